@@ -16,6 +16,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fs/accounting"
 	"github.com/ncw/rclone/fs/config"
 	"github.com/ncw/rclone/fs/config/flags"
 	"github.com/ncw/rclone/fs/hash"
@@ -594,10 +595,12 @@ func (o *Object) Hash(r hash.Type) (string, error) {
 
 	if !o.modTime.Equal(oldtime) || oldsize != o.size || hashes == nil {
 		hashes = make(map[hash.Type]string)
-		in, err := os.Open(o.path)
+		fd, err := os.Open(o.path)
 		if err != nil {
 			return "", errors.Wrap(err, "hash: failed to open")
 		}
+		// Account the reads as a check so they show in the stats
+		in := accounting.NewAccountCheck(fd, o.size, o.remote)
 		hashes, err = hash.Stream(in)
 		closeErr := in.Close()
 		if err != nil {
@@ -322,7 +322,7 @@ func Run(Retry bool, showStats bool, cmd *cobra.Command, f func() error) {
 		resolveExitCode(err)
 	}
 	if showStats && (accounting.Stats.Errored() || *statsInterval > 0) {
-		accounting.Stats.Log()
+		accounting.AggregateStats().Log()
 	}
 	fs.Debugf(nil, "%d go routines active\n", runtime.NumGoroutine())
 
@@ -370,13 +370,16 @@ func CheckArgs(MinArgs, MaxArgs int, cmd *cobra.Command, args []string) {
 // It returns a channel which should be closed to stop the stats.
 func StartStats() chan struct{} {
 	stopStats := make(chan struct{})
+	if fs.Config.StatsOneLine {
+		accounting.StartStatsSignalHandler()
+	}
 	if *statsInterval > 0 {
 		go func() {
 			ticker := time.NewTicker(*statsInterval)
 			for {
 				select {
 				case <-ticker.C:
-					accounting.Stats.Log()
+					accounting.AggregateStats().Log()
 				case <-stopStats:
 					ticker.Stop()
 					return
@@ -2,17 +2,96 @@
 package accounting
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"math"
+	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VividCortex/ewma"
+	"github.com/mattn/go-runewidth"
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/rclone/fs/asyncreader"
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/ncw/rclone/fs/hash"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
 )
 
+// ErrorTransferStalled is returned from Read when the transfer has
+// been aborted because it was slower than --min-speed for
+// --min-speed-time.  It is a retry error so the transfer is retried.
+var ErrorTransferStalled = fserrors.RetryErrorf("transfer stalled: slower than --min-speed")
+
+// ErrorTransferAborted is returned from Read when the transfer has
+// been aborted with AbortTransfer or Abort.
+var ErrorTransferAborted = errors.New("transfer aborted")
+
+// ErrorMaxTransferLimitReached is returned from Read (and Write) once
+// --max-transfer bytes have been transferred.  It is a fatal error so
+// the sync stops starting new transfers.
+var ErrorMaxTransferLimitReached = fserrors.FatalError(errors.New("max transfer limit reached as set by --max-transfer"))
+
+// ReadError is returned from Read when reading the underlying stream
+// fails.  It records how far through the transfer the error happened
+// which is useful for diagnosing partial transfers.
+//
+// io.EOF and io.ErrUnexpectedEOF are returned as they are so they can
+// still be compared with.
+type ReadError struct {
+	Name   string // name of the transfer
+	Offset int64  // bytes read by the transfer when the error happened
+	Err    error  // the error from the stream
+}
+
+// Error returns the error as a string
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("%s: read failed at offset %d: %v", e.Name, e.Offset, e.Err)
+}
+
+// Cause returns the underlying error for errors.Cause
+func (e *ReadError) Cause() error {
+	return e.Err
+}
+
+// MaxBytesError is returned from Read when the stream has more data
+// than the limit set with SetMaxBytes or WithSizeLimit.
+type MaxBytesError struct {
+	Name string // name of the transfer
+	Max  int64  // the limit which was exceeded
+}
+
+// Error returns the error as a string
+func (e *MaxBytesError) Error() string {
+	return fmt.Sprintf("%s: read more than the limit of %d bytes", e.Name, e.Max)
+}
+
+// IdleTimeoutError is returned from Read (and Write) when the
+// transfer was aborted because no data was transferred for the time
+// set with SetIdleTimeout.  It is a retry error so the transfer is
+// retried.
+type IdleTimeoutError struct {
+	Name    string        // name of the transfer
+	Timeout time.Duration // the idle timeout which was exceeded
+}
+
+// Error returns the error as a string
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("%s: transfer timed out: no data transferred for %v", e.Name, e.Timeout)
+}
+
+// Retry returns true so the transfer is retried - see fserrors.Retrier
+func (e *IdleTimeoutError) Retry() bool {
+	return true
+}
+
 // Account limits and accounts for one transfer
+//
+// It can either wrap an io.ReadCloser (see NewAccount) or an
+// io.WriteCloser (see NewAccountWriter).
 type Account struct {
 	// The mutex is to make sure Read() and Close() aren't called
 	// concurrently.  Unfortunately the persistent connection loop
@@ -22,164 +101,1667 @@ type Account struct {
 	mu      sync.Mutex
 	in      io.Reader
 	origIn  io.ReadCloser
+	out     io.Writer // set if accounting writes rather than reads
 	close   io.Closer
 	size    int64
-	name    string
+	name    string             // guarded by statmu - see SetName
+	stats   *StatsInfo         // stats this transfer is accounted in
 	statmu  sync.Mutex         // Separate mutex for stat values.
 	bytes   int64              // Total number of bytes read
+	already int64              // bytes present before the transfer set by SetAlreadyDone
+	wire    int64              // bytes on the wire if set by AddServerSideBytes
 	start   time.Time          // Start time of first read
+	created time.Time          // time the Account was made or Start was called
+	firstAt time.Time          // time the first byte was transferred
+	resume  chan struct{}      // set while paused - closed by Resume
+	pauseAt time.Time          // time Pause was called if paused
 	lpTime  time.Time          // Time of last average measurement
 	lpBytes int                // Number of bytes read since last measurement
+	lpLast  time.Time          // Time of the last measurement with bytes read
+	lpSpeed float64            // Speed during the last measurement
 	avg     ewma.MovingAverage // Moving average of last few measurements
-	closed  bool               // set if the file is closed
+	window  time.Duration      // window of avg set by SetAverageWindow - 0 for default
+	closed  bool               // set if the file is closed - set with mu and statmu held
 	exit    chan struct{}      // channel that will be closed when transfer is finished
+	exitMu  sync.Once          // makes sure the transfer is only finished once
+	err     error              // set if the transfer was cancelled - returned by Read
 	withBuf bool               // is using a buffered in
+	limiter *rate.Limiter      // per transfer bandwidth limiter - nil if unlimited
+	share   *rate.Limiter      // fair share of the global bandwidth limit - nil if none
+	weight  int                // weight of the share set by SetWeight - < 1 means 1
+	dir     Direction          // which way the data flows set by WithDirection
+	retries int                // number of times the transfer has been retried
+	progFn  ProgressFn         // called after every accounted read if set
+	group   *AccountGroup      // group this is part of if set
+	ticks   int                // number of average ticks since the start
+	slowAt  time.Time          // time the speed went below --min-speed
+	hashes  hash.Set           // types of hashes being calculated - guarded by statmu
+	hasher  *hash.MultiHasher  // calculates the hashes if set - guarded by statmu
+	hashEnd bool               // set if all the data has been hashed - guarded by statmu
+	strLast string             // last line made by StringThrottled - guarded by statmu
+	strAt   time.Time          // when strLast was made - guarded by statmu
+	idle    time.Duration      // idle timeout set by SetIdleTimeout - 0 for none
+	idleRun bool               // set if watchIdle is running
+	lastAt  time.Time          // time bytes were last transferred for the idle timeout
+	history speedHistory       // speed at each of the last few average ticks
+	remote  *remoteBucket      // bandwidth limit set by WithBwLimitRemote - guarded by statmu
+	maxRead int64              // max bytes which may be read set by SetMaxBytes - 0 for no limit
+	bufFull int                // ticks in a row the async buffer was full - see _tuneBuffers
+	bufLow  int                // ticks in a row the async buffer was empty - see _tuneBuffers
+	full    int64              // size of the object if this is a range of it - 0 if not a range
+	check   bool               // set if reading for a check rather than a transfer - see NewAccountCheck
+	latency *histogram         // seconds each read of in took if --stats-read-latency - contents guarded by statmu
+	id      string             // unique ID of the Account - see ID
+	first   string             // name the Account was made with before any SetName - see stringSet
+	started bool               // set by the first read or write and not cleared by ResetStats - see checkMaxTransfer
+	fsName  string             // name of the remote of the transfer if known - guarded by statmu
+
+	// async buffer if WithBuffer added one - guarded by statmu
+	asyncIn *asyncreader.AsyncReader
+}
+
+// Direction is which way the data of a transfer flows between rclone
+// and the remotes.  The zero value means it isn't known.
+type Direction int
+
+// Directions of the data of a transfer - these may be combined
+const (
+	Download Direction = 1 << iota // data is read from a remote
+	Upload                         // data is written to a remote
+)
+
+// isLocal returns true if f is the local disk
+func isLocal(f fs.Info) bool {
+	name := f.Name()
+	return name == "local" || fs.ConfigFileGet(name, "type") == "local"
+}
+
+// TransferDirection returns the Direction of data copied from src to
+// dst.  Either may be nil if the data comes from or goes to rclone
+// itself, eg from stdin.  Copying from one remote to another is both
+// a Download and an Upload.
+func TransferDirection(src, dst fs.Info) (dir Direction) {
+	if src != nil && !isLocal(src) {
+		dir |= Download
+	}
+	if dst != nil && !isLocal(dst) {
+		dir |= Upload
+	}
+	return dir
 }
 
+// ProgressFn is called with the number of bytes accounted by a read
+// and the total number of bytes accounted so far
+type ProgressFn func(bytesThisRead int, totalBytes int64)
+
 // NewAccountSizeName makes a Account reader for an io.ReadCloser of
 // the given size and name
 func NewAccountSizeName(in io.ReadCloser, size int64, name string) *Account {
+	return NewAccountSizeNameContext(context.Background(), in, size, name)
+}
+
+// NewAccountSizeNameContext makes a Account reader for an
+// io.ReadCloser of the given size and name which is cancelled when
+// ctx is.
+//
+// When ctx is cancelled the underlying reader is closed so any Read
+// in progress returns promptly, and Read returns ctx.Err() from then
+// on.
+func NewAccountSizeNameContext(ctx context.Context, in io.ReadCloser, size int64, name string) *Account {
 	acc := &Account{
+		stats:  StatsFromContext(ctx),
 		in:     in,
 		close:  in,
 		origIn: in,
 		size:   size,
 		name:   name,
-		exit:   make(chan struct{}),
-		avg:    ewma.NewMovingAverage(),
-		lpTime: time.Now(),
 	}
-	go acc.averageLoop()
-	Stats.inProgress.set(acc.name, acc)
+	acc.init()
+	acc.watchContext(ctx)
+	return acc
+}
+
+// NewAccountSizeNameRange makes a Account reader for a range read of
+// an object, eg a ranged GET.  in is rangeLen bytes long and is part
+// of an object fullSize bytes long (-1 if unknown).
+//
+// The progress, percentage and ETA are measured against rangeLen.
+// Read the size of the whole object with FullSize.
+func NewAccountSizeNameRange(in io.ReadCloser, rangeLen, fullSize int64, name string) *Account {
+	acc := NewAccountSizeName(in, rangeLen, name)
+	if fullSize == 0 {
+		fullSize = -1
+	}
+	acc.full = fullSize
+	return acc
+}
+
+// NewAccountCheck makes a Account reader for an io.ReadCloser of the
+// given size and name which is read for a check rather than a
+// transfer, eg to hash a file to compare it with another.
+//
+// Its bytes are counted in the checked bytes of the stats, not the
+// bytes transferred, and it is shown with the checks in progress.  It
+// isn't limited by the global bandwidth limits unless
+// --bwlimit-checks is set, nor by --max-transfer, and it isn't
+// reported to OnTransferComplete or the transfer log.
+func NewAccountCheck(in io.ReadCloser, size int64, name string) *Account {
+	acc := &Account{
+		stats:  Stats,
+		in:     in,
+		close:  in,
+		origIn: in,
+		size:   size,
+		name:   name,
+		check:  true,
+	}
+	acc.init()
+	return acc
+}
+
+// NewAccount makes a Account reader for an object
+func NewAccount(in io.ReadCloser, obj fs.Object) *Account {
+	return NewAccountContext(context.Background(), in, obj)
+}
+
+// NewAccountContext makes a Account reader for an object which is
+// accounted in the StatsInfo set on ctx with WithStats and cancelled
+// when ctx is
+func NewAccountContext(ctx context.Context, in io.ReadCloser, obj fs.Object) *Account {
+	acc := NewAccountSizeNameContext(ctx, in, obj.Size(), obj.Remote())
+	if f := obj.Fs(); f != nil {
+		acc.WithRemoteName(f.Name())
+	}
+	return acc
+}
+
+// NewAccountWriter makes an Account writer for an io.WriteCloser of
+// the given size and name.
+//
+// Bytes written to the Account are passed on to out and accounted in
+// the same way as bytes read from a reader Account. Use a size of -1
+// if the size is unknown.
+func NewAccountWriter(out io.WriteCloser, size int64, name string) *Account {
+	return NewAccountWriterContext(context.Background(), out, size, name)
+}
+
+// NewAccountWriterContext makes an Account writer for an
+// io.WriteCloser of the given size and name which is cancelled when
+// ctx is.
+//
+// When ctx is cancelled out is closed so any Write in progress
+// returns promptly, and Write returns ctx.Err() from then on.
+func NewAccountWriterContext(ctx context.Context, out io.WriteCloser, size int64, name string) *Account {
+	acc := &Account{
+		stats: StatsFromContext(ctx),
+		out:   out,
+		close: out,
+		size:  size,
+		name:  name,
+	}
+	acc.init()
+	acc.watchContext(ctx)
+	return acc
+}
+
+// Limits for fs.Config.StatsAvgWindow
+const (
+	minAvgWindow = time.Second
+	maxAvgWindow = time.Hour
+)
+
+// newMovingAverage makes a moving average for the speed with the
+// window set in fs.Config.StatsAvgWindow.
+func newMovingAverage() ewma.MovingAverage {
+	return newMovingAverageWindow(fs.Config.StatsAvgWindow)
+}
+
+// newMovingAverageWindow makes a moving average for the speed over
+// window.
+//
+// The averages are updated every tickInterval() so the window is
+// converted into the age in ticks of the moving average.  It is
+// clamped to sane bounds and the ewma default is used if it is not
+// set.
+func newMovingAverageWindow(window time.Duration) ewma.MovingAverage {
+	if window <= 0 {
+		return ewma.NewMovingAverage()
+	}
+	if window < minAvgWindow {
+		window = minAvgWindow
+	} else if window > maxAvgWindow {
+		window = maxAvgWindow
+	}
+	return ewma.NewMovingAverage(float64(window) / float64(tickInterval()))
+}
+
+// SetAverageWindow sets the window the moving average of the speed is
+// taken over for this transfer, overriding fs.Config.StatsAvgWindow,
+// and restarts the average.  0 means use the default.
+//
+// The averages are still updated at the interval set by
+// fs.Config.StatsAvgWindow.
+func (acc *Account) SetAverageWindow(window time.Duration) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.window = window
+	if acc.avg != nil {
+		acc.avg = acc._newMovingAverage()
+	}
+}
+
+// _newMovingAverage makes a moving average with the window for this
+// Account - call with statmu held
+func (acc *Account) _newMovingAverage() ewma.MovingAverage {
+	if acc.window > 0 {
+		return newMovingAverageWindow(acc.window)
+	}
+	return newMovingAverage()
+}
+
+// init sets up the stats for a new Account, starts the averaging and
+// marks it as in progress
+//
+// If fs.Config.StatsLight is set there is no moving average and the
+// Account isn't registered with the averager so the current speed is
+// the average speed since the start.
+func (acc *Account) init() {
+	acc.exit = make(chan struct{})
+	acc.lpTime = clk.Now()
+	acc.created = acc.lpTime
+	if !fs.Config.StatsLight {
+		acc.avg = newMovingAverage()
+		acc.history = newSpeedHistory(fs.Config.StatsSpeedHistory)
+		averages.add(acc)
+	}
+	if fs.Config.StatsReadLatency {
+		acc.latency = newHistogram(latencyBounds)
+	}
+	acc.id = newAccountID()
+	acc.first = acc.name
+	acc.inProgress().set(acc)
+	acc.stats.pauseIfPaused(acc)
+	if !acc.check {
+		transferStarted(acc.name, acc.size)
+	}
+}
+
+// lastAccountID is the number of the last ID given to an Account by
+// newAccountID - use atomic
+var lastAccountID int64
+
+// newAccountID returns a new unique ID for an Account
+func newAccountID() string {
+	return fmt.Sprintf("#%d", atomic.AddInt64(&lastAccountID, 1))
+}
+
+// lessID returns true if the Account ID a was given out before b.  No
+// ID, as in a placeholder for a transfer which hasn't been opened yet,
+// sorts last.
+func lessID(a, b string) bool {
+	if a == "" || b == "" {
+		return b == "" && a != ""
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// inProgress returns where the Account is kept while it is in
+// progress - the checks or the transfers in progress of its stats
+func (acc *Account) inProgress() *inProgress {
+	if acc.check {
+		return acc.stats.checkProg
+	}
+	return acc.stats.inProgress
+}
+
+// watchContext cancels the transfer if ctx is cancelled before the
+// transfer finishes
+func (acc *Account) watchContext(ctx context.Context) {
+	if ctx.Done() == nil {
+		// can never be cancelled
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			acc.cancel(ctx.Err())
+		case <-acc.exit:
+		}
+	}()
+}
+
+// finish marks the transfer as finished with err, stopping the
+// averaging, removing it from the in progress transfers and telling
+// the subscribers to OnTransferComplete.  It is safe to call
+// more than once - only the first call has any effect.
+func (acc *Account) finish(err error) {
+	acc.exitMu.Do(func() {
+		close(acc.exit)
+		averages.remove(acc)
+		acc.inProgress().remove(acc)
+		if acc.check {
+			// Checks aren't transfers so aren't reported
+			return
+		}
+		acc.statmu.Lock()
+		group := acc.group
+		snapshot := acc._transferSnapshot(err)
+		acc.statmu.Unlock()
+		acc.stats.doneAccount(snapshot)
+		if group != nil {
+			group.remove(acc)
+		}
+		acc.stats.transferComplete(snapshot)
+		logTransfer(snapshot)
+		transferEnded(snapshot.Name, snapshot.Bytes, snapshot.Error)
+	})
+}
+
+// Finish marks the transfer as finished with err, or nil if it was
+// successful, without closing it.  Use this to report the result of
+// the transfer to OnTransferComplete - Close reports the error
+// from closing the stream otherwise.
+//
+// The Account should still be closed.
+func (acc *Account) Finish(err error) {
+	acc.finish(err)
+}
+
+// SetIdleTimeout aborts the transfer if no data is transferred for
+// d.  Transfers which are making progress, however slowly, aren't
+// aborted.  0 means no idle timeout.
+//
+// When it fires the underlying stream is closed so a Read blocked in
+// it returns, and Read returns an *IdleTimeoutError from then on.
+//
+// Progress is measured where the data is read from the Account, not
+// where the async buffer fills, so a full buffer doesn't hide a dead
+// connection.  The idle time is counted from when this is called and
+// the time paused isn't counted.
+func (acc *Account) SetIdleTimeout(d time.Duration) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.idle = d
+	if d <= 0 {
+		return
+	}
+	acc.lastAt = clk.Now()
+	if acc.idleRun {
+		return
+	}
+	acc.idleRun = true
+	go acc.watchIdle()
+}
+
+// watchIdle cancels the transfer if it is idle for longer than the
+// idle timeout, until the transfer finishes or the timeout is removed
+func (acc *Account) watchIdle() {
+	for {
+		acc.statmu.Lock()
+		d := acc.idle
+		if d <= 0 {
+			acc.idleRun = false
+			acc.statmu.Unlock()
+			return
+		}
+		wait := d
+		if acc.resume == nil {
+			wait = acc.lastAt.Add(d).Sub(clk.Now())
+		}
+		name := acc.name
+		acc.statmu.Unlock()
+		if wait <= 0 {
+			fs.Errorf(name, "Aborting transfer: no data transferred for %v", d)
+			acc.cancel(&IdleTimeoutError{Name: name, Timeout: d})
+			return
+		}
+		timer := clk.NewTimer(wait)
+		select {
+		case <-timer.Chan():
+		case <-acc.exit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Name returns the name of the transfer
+func (acc *Account) Name() string {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.name
+}
+
+// SetName changes the name of the transfer, eg to show which phase of
+// a multi-phase operation it is in.  The transfer is renamed in the
+// transfers in progress at the same time so it is always found under
+// one name or the other.
+func (acc *Account) SetName(name string) {
+	acc.inProgress().rename(acc, name)
+}
+
+// ID returns the ID of the Account, eg "#42", which is unique in the
+// process unlike the name.  Use this to refer to the transfer, eg with
+// AbortTransfer, if more than one transfer may have the same name.
+func (acc *Account) ID() string {
+	return acc.id
+}
+
+// WithRemoteName sets the name of the remote of the transfer.  This
+// is shown before the name, as in "remote:path/to/file", when another
+// transfer in progress has the same name.  NewAccount sets it from the
+// Fs of the object.
+func (acc *Account) WithRemoteName(name string) *Account {
+	acc.statmu.Lock()
+	acc.fsName = name
+	acc.statmu.Unlock()
+	return acc
+}
+
+// WithDirection sets which way the data of the transfer flows so its
+// bytes are counted as uploaded and/or downloaded in the stats.
+func (acc *Account) WithDirection(dir Direction) *Account {
+	acc.statmu.Lock()
+	acc.dir = dir
+	acc.statmu.Unlock()
+	return acc
+}
+
+// SetMaxBytes makes Read return a *MaxBytesError if the stream has
+// more than n bytes, counting any set by SetAlreadyDone.  The bytes up
+// to n are returned and the transfer is aborted with the error so the
+// stream is closed.  Use this to guard against a remote sending
+// unbounded data.  n <= 0 means no limit.
+func (acc *Account) SetMaxBytes(n int64) {
+	acc.statmu.Lock()
+	acc.maxRead = n
+	acc.statmu.Unlock()
+}
+
+// WithSizeLimit limits the bytes which may be read to the size of the
+// transfer with SetMaxBytes if the size is known and positive.  It
+// uses the size at the time it is called.
+func (acc *Account) WithSizeLimit() *Account {
+	acc.statmu.Lock()
+	if acc.size > 0 {
+		acc.maxRead = acc.size
+	}
+	acc.statmu.Unlock()
 	return acc
 }
 
-// NewAccount makes a Account reader for an object
-func NewAccount(in io.ReadCloser, obj fs.Object) *Account {
-	return NewAccountSizeName(in, obj.Size(), obj.Remote())
+// limitMaxBytes returns how many of n bytes just read may be kept
+// under the limit set by SetMaxBytes, and a *MaxBytesError if that
+// is fewer than n.
+func (acc *Account) limitMaxBytes(n int) (int, error) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.maxRead <= 0 {
+		return n, nil
+	}
+	left := acc.maxRead - acc.bytes - acc.already
+	if int64(n) <= left {
+		return n, nil
+	}
+	if left < 0 {
+		left = 0
+	}
+	return int(left), &MaxBytesError{Name: acc.name, Max: acc.maxRead}
+}
+
+// SetSize sets the size of the transfer, use this if it wasn't known
+// when the Account was made (pass -1) but has been found out since,
+// eg from a Content-Length which arrived late.  The stats show the
+// percentage done and the ETA from then on.
+func (acc *Account) SetSize(size int64) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.size = size
+}
+
+// FullSize returns the size of the whole object if the Account was
+// made with NewAccountSizeNameRange, which is -1 if unknown, and the
+// size of the transfer otherwise.
+func (acc *Account) FullSize() int64 {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.full != 0 {
+		return acc.full
+	}
+	return acc.size
+}
+
+// IsRange returns true if the Account was made with
+// NewAccountSizeNameRange so it is transferring part of an object.
+func (acc *Account) IsRange() bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.full != 0
+}
+
+// SetAlreadyDone sets the number of bytes of the transfer which were
+// already present before it started, eg when resuming a partial
+// download.  They count towards the percentage done and the ETA but
+// not towards the bytes transferred or the speed.
+//
+// It returns an error if bytes is negative or more than the size.
+func (acc *Account) SetAlreadyDone(bytes int64) error {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if bytes < 0 {
+		return errors.Errorf("already done bytes %d must not be negative", bytes)
+	}
+	if acc.size >= 0 && bytes > acc.size {
+		return errors.Errorf("already done bytes %d is more than the size %d", bytes, acc.size)
+	}
+	acc.already = bytes
+	return nil
+}
+
+// Start sets the time the transfer started, which the time to first
+// byte is measured from, to now.  This is set when the Account is
+// made so only call it if the transfer starts some time later.
+//
+// It has no effect once the first byte has been transferred.
+func (acc *Account) Start() {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.firstAt.IsZero() {
+		acc.created = clk.Now()
+	}
+}
+
+// TimeToFirstByte returns the time from the start of the transfer
+// (see Start) to the first byte being transferred.  ok is false until
+// the first byte has been transferred.
+func (acc *Account) TimeToFirstByte() (ttfb time.Duration, ok bool) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.firstAt.IsZero() {
+		return 0, false
+	}
+	return acc._firstByte(), true
+}
+
+// _firstByte returns the time from the start of the transfer to the
+// first byte or 0 if there hasn't been one yet - call with statmu held
+func (acc *Account) _firstByte() time.Duration {
+	if acc.firstAt.IsZero() {
+		return 0
+	}
+	return acc.firstAt.Sub(acc.created)
+}
+
+// Done returns a channel which is closed when the transfer finishes,
+// that is when it is closed, cancelled or Finish is called.  It
+// returns the same channel every time and is safe to call at any
+// time.
+func (acc *Account) Done() <-chan struct{} {
+	return acc.exit
+}
+
+// _transferSnapshot returns a TransferSnapshot of the transfer
+// finishing with err - call with statmu held
+func (acc *Account) _transferSnapshot(err error) TransferSnapshot {
+	s := TransferSnapshot{
+		ID:    acc.id,
+		Name:  acc.name,
+		Size:  acc.size,
+		Bytes: acc.bytes,
+		Start: acc.start,
+		Error: err,
+
+		Retries: acc.retries,
+	}
+	if err == nil && acc.hasher != nil && acc.hashEnd && acc.err == nil {
+		s.Hashes = acc.hasher.Sums()
+	}
+	s.FirstByte = acc._firstByte()
+	if !acc.start.IsZero() {
+		s.Duration = acc._elapsed(clk.Now())
+		if s.Duration > 0 {
+			s.AverageSpeed = float64(acc.bytes) / s.Duration.Seconds()
+		}
+	}
+	return s
+}
+
+// cancel the transfer with err.  This finishes the transfer and
+// closes the underlying stream so that any Read blocked in it
+// returns.  All subsequent Reads will return err.
+//
+// This doesn't take acc.mu as a Read may be holding it.
+func (acc *Account) cancel(err error) {
+	acc.statmu.Lock()
+	if acc.err != nil {
+		acc.statmu.Unlock()
+		return
+	}
+	acc.err = err
+	var stream io.Closer = acc.origIn
+	if acc.origIn == nil {
+		stream = acc.close
+	}
+	acc.statmu.Unlock()
+	acc.finish(err)
+	closeErr := stream.Close()
+	if closeErr != nil {
+		fs.Debugf(acc.Name(), "Failed to close cancelled transfer: %v", closeErr)
+	}
+}
+
+// Abort aborts the transfer with err, or ErrorTransferAborted if err
+// is nil.  If the transfer has already been aborted this does nothing.
+//
+// The underlying stream is closed so a Read blocked in it returns,
+// the transfer is finished with err and is no longer in progress, and
+// all Reads return err from then on rather than the error from the
+// closed stream.  Use Err to find out why a transfer failed.
+func (acc *Account) Abort(err error) {
+	if err == nil {
+		err = ErrorTransferAborted
+	}
+	acc.cancel(err)
+}
+
+// Err returns the error the transfer was aborted with, by Abort, or
+// because it stalled or was idle for too long, or nil if it wasn't.
+func (acc *Account) Err() error {
+	return acc.cancelled()
+}
+
+// cancelled returns the error the transfer was cancelled with or nil
+func (acc *Account) cancelled() error {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.err
+}
+
+// checkMaxTransfer returns ErrorMaxTransferLimitReached if the transfer
+// mustn't carry on because --max-transfer bytes have been transferred,
+// or ErrorByteBudgetExceeded if the budget set with SetByteBudget has
+// been used up.
+//
+// With --max-transfer-mode soft transfers which have already started
+// may finish.
+func (acc *Account) checkMaxTransfer() error {
+	if acc.check {
+		return nil
+	}
+	if err := checkByteBudget(); err != nil {
+		return err
+	}
+	if fs.Config.MaxTransfer < 0 || acc.stats.GetBytes() < int64(fs.Config.MaxTransfer) {
+		return nil
+	}
+	if fs.Config.MaxTransferMode == "soft" {
+		acc.statmu.Lock()
+		started := acc.started
+		acc.statmu.Unlock()
+		if started {
+			return nil
+		}
+	}
+	return ErrorMaxTransferLimitReached
+}
+
+// WithBuffer - If the file is above a certain size it adds an Async reader
+//
+// It does nothing for writer Accounts.
+func (acc *Account) WithBuffer() *Account {
+	if acc.origIn == nil {
+		return acc
+	}
+	acc.withBuf = true
+	acc.statmu.Lock()
+	size := acc.size
+	acc.statmu.Unlock()
+	var buffers int
+	if size >= int64(fs.Config.BufferSize) || size == -1 {
+		buffers = int(int64(fs.Config.BufferSize) / asyncreader.BufferSize)
+	} else {
+		buffers = int(size / asyncreader.BufferSize)
+	}
+	// On big files add a buffer
+	if buffers > 0 {
+		var (
+			rc  *asyncreader.AsyncReader
+			err error
+		)
+		if fs.Config.BufferAuto {
+			start := buffers
+			if start > bufferAutoStart {
+				start = bufferAutoStart
+			}
+			rc, err = asyncreader.NewGrowable(acc.origIn, start, maxBuffers(size, buffers))
+		} else {
+			rc, err = asyncreader.New(acc.origIn, buffers)
+		}
+		if err == asyncreader.ErrorMemoryLimit {
+			fs.Debugf(acc.Name(), "Not buffering: %v", err)
+		} else if err != nil {
+			fs.Errorf(acc.Name(), "Failed to make buffer: %v", err)
+		} else {
+			acc.in = rc
+			acc.close = rc
+			acc.statmu.Lock()
+			acc.asyncIn = rc
+			acc.statmu.Unlock()
+		}
+	}
+	return acc
+}
+
+// Tuning for fs.Config.BufferAuto
+const (
+	bufferAutoStart = 2   // number of buffers to start with
+	bufferAutoDelay = 5   // ticks after the start before tuning the buffers
+	bufferAutoTicks = 3   // ticks the buffer must be full or empty for before changing it
+	bufferAutoFull  = 0.9 // the buffer is full if at least this fraction is in use
+	bufferAutoEmpty = 0.1 // the buffer is empty if at most this fraction is in use
+	bufferAutoMax   = 4   // use at most this many times --buffer-size
+)
+
+// maxBuffers returns the most buffers fs.Config.BufferAuto may grow
+// the async buffer to for a file of size if it starts with buffers
+func maxBuffers(size int64, buffers int) int {
+	max := int64(bufferAutoMax) * int64(fs.Config.BufferSize)
+	if size >= 0 && size < max {
+		max = size
+	}
+	maxBuffers := int(max / asyncreader.BufferSize)
+	if maxBuffers < buffers {
+		maxBuffers = buffers
+	}
+	return maxBuffers
+}
+
+// _tuneBuffers changes the number of async buffers according to how
+// full they are - call with statmu held
+func (acc *Account) _tuneBuffers() {
+	if !fs.Config.BufferAuto || acc.asyncIn == nil || acc.ticks < bufferAutoDelay {
+		return
+	}
+	acc._tuneBuffersFill(acc.asyncIn.Buffered())
+}
+
+// _tuneBuffersFill doubles the number of async buffers if they have
+// been full for bufferAutoTicks, as the source is faster than the
+// destination and more read ahead will help, and halves it if they
+// have been empty for that long, as the buffers aren't being used.
+//
+// buffered and capacity are as returned by Buffered - call with
+// statmu held
+func (acc *Account) _tuneBuffersFill(buffered, capacity int64) {
+	switch {
+	case capacity > 0 && float64(buffered) >= bufferAutoFull*float64(capacity):
+		acc.bufFull++
+		acc.bufLow = 0
+	case float64(buffered) <= bufferAutoEmpty*float64(capacity):
+		acc.bufLow++
+		acc.bufFull = 0
+	default:
+		acc.bufFull, acc.bufLow = 0, 0
+	}
+	have := acc.asyncIn.Buffers()
+	if acc.bufFull >= bufferAutoTicks {
+		acc.bufFull = 0
+		if got := acc.asyncIn.SetBuffers(2 * have); got != have {
+			fs.Debugf(acc.name, "Increased buffers from %d to %d", have, got)
+		}
+	} else if acc.bufLow >= bufferAutoTicks {
+		acc.bufLow = 0
+		if got := acc.asyncIn.SetBuffers(have / 2); got != have {
+			fs.Debugf(acc.name, "Decreased buffers from %d to %d", have, got)
+		}
+	}
+}
+
+// WithHash calculates the hashes in the set of all the data read from
+// (or written to) the Account.  Read the hashes with Sums when the
+// transfer is complete, or with Hashes to find out whether it
+// is.
+//
+// The data is hashed as it is read from the Account, so any data
+// read ahead by WithBuffer but not read isn't hashed.
+func (acc *Account) WithHash(hashes hash.Set) (*Account, error) {
+	hasher, err := hash.NewMultiHasherTypes(hashes)
+	if err != nil {
+		return acc, err
+	}
+	acc.statmu.Lock()
+	acc.hashes = hashes
+	acc.hasher = hasher
+	acc.statmu.Unlock()
+	return acc, nil
+}
+
+// hash adds p to the hashes being calculated if any.  Set end if p
+// is the last of the data.
+func (acc *Account) hash(p []byte, end bool) {
+	acc.statmu.Lock()
+	if acc.hasher != nil {
+		_, _ = acc.hasher.Write(p)
+		acc.hashEnd = acc.hashEnd || end
+	}
+	acc.statmu.Unlock()
+}
+
+// resetHash starts the hashes being calculated again
+func (acc *Account) resetHash() {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.hasher == nil {
+		return
+	}
+	hasher, err := hash.NewMultiHasherTypes(acc.hashes)
+	if err != nil {
+		fs.Errorf(acc.name, "Failed to reset hashes: %v", err)
+		return
+	}
+	acc.hasher = hasher
+	acc.hashEnd = false
+}
+
+// Sums returns the hashes of the data transferred so far as set up
+// by WithHash, or nil if no hashes are being calculated
+func (acc *Account) Sums() map[hash.Type]string {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.hasher == nil {
+		return nil
+	}
+	return acc.hasher.Sums()
+}
+
+// Hashes returns the hashes of the data transferred so far as set up
+// by WithHash, or nil if no hashes are being calculated.
+//
+// complete is only set if the hashes are of all the data, that is
+// the stream has been read to the end, or the writer Account has
+// been closed, and the transfer wasn't cancelled.  The hashes of a
+// transfer which was aborted part way are of the partial data.
+func (acc *Account) Hashes() (sums map[hash.Type]string, complete bool) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.hasher == nil {
+		return nil, false
+	}
+	return acc.hasher.Sums(), acc.hashEnd && acc.err == nil
+}
+
+// GetReader returns the underlying io.ReadCloser under any Buffer
+func (acc *Account) GetReader() io.ReadCloser {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return acc.origIn
+}
+
+// GetBufferedReader returns an io.ReadCloser which reads from the
+// stream the Account reads from, that is from the async buffer if
+// WithBuffer added one or the underlying io.ReadCloser otherwise.  It
+// returns nil for writer Accounts.
+//
+// Reads from it take the Account's lock so they are never concurrent
+// with Reads of the Account and they carry on working if the stream
+// is replaced by UpdateReader.  They aren't accounted.  Closing it
+// closes the Account.
+func (acc *Account) GetBufferedReader() io.ReadCloser {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	if acc.origIn == nil {
+		return nil
+	}
+	return bufferedReader{acc: acc}
+}
+
+// bufferedReader reads from the stream the Account reads from
+type bufferedReader struct {
+	acc *Account
+}
+
+// Read from the stream the Account reads from - see io.Reader
+func (r bufferedReader) Read(p []byte) (n int, err error) {
+	r.acc.mu.Lock()
+	defer r.acc.mu.Unlock()
+	return r.acc.in.Read(p)
+}
+
+// Close the Account
+func (r bufferedReader) Close() error {
+	return r.acc.Close()
+}
+
+// StopBuffering stops the async buffer doing any more buffering
+func (acc *Account) StopBuffering() {
+	if asyncIn, ok := acc.in.(*asyncreader.AsyncReader); ok {
+		asyncIn.Abandon()
+	}
+	acc.statmu.Lock()
+	acc.asyncIn = nil
+	acc.statmu.Unlock()
+}
+
+// Buffered returns the number of bytes which have been read ahead
+// into the async buffer but not yet read from the Account and the
+// capacity of the buffer.  ok is false if there is no async buffer.
+func (acc *Account) Buffered() (buffered, capacity int64, ok bool) {
+	acc.statmu.Lock()
+	asyncIn := acc.asyncIn
+	acc.statmu.Unlock()
+	if asyncIn == nil {
+		return 0, 0, false
+	}
+	buffered, capacity = asyncIn.Buffered()
+	return buffered, capacity, true
+}
+
+// IsBuffered returns true if WithBuffer added an async buffer to the
+// Account.  It doesn't for small files or if the buffer couldn't be
+// made, eg because --buffer-memory was used up.
+func (acc *Account) IsBuffered() bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.asyncIn != nil
+}
+
+// Buffers returns the number of buffers of asyncreader.BufferSize
+// the async buffer is using, or 0 if the Account isn't buffered.  This
+// may grow or shrink during the transfer with --buffer-auto.
+func (acc *Account) Buffers() int {
+	acc.statmu.Lock()
+	asyncIn := acc.asyncIn
+	acc.statmu.Unlock()
+	if asyncIn == nil {
+		return 0
+	}
+	return asyncIn.Buffers()
+}
+
+// UpdateReader updates the underlying io.ReadCloser stopping the
+// asynb buffer (if any) and re-adding it
+//
+// Any hashes being calculated with WithHash are reset as the data
+// from the new reader can't be added to them.
+func (acc *Account) UpdateReader(in io.ReadCloser) {
+	acc.mu.Lock()
+	acc.StopBuffering()
+	acc.in = in
+	acc.close = in
+	acc.statmu.Lock()
+	acc.origIn = in
+	acc.statmu.Unlock()
+	acc.WithBuffer()
+	acc.resetHash()
+	acc.mu.Unlock()
+}
+
+// RetryReader updates the underlying io.ReadCloser like UpdateReader
+// for a retry of the transfer from the start.  The stats for the
+// transfer are reset as with ResetStats and the attempt is counted in
+// Attempts.
+func (acc *Account) RetryReader(in io.ReadCloser) {
+	acc.UpdateReader(in)
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.retries++
+	acc._resetStats()
+}
+
+// Attempts returns the number of attempts at the transfer so far.
+// This starts at 1 and goes up by one each time RetryReader is
+// called.  ResetStats doesn't change it.
+func (acc *Account) Attempts() int {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.retries + 1
+}
+
+// ResetStats resets the stats for this transfer as if it had just
+// been started, so the percentage, speed and ETA reflect the current
+// attempt only.
+//
+// The bytes already accounted in its StatsInfo are left alone as
+// they were really transferred.
+func (acc *Account) ResetStats() {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc._resetStats()
+}
+
+// DiscardStats resets the stats for this transfer like ResetStats and
+// also moves the bytes transferred so far from the bytes transferred
+// to the bytes retried in its StatsInfo.
+//
+// Use this instead of ResetStats when the data transferred by the
+// failed attempt was thrown away and shouldn't count towards the
+// total.
+func (acc *Account) DiscardStats() {
+	acc.statmu.Lock()
+	discarded := acc._resetStats()
+	acc.statmu.Unlock()
+	acc.stats.BytesRetried(discarded)
+}
+
+// _resetStats does the work for ResetStats returning the number of
+// bytes discarded - call with statmu held
+func (acc *Account) _resetStats() (discarded int64) {
+	discarded = acc.bytes
+	acc.bytes = 0
+	acc.wire = 0
+	acc.start = time.Time{}
+	acc.lpBytes = 0
+	acc.lpTime = clk.Now()
+	acc.lpLast = time.Time{}
+	acc.lpSpeed = 0
+	if acc.avg != nil {
+		acc.avg = acc._newMovingAverage()
+	}
+	acc.ticks = 0
+	acc.slowAt = time.Time{}
+	acc.history.reset()
+	return discarded
+}
+
+// averageTick adds the average speed since the last tick to the
+// moving average.  It is called by the averager every
+// tickInterval().
+func (acc *Account) averageTick(now time.Time) {
+	acc.statmu.Lock()
+	elapsed := now.Sub(acc.lpTime).Seconds()
+	if elapsed <= 0 || acc.resume != nil {
+		// Account was made after the tick fired or is paused
+		acc.statmu.Unlock()
+		return
+	}
+	// Add average of last second.
+	avg := float64(acc.lpBytes) / elapsed
+	acc.avg.Add(avg)
+	acc.history.add(avg)
+	acc.lpSpeed = avg
+	if acc.lpBytes != 0 {
+		acc.lpLast = now
+	}
+	acc.lpBytes = 0
+	acc.lpTime = now
+	if !acc.start.IsZero() {
+		acc.ticks++
+	}
+	tooSlow := acc._checkMinSpeed(now)
+	acc._tuneBuffers()
+	acc.statmu.Unlock()
+	if tooSlow {
+		fs.Errorf(acc.Name(), "Aborting transfer: speed below %vBytes/s for %v", fs.Config.MinSpeed, fs.Config.MinSpeedTime)
+		acc.cancel(ErrorTransferStalled)
+	}
+}
+
+// _checkMinSpeed returns true if the speed of the transfer has been
+// below --min-speed for --min-speed-time - call with statmu held.
+//
+// The speed isn't checked until the first byte has been read and the
+// moving average has had time to warm up so setting up the connection
+// doesn't count.
+func (acc *Account) _checkMinSpeed(now time.Time) bool {
+	if fs.Config.MinSpeed <= 0 || acc.start.IsZero() {
+		return false
+	}
+	if acc.ticks <= int(ewma.WARMUP_SAMPLES) || acc.avg.Value() >= float64(fs.Config.MinSpeed) {
+		acc.slowAt = time.Time{}
+		return false
+	}
+	if acc.slowAt.IsZero() {
+		acc.slowAt = now
+	}
+	return now.Sub(acc.slowAt) >= fs.Config.MinSpeedTime
+}
+
+// SpeedHistory returns up to the last n speed samples of the transfer
+// in bytes/s, oldest first.  A sample is taken every tickInterval(),
+// normally every second, and up to fs.Config.StatsSpeedHistory of
+// them are kept.
+//
+// No samples are kept with fs.Config.StatsLight or while the transfer
+// is paused.
+func (acc *Account) SpeedHistory(n int) []float64 {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.history.last(n)
+}
+
+// WindowSpeed returns the speed of the transfer in bytes/s over the
+// last window worked out from the samples kept for SpeedHistory.
+// Unlike the average speed since the start this forgets how fast the
+// transfer was before the window, and unlike the moving average all of
+// the window counts the same.
+//
+// The window is rounded up to whole samples.  It is cut short to the
+// samples kept, fs.Config.StatsSpeedHistory, and to the samples since
+// the transfer started if it is younger.  ok is false if there are no
+// samples yet or none are kept.
+func (acc *Account) WindowSpeed(window time.Duration) (bps float64, ok bool) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	n := len(acc.history.samples)
+	if interval := tickInterval(); window < time.Duration(n)*interval {
+		n = int((window + interval - 1) / interval)
+		if n < 1 {
+			n = 1
+		}
+	}
+	return acc.history.average(n)
+}
+
+// stalledThreshold is how long a transfer must not have made any
+// progress for before String marks it as stalled
+const stalledThreshold = time.Minute
+
+// IsStalled returns true if the transfer has started but no bytes
+// have been transferred for at least threshold.
+//
+// It is never true before the first read so it won't trigger while
+// the transfer is still connecting, nor with fs.Config.StatsLight.
+func (acc *Account) IsStalled(threshold time.Duration) bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc._isStalled(threshold)
+}
+
+// _isStalled does the work for IsStalled - call with statmu held
+func (acc *Account) _isStalled(threshold time.Duration) bool {
+	if acc.start.IsZero() || acc.avg == nil || acc.resume != nil {
+		// Not started, no measurements to tell or paused
+		return false
+	}
+	last := acc.lpLast
+	if last.Before(acc.start) {
+		last = acc.start
+	}
+	return clk.Now().Sub(last) >= threshold
+}
+
+// Pause pauses the transfer.  Reads (or writes) block until Resume is
+// called or the transfer is cancelled or closed.  A read in progress
+// when Pause is called isn't interrupted.
+//
+// The time spent paused doesn't count towards the speed and ETA.
+func (acc *Account) Pause() {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.resume != nil {
+		return
+	}
+	acc.resume = make(chan struct{})
+	acc.pauseAt = clk.Now()
+}
+
+// Resume resumes a transfer paused with Pause
+func (acc *Account) Resume() {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.resume == nil {
+		return
+	}
+	// Move the times on by the time paused so it isn't counted
+	now := clk.Now()
+	paused := now.Sub(acc.pauseAt)
+	if !acc.start.IsZero() {
+		acc.start = acc.start.Add(paused)
+	}
+	if !acc.lpLast.IsZero() {
+		acc.lpLast = acc.lpLast.Add(paused)
+	}
+	if !acc.lastAt.IsZero() {
+		acc.lastAt = acc.lastAt.Add(paused)
+	}
+	acc.lpTime = now
+	acc.slowAt = time.Time{}
+	close(acc.resume)
+	acc.resume = nil
+	acc.pauseAt = time.Time{}
+}
+
+// IsPaused returns true if the transfer is paused
+func (acc *Account) IsPaused() bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.resume != nil
+}
+
+// waitResume blocks while the transfer is paused until it is resumed
+// or finished.  It must be called without acc.mu held so the Account
+// can be closed while it is waiting.
+func (acc *Account) waitResume() {
+	acc.statmu.Lock()
+	resume := acc.resume
+	acc.statmu.Unlock()
+	if resume == nil {
+		return
+	}
+	select {
+	case <-resume:
+	case <-acc.exit:
+	}
+}
+
+// _elapsed returns the time the transfer has been running at now,
+// not counting the time paused - call with statmu held
+func (acc *Account) _elapsed(now time.Time) time.Duration {
+	if !acc.pauseAt.IsZero() {
+		now = acc.pauseAt
+	}
+	return now.Sub(acc.start)
+}
+
+// elapsed returns the time spent transferring so far rounded to the
+// second, or 0 if the transfer hasn't started
+func (acc *Account) elapsed() time.Duration {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.start.IsZero() {
+		return 0
+	}
+	d := acc._elapsed(clk.Now())
+	return d - d%time.Second
+}
+
+// checkStart sets the start time if this is the first read or write
+func (acc *Account) checkStart() {
+	acc.statmu.Lock()
+	if acc.start.IsZero() {
+		acc.start = clk.Now()
+	}
+	acc.started = true
+	acc.statmu.Unlock()
+}
+
+// accountBytes updates the stats for n bytes read or written and
+// limits the bandwidth
+func (acc *Account) accountBytes(n int) {
+	if !acc.countBytes(n) {
+		return
+	}
+	if acc.globallyLimited() {
+		acc.shareBandwidth(n)
+		limitBandwidth(n)
+		acc.limitRemoteBandwidth(n)
+	}
+	acc.limitBandwidth(n)
+}
+
+// globallyLimited returns true if the bytes of this Account go
+// through the global bandwidth limit, which checks only do with
+// --bwlimit-checks
+func (acc *Account) globallyLimited() bool {
+	return !acc.check || fs.Config.BwLimitChecks
+}
+
+// countBytes updates the stats for n bytes read or written.  It
+// returns false without counting them if the Account has been closed
+// as the transfer has been finished.
+func (acc *Account) countBytes(n int) (counted bool) {
+	acc.statmu.Lock()
+	if acc.closed {
+		acc.statmu.Unlock()
+		return false
+	}
+	acc.lpBytes += n
+	acc.bytes += int64(n)
+	total, progFn, group, dir := acc.bytes, acc.progFn, acc.group, acc.dir
+	first := n > 0 && acc.firstAt.IsZero()
+	if first {
+		acc.firstAt = clk.Now()
+	}
+	if n > 0 && acc.idle > 0 {
+		acc.lastAt = clk.Now()
+	}
+	firstByte := acc._firstByte()
+	small := acc._isSmall()
+	// Count these with statmu held so that once Close has set
+	// closed no more bytes turn up in the stats.  Neither takes
+	// a lock which is held while taking statmu.
+	if acc.check {
+		acc.stats.checkBytes(int64(n))
+	} else {
+		acc.stats.transferBytes(int64(n), dir)
+	}
+	if group != nil {
+		group.accountBytes(n)
+	}
+	acc.statmu.Unlock()
+
+	if first && !acc.check {
+		acc.stats.firstByte(firstByte)
+	}
+
+	if small {
+		acc.stats.small.add(n, clk.Now())
+	}
+
+	if progFn != nil {
+		progFn(n, total)
+	}
+	return true
+}
+
+// isSmall returns true if the transfer is of a file smaller than
+// --stats-small-files so it is shown in the total of the small files
+// in the stats rather than on its own
+func (acc *Account) isSmall() bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc._isSmall()
 }
 
-// WithBuffer - If the file is above a certain size it adds an Async reader
-func (acc *Account) WithBuffer() *Account {
-	acc.withBuf = true
-	var buffers int
-	if acc.size >= int64(fs.Config.BufferSize) || acc.size == -1 {
-		buffers = int(int64(fs.Config.BufferSize) / asyncreader.BufferSize)
+// _isSmall does the work for isSmall - call with statmu held
+func (acc *Account) _isSmall() bool {
+	return !acc.check && fs.Config.StatsSmallFiles > 0 && acc.size >= 0 && acc.size < int64(fs.Config.StatsSmallFiles)
+}
+
+// isClosed returns true if the Account has been closed
+func (acc *Account) isClosed() bool {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	return acc.closed
+}
+
+// SetProgressCallback sets fn to be called after every accounted
+// read (or write) with the number of bytes transferred by that call
+// and the total so far.  Use nil to remove the callback.
+//
+// The callback is run on the goroutine doing the reading so it must
+// be fast.  It is called without any accounting locks held so it may
+// call back into the Account.
+func (acc *Account) SetProgressCallback(fn ProgressFn) {
+	acc.statmu.Lock()
+	acc.progFn = fn
+	acc.statmu.Unlock()
+}
+
+// read bytes from the io.Reader passed in and account them
+func (acc *Account) read(in io.Reader, p []byte) (n int, err error) {
+	if err = acc.cancelled(); err != nil {
+		return 0, err
+	}
+	if err = acc.checkMaxTransfer(); err != nil {
+		return 0, err
+	}
+	acc.checkStart()
+	// latency is only set by init so it can be read without the lock
+	if acc.latency != nil {
+		start := clk.Now()
+		n, err = in.Read(p)
+		acc.addLatency(clk.Now().Sub(start))
 	} else {
-		buffers = int(acc.size / asyncreader.BufferSize)
+		n, err = in.Read(p)
 	}
-	// On big files add a buffer
-	if buffers > 0 {
-		rc, err := asyncreader.New(acc.origIn, buffers)
-		if err != nil {
-			fs.Errorf(acc.name, "Failed to make buffer: %v", err)
-		} else {
-			acc.in = rc
-			acc.close = rc
+	if acc.isClosed() {
+		// Read called after Close, eg by the http transport
+		// after CancelRequest, or from an accountStream which
+		// doesn't take mu.  The transfer has been finished so
+		// return the data without hashing or accounting it.
+		return n, err
+	}
+	n, maxErr := acc.limitMaxBytes(n)
+	acc.hash(p[:n], err == io.EOF && maxErr == nil)
+	acc.accountBytes(n)
+	if maxErr != nil {
+		acc.cancel(maxErr)
+		return n, maxErr
+	}
+	if err != nil {
+		// Return the reason for the cancel rather than the
+		// error from the closed stream
+		if cancelErr := acc.cancelled(); cancelErr != nil {
+			err = cancelErr
+		} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+			acc.statmu.Lock()
+			name, offset := acc.name, acc.bytes
+			acc.statmu.Unlock()
+			err = &ReadError{Name: name, Offset: offset, Err: err}
 		}
 	}
-	return acc
+	return
 }
 
-// GetReader returns the underlying io.ReadCloser under any Buffer
-func (acc *Account) GetReader() io.ReadCloser {
-	acc.mu.Lock()
-	defer acc.mu.Unlock()
-	return acc.origIn
+// write bytes to the io.Writer passed in and account them
+func (acc *Account) write(out io.Writer, p []byte) (n int, err error) {
+	if err = acc.cancelled(); err != nil {
+		return 0, err
+	}
+	if err = acc.checkMaxTransfer(); err != nil {
+		return 0, err
+	}
+	acc.checkStart()
+	n, err = out.Write(p)
+	acc.hash(p[:n], false)
+	acc.accountBytes(n)
+	if err != nil {
+		if cancelErr := acc.cancelled(); cancelErr != nil {
+			err = cancelErr
+		}
+	}
+	return
 }
 
-// StopBuffering stops the async buffer doing any more buffering
-func (acc *Account) StopBuffering() {
-	if asyncIn, ok := acc.in.(*asyncreader.AsyncReader); ok {
-		asyncIn.Abandon()
+// Read bytes from the object - see io.Reader
+func (acc *Account) Read(p []byte) (n int, err error) {
+	acc.waitResume()
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	if acc.in == nil {
+		return 0, errors.New("can't read from a writer Account")
 	}
+	return acc.read(acc.in, p)
 }
 
-// UpdateReader updates the underlying io.ReadCloser stopping the
-// asynb buffer (if any) and re-adding it
-func (acc *Account) UpdateReader(in io.ReadCloser) {
+// errAccountClosed is returned from WriteTo if the Account is closed
+// while it is running
+var errAccountClosed = errors.New("account closed")
+
+// writeToBufferSize is the size of the chunks WriteTo copies in
+const writeToBufferSize = 64 * 1024
+
+// WriteTo writes the data from the Account to w until there is no
+// more or an error occurs - see io.WriterTo.
+//
+// Each chunk is accounted and limited exactly as a Read would be so
+// this is just a faster way of copying the Account with io.Copy.
+//
+// If the stream being read, eg the async buffer, is an io.WriterTo
+// then its WriteTo is used so the data is written straight from its
+// buffers to w.  mu isn't held while it runs so the transfer can be
+// paused and closed in the middle of it.
+func (acc *Account) WriteTo(w io.Writer) (n int64, err error) {
 	acc.mu.Lock()
-	acc.StopBuffering()
-	acc.in = in
-	acc.close = in
-	acc.origIn = in
-	acc.WithBuffer()
+	in, ok := acc.in.(io.WriterTo)
 	acc.mu.Unlock()
+	if !ok {
+		return acc.writeToBuffered(w)
+	}
+	if err = acc.cancelled(); err != nil {
+		return 0, err
+	}
+	aw := &accountWriter{acc: acc, w: w, last: clk.Now()}
+	n, err = in.WriteTo(aw)
+	switch {
+	case err == io.EOF || err == nil:
+		acc.hash(nil, true)
+		return n, nil
+	case err == aw.err:
+		return n, err
+	}
+	if cancelErr := acc.cancelled(); cancelErr != nil {
+		return n, cancelErr
+	}
+	if acc.isClosed() {
+		// The error is from stopping the stream
+		return n, errAccountClosed
+	}
+	if err != io.ErrUnexpectedEOF {
+		acc.statmu.Lock()
+		name, offset := acc.name, acc.bytes
+		acc.statmu.Unlock()
+		err = &ReadError{Name: name, Offset: offset, Err: err}
+	}
+	return n, err
 }
 
-// averageLoop calculates averages for the stats in the background
-func (acc *Account) averageLoop() {
-	tick := time.NewTicker(time.Second)
-	defer tick.Stop()
-	for {
-		select {
-		case now := <-tick.C:
-			acc.statmu.Lock()
-			// Add average of last second.
-			elapsed := now.Sub(acc.lpTime).Seconds()
-			avg := float64(acc.lpBytes) / elapsed
-			acc.avg.Add(avg)
-			acc.lpBytes = 0
-			acc.lpTime = now
-			// Unlock stats
-			acc.statmu.Unlock()
-		case <-acc.exit:
-			return
+// accountWriter is passed to the WriteTo of the stream in
+// Account.WriteTo to account the data written to w as if it had been
+// read with Read
+type accountWriter struct {
+	acc  *Account
+	w    io.Writer
+	err  error     // the last error from Write
+	last time.Time // when Write last returned - the stream reads until the next
+}
+
+// Write accounts and writes p to w in chunks of writeToBufferSize
+func (aw *accountWriter) Write(p []byte) (n int, err error) {
+	acc := aw.acc
+	// latency is only set by init so it can be read without the lock
+	if acc.latency != nil {
+		acc.addLatency(clk.Now().Sub(aw.last))
+		defer func() {
+			aw.last = clk.Now()
+		}()
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > writeToBufferSize {
+			chunk = chunk[:writeToBufferSize]
+		}
+		acc.waitResume()
+		if err = acc.cancelled(); err == nil && acc.isClosed() {
+			err = errAccountClosed
+		}
+		if err == nil {
+			err = acc.checkMaxTransfer()
+		}
+		if err != nil {
+			aw.err = err
+			return n, err
+		}
+		acc.checkStart()
+		limited, maxErr := acc.limitMaxBytes(len(chunk))
+		chunk = chunk[:limited]
+		nw, err := aw.w.Write(chunk)
+		acc.hash(chunk[:nw], false)
+		acc.accountBytes(nw)
+		n += nw
+		if maxErr != nil {
+			acc.cancel(maxErr)
+			aw.err = maxErr
+			return n, maxErr
 		}
+		if err == nil && nw != len(chunk) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			aw.err = err
+			return n, err
+		}
+		p = p[nw:]
 	}
+	return n, nil
 }
 
-// read bytes from the io.Reader passed in and account them
-func (acc *Account) read(in io.Reader, p []byte) (n int, err error) {
-	// Set start time.
-	acc.statmu.Lock()
-	if acc.start.IsZero() {
-		acc.start = time.Now()
+// writeToBuffered does WriteTo with Read for streams which aren't
+// io.WriterTo
+func (acc *Account) writeToBuffered(w io.Writer) (n int64, err error) {
+	buf := make([]byte, writeToBufferSize)
+	for {
+		nr, readErr := acc.Read(buf)
+		if nr > 0 {
+			nw, writeErr := w.Write(buf[:nr])
+			n += int64(nw)
+			if writeErr != nil {
+				return n, writeErr
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return n, nil
+		}
+		if readErr != nil {
+			return n, readErr
+		}
 	}
-	acc.statmu.Unlock()
-
-	n, err = in.Read(p)
-
-	// Update Stats
-	acc.statmu.Lock()
-	acc.lpBytes += n
-	acc.bytes += int64(n)
-	acc.statmu.Unlock()
-
-	Stats.Bytes(int64(n))
-
-	limitBandwidth(n)
-	return
 }
 
-// Read bytes from the object - see io.Reader
-func (acc *Account) Read(p []byte) (n int, err error) {
+// Write bytes to the underlying writer - see io.Writer
+//
+// This may only be used on Accounts made with NewAccountWriter.
+func (acc *Account) Write(p []byte) (n int, err error) {
+	acc.waitResume()
 	acc.mu.Lock()
 	defer acc.mu.Unlock()
-	return acc.read(acc.in, p)
+	if acc.out == nil {
+		return 0, errors.New("can't write to a reader Account")
+	}
+	return acc.write(acc.out, p)
 }
 
 // Close the object
 func (acc *Account) Close() error {
 	acc.mu.Lock()
 	defer acc.mu.Unlock()
-	if acc.closed {
+	acc.statmu.Lock()
+	closed := acc.closed
+	acc.closed = true
+	acc.statmu.Unlock()
+	if closed {
 		return nil
 	}
-	acc.closed = true
-	close(acc.exit)
-	Stats.inProgress.clear(acc.name)
-	return acc.close.Close()
+	// Let a WriteTo paused in the stream return before closing it
+	// as closing the async buffer waits for its WriteTo to finish
+	acc.Resume()
+	err := acc.close.Close()
+	if acc.out != nil && err == nil {
+		acc.hash(nil, true)
+	}
+	acc.finish(err)
+	if acc.cancelled() != nil {
+		// The stream was already closed by the cancel
+		return nil
+	}
+	return err
+}
+
+// Drain stops the async buffer (if any), discards the data it has
+// read ahead and closes the Account, freeing the buffers straight
+// away.
+//
+// If account is set then the discarded bytes are counted in the stats
+// as they have been read from the source, otherwise they aren't
+// counted.  The bandwidth limit isn't applied to them either way.
+func (acc *Account) Drain(account bool) error {
+	acc.mu.Lock()
+	if asyncIn, ok := acc.in.(*asyncreader.AsyncReader); ok {
+		discarded := asyncIn.Drain()
+		if account && discarded > 0 {
+			acc.checkStart()
+			acc.countBytes(int(discarded))
+		}
+	}
+	acc.mu.Unlock()
+	return acc.Close()
 }
 
-// progress returns bytes read as well as the size.
-// Size can be <= 0 if the size is unknown.
+// progress returns bytes done, including any set by SetAlreadyDone,
+// as well as the size.  Size can be <= 0 if the size is unknown.
 func (acc *Account) progress() (bytes, size int64) {
 	if acc == nil {
 		return 0, 0
 	}
 	acc.statmu.Lock()
-	bytes, size = acc.bytes, acc.size
+	bytes, size = acc.bytes+acc.already, acc.size
 	acc.statmu.Unlock()
 	return bytes, size
 }
@@ -193,12 +1775,108 @@ func (acc *Account) speed() (bps, current float64) {
 	}
 	acc.statmu.Lock()
 	defer acc.statmu.Unlock()
+	return acc._speed()
+}
+
+// AddServerSideBytes adds n to the bytes which have been transferred
+// over the wire.  Use this if these differ from the bytes read through
+// the Account, eg when the backend decompresses a gzipped stream.
+//
+// Once this has been called the progress still shows the bytes read
+// against the size but the speeds are of the bytes on the wire.  The
+// current speed is scaled from the moving average by the compression
+// ratio so far.  The ETA is still from the bytes read so it doesn't
+// depend on knowing the compression ratio up front.
+//
+// If the size is unknown (-1) there is no progress or ETA to show so
+// this only changes the speeds.
+func (acc *Account) AddServerSideBytes(n int64) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.wire += n
+}
+
+// CompressionRatio returns the bytes read through the Account divided
+// by the bytes on the wire set with AddServerSideBytes once the
+// transfer has finished, eg 3 if a gzipped stream was a third of the
+// size of the data it decompressed to.  Below 1 means the data got
+// bigger on the wire.
+//
+// ok is false if the transfer hasn't finished yet or either count is
+// 0 so there is no ratio.
+func (acc *Account) CompressionRatio() (ratio float64, ok bool) {
+	select {
+	case <-acc.exit:
+	default:
+		return 0, false
+	}
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.wire <= 0 || acc.bytes <= 0 {
+		return 0, false
+	}
+	return float64(acc.bytes) / float64(acc.wire), true
+}
+
+// addLatency records that a read of in took d for ReadLatencies
+func (acc *Account) addLatency(d time.Duration) {
+	acc.statmu.Lock()
+	acc.latency.add(d.Seconds())
+	acc.statmu.Unlock()
+}
+
+// ReadLatencies returns estimates of the 50th, 95th and 99th
+// percentiles of the time each call of Read on the underlying reader
+// took, or with WriteTo the time the stream took between writes.
+// Comparing these with the speed shows whether the reads are slow or
+// just small.
+//
+// The reads are only timed with --stats-read-latency so ok is false
+// without it or if nothing has been read yet.
+func (acc *Account) ReadLatencies() (p50, p95, p99 time.Duration, ok bool) {
+	if acc.latency == nil {
+		return 0, 0, 0, false
+	}
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	if acc.latency.count == 0 {
+		return 0, 0, 0, false
+	}
+	toDuration := func(seconds float64) time.Duration {
+		d := time.Duration(seconds * float64(time.Second))
+		return d - d%time.Microsecond
+	}
+	h := acc.latency
+	return toDuration(h.quantile(0.5)), toDuration(h.quantile(0.95)), toDuration(h.quantile(0.99)), true
+}
+
+// _speed does the work for speed - call with statmu held
+//
+// The speeds are of the bytes on the wire if AddServerSideBytes has
+// been called.
+func (acc *Account) _speed() (bps, current float64) {
+	bps, current = acc._readSpeed()
+	if acc.wire > 0 && acc.bytes > 0 {
+		ratio := float64(acc.wire) / float64(acc.bytes)
+		bps *= ratio
+		current *= ratio
+	}
+	return bps, current
+}
+
+// _readSpeed returns the speeds of the bytes read through the Account
+// - call with statmu held
+func (acc *Account) _readSpeed() (bps, current float64) {
 	if acc.bytes == 0 {
 		return 0, 0
 	}
 	// Calculate speed from first read.
-	total := float64(time.Now().Sub(acc.start)) / float64(time.Second)
+	total := acc._elapsed(clk.Now()).Seconds()
 	bps = float64(acc.bytes) / total
+	if acc.avg == nil {
+		// No moving average with fs.Config.StatsLight
+		return bps, bps
+	}
 	current = acc.avg.Value()
 	return
 }
@@ -207,63 +1885,328 @@ func (acc *Account) speed() (bps, current float64) {
 // rounded to full seconds.
 // If the ETA cannot be determined 'ok' returns false.
 func (acc *Account) eta() (eta time.Duration, ok bool) {
-	if acc == nil || acc.size <= 0 {
+	if acc == nil {
 		return 0, false
 	}
 	acc.statmu.Lock()
 	defer acc.statmu.Unlock()
-	if acc.bytes == 0 {
+	return acc._eta()
+}
+
+// _eta does the work for eta - call with statmu held
+func (acc *Account) _eta() (eta time.Duration, ok bool) {
+	_, current := acc._readSpeed()
+	return calculateETA(acc.size, acc.bytes+acc.already, current)
+}
+
+// calculateETA returns the ETA for a transfer of size which has done
+// bytes so far at a speed of avg bytes per second, rounded to full
+// seconds. If the ETA cannot be determined 'ok' returns false.
+func calculateETA(size, bytes int64, avg float64) (eta time.Duration, ok bool) {
+	if size <= 0 {
+		return 0, false
+	}
+	if bytes == 0 {
 		return 0, false
 	}
-	left := acc.size - acc.bytes
+	left := size - bytes
 	if left <= 0 {
 		return 0, true
 	}
-	avg := acc.avg.Value()
 	if avg <= 0 {
 		return 0, false
 	}
-	seconds := float64(left) / acc.avg.Value()
+	seconds := float64(left) / avg
+
+	return roundETA(time.Duration(time.Second * time.Duration(int(seconds)))), true
+}
+
+// etaRoundAbove is the ETA above which ETAs are rounded to
+// --stats-eta-resolution
+const etaRoundAbove = time.Minute
+
+// roundETA rounds eta to the nearest --stats-eta-resolution if it is
+// longer than etaRoundAbove so long ETAs don't change every time the
+// stats are shown.  Shorter ETAs are left alone.  The result is
+// never negative.
+func roundETA(eta time.Duration) time.Duration {
+	if eta < 0 {
+		return 0
+	}
+	resolution := fs.Config.StatsETAResolution
+	if resolution <= 0 || eta <= etaRoundAbove {
+		return eta
+	}
+	rounded := (eta + resolution/2) / resolution * resolution
+	if rounded <= 0 {
+		// resolution is much bigger than eta
+		return eta
+	}
+	return rounded
+}
+
+// etaString formats eta for the stats.  If --stats-show-done-time is
+// set then the local time the transfer is expected to be done at is
+// added, eg "7h32m0s (done ~03:40)", in the time zone of now.
+//
+// The done time is rounded more coarsely the longer the ETA is, so
+// it doesn't change every time the stats are shown, and it has the
+// date too if it is a day or more away.
+func etaString(eta time.Duration, now time.Time) string {
+	if !fs.Config.StatsShowDoneTime {
+		return eta.String()
+	}
+	var granularity time.Duration
+	layout := "15:04"
+	switch {
+	case eta < time.Hour:
+		granularity = time.Minute
+	case eta < 24*time.Hour:
+		granularity = 5 * time.Minute
+	default:
+		granularity = time.Hour
+		layout = "2006-01-02 15:04"
+	}
+	done := now.Add(eta)
+	// Round in local time so the granularity lines up with the
+	// local clock in zones with odd offsets
+	_, offset := done.Zone()
+	shift := time.Duration(offset) * time.Second
+	done = done.Add(shift).Round(granularity).Add(-shift)
+	return fmt.Sprintf("%v (done ~%s)", eta, done.Format(layout))
+}
+
+// AccountSnapshot is a point in time copy of the stats of an Account
+//
+// It is plain data so it can be kept around after the Account is
+// finished.
+type AccountSnapshot struct {
+	Name            string        // name of the transfer
+	Start           time.Time     // time of the first read - zero if not started
+	Bytes           int64         // bytes transferred so far
+	Size            int64         // size of the transfer - < 0 if unknown
+	Percentage      int           // percentage done
+	PercentageValid bool          // set if Percentage could be calculated
+	BytesPerSecond  float64       // average speed since the first read
+	CurrentSpeed    float64       // exponentially weighted moving average of the speed
+	ETA             time.Duration // estimated time to completion
+	ETAValid        bool          // set if ETA could be calculated
+	FirstByte       time.Duration // time from the start to the first byte - 0 if none yet
+	WireBytes       int64         // bytes on the wire set by AddServerSideBytes - 0 if not set
+	Buffered        bool          // set if the transfer is read through an async buffer
+	AlreadyDone     int64         // bytes present before the transfer set by SetAlreadyDone
+
+	ID         string // unique ID of the Account
+	RemoteName string // name of the remote set by WithRemoteName - "" if not known
+}
+
+// DisplayName returns the name of the transfer prefixed with the name
+// of its remote, eg "remote:path/to/file", or just the name if the
+// remote isn't known
+func (s AccountSnapshot) DisplayName() string {
+	if s.RemoteName == "" {
+		return s.Name
+	}
+	return s.RemoteName + ":" + s.Name
+}
+
+// percentage returns the percentage of size done with bytes
+// transferred.  ok is false if the size is unknown.
+func percentage(bytes, size int64) (percent int, ok bool) {
+	if size < 0 {
+		return 0, false
+	}
+	if size > 0 {
+		percent = int(100 * float64(bytes) / float64(size))
+	}
+	return percent, true
+}
+
+// percentageString formats the percentage of size done for the
+// stats line.  Sizes of at least --stats-percent-decimals-size are
+// shown with --stats-percent-decimals decimal places so progress on
+// huge files can be seen.  size must be known.
+//
+// The percentage is truncated rather than rounded so 100% is only
+// shown when all the bytes are done, and it is padded so the width
+// doesn't change as it goes up.
+func percentageString(bytes, size int64) string {
+	decimals := 0
+	if size >= int64(fs.Config.StatsDecimalsSize) {
+		decimals = fs.Config.StatsPercentDecimals
+	}
+	if decimals <= 0 {
+		percent, _ := percentage(bytes, size)
+		return fmt.Sprintf("%2d%%", percent)
+	}
+	scale := math.Pow10(decimals)
+	var units float64
+	if size > 0 {
+		units = math.Floor(100 * scale * float64(bytes) / float64(size))
+		// float64 can round up when very close to the size
+		if bytes < size && units >= 100*scale {
+			units = 100*scale - 1
+		}
+	}
+	return fmt.Sprintf("%*.*f%%", decimals+3, decimals, units/scale)
+}
+
+// Snapshot returns a consistent copy of the stats for this Account
+func (acc *Account) Snapshot() AccountSnapshot {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	s := AccountSnapshot{
+		Name:  acc.name,
+		Start: acc.start,
+		Bytes: acc.bytes,
+		Size:  acc.size,
+	}
+	s.AlreadyDone = acc.already
+	s.Percentage, s.PercentageValid = percentage(acc.bytes+acc.already, acc.size)
+	s.BytesPerSecond, s.CurrentSpeed = acc._speed()
+	s.ETA, s.ETAValid = acc._eta()
+	s.FirstByte = acc._firstByte()
+	s.WireBytes = acc.wire
+	s.Buffered = acc.asyncIn != nil
+	s.ID = acc.id
+	s.RemoteName = acc.fsName
+	return s
+}
+
+// truncateName shortens name to length cells on the screen plus "..."
+// if it is wider, or leaves it alone if length is 0.  Double width
+// characters, eg CJK, count as 2 cells.
+//
+// mode says which part is replaced by the "...": "left" keeps the end
+// of the name, "right" the start and "middle" keeps both ends making
+// sure at least the extension is kept.
+func truncateName(name string, length int, mode string) string {
+	if length <= 0 || runewidth.StringWidth(name) <= length {
+		return name
+	}
+	runes := []rune(name)
+	switch mode {
+	case "right":
+		return string(runes[:headRunes(runes, length)]) + "..."
+	case "middle":
+		tail := length / 2
+		if ext := runewidth.StringWidth(path.Ext(name)); ext > tail && ext < length {
+			tail = ext
+		}
+		tailStart := len(runes) - tailRunes(runes, tail)
+		head := headRunes(runes, length-runewidth.StringWidth(string(runes[tailStart:])))
+		return string(runes[:head]) + "..." + string(runes[tailStart:])
+	}
+	return "..." + string(runes[len(runes)-tailRunes(runes, length):])
+}
+
+// headRunes returns how many runes from the start of runes fit in
+// width cells
+func headRunes(runes []rune, width int) (n int) {
+	for _, r := range runes {
+		width -= runewidth.RuneWidth(r)
+		if width < 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// tailRunes returns how many runes from the end of runes fit in width
+// cells
+func tailRunes(runes []rune, width int) (n int) {
+	for i := len(runes) - 1; i >= 0; i-- {
+		width -= runewidth.RuneWidth(runes[i])
+		if width < 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
 
-	return time.Duration(time.Second * time.Duration(int(seconds))), true
+// statsName returns the name of the transfer as shown in the stats
+func (acc *Account) statsName() string {
+	return truncateName(acc.Name(), fs.Config.StatsFileNameLength, fs.Config.StatsFileNameMode)
 }
 
 // String produces stats for this file
 func (acc *Account) String() string {
+	name := acc.statsName()
+	return acc.stringWidth(name, runewidth.StringWidth(name))
+}
+
+// StringThrottled is like String but only makes a new line once every
+// minInterval, returning the last one made otherwise.  Use this if
+// the stats are shown more often than they are worth recalculating.
+func (acc *Account) StringThrottled(minInterval time.Duration) string {
+	now := clk.Now()
+	acc.statmu.Lock()
+	if acc.strLast != "" && now.Sub(acc.strAt) < minInterval {
+		line := acc.strLast
+		acc.statmu.Unlock()
+		return line
+	}
+	acc.statmu.Unlock()
+	line := acc.String()
+	acc.statmu.Lock()
+	acc.strLast, acc.strAt = line, now
+	acc.statmu.Unlock()
+	return line
+}
+
+// stringWidth produces stats for this file with name, as returned by
+// statsName, right aligned in a column width cells wide
+func (acc *Account) stringWidth(name string, width int) string {
 	a, b := acc.progress()
-	_, cur := acc.speed()
+	avg, cur := acc.speed()
 	eta, etaok := acc.eta()
 	etas := "-"
 	if etaok {
 		if eta > 0 {
-			etas = fmt.Sprintf("%v", eta)
+			etas = etaString(eta, clk.Now())
 		} else {
 			etas = "0s"
 		}
 	}
-	name := []rune(acc.name)
-	if fs.Config.StatsFileNameLength > 0 {
-		if len(name) > fs.Config.StatsFileNameLength {
-			where := len(name) - fs.Config.StatsFileNameLength
-			name = append([]rune{'.', '.', '.'}, name[where:]...)
+	var done string
+	if b >= 0 {
+		done = fmt.Sprintf("%s /%s", percentageString(a, b), sizeString(fs.SizeSuffix(b)))
+		// The progress is of the range so show what it is part of
+		if full := acc.FullSize(); acc.IsRange() && full >= 0 {
+			done += fmt.Sprintf(" of %s", sizeString(fs.SizeSuffix(full)))
 		}
+	} else {
+		// The size is unknown so show the elapsed time instead of
+		// the meaningless percentage and ETA
+		done = fmt.Sprintf("%s done", sizeString(fs.SizeSuffix(a)))
+		etas = fmt.Sprintf("%v elapsed", acc.elapsed())
+	}
+
+	speed := FormatRate(cur)
+	if fs.Config.StatsShowAvgSpeed {
+		speed += fmt.Sprintf(" (avg %s)", FormatRate(avg))
 	}
 
-	if fs.Config.DataRateUnit == "bits" {
-		cur = cur * 8
+	if acc.IsPaused() {
+		etas += ", PAUSED"
+	} else if acc.IsStalled(stalledThreshold) {
+		etas += ", STALLED"
 	}
 
-	percentageDone := 0
-	if b > 0 {
-		percentageDone = int(100 * float64(a) / float64(b))
+	if buffered, capacity, ok := acc.Buffered(); ok && capacity > 0 {
+		etas += fmt.Sprintf(" (buf %d%%)", int(100*buffered/capacity))
 	}
 
-	done := fmt.Sprintf("%2d%% /%s", percentageDone, fs.SizeSuffix(b))
+	if attempts := acc.Attempts(); attempts > 1 {
+		etas += fmt.Sprintf(" (retry %d)", attempts-1)
+	}
 
-	return fmt.Sprintf("%45s: %s, %s/s, %s",
-		string(name),
+	return fmt.Sprintf("%s: %s, %s, %s",
+		runewidth.FillLeft(name, width),
 		done,
-		fs.SizeSuffix(cur),
+		speed,
 		etas,
 	)
 }
@@ -291,10 +2234,58 @@ func (acc *Account) WrapStream(in io.Reader) io.Reader {
 	}
 }
 
+// WrapStreamCounter wraps an io Reader so it will be accounted in the
+// same way as WrapStream and also counted in counter.
+//
+// Use this to see the progress of part of a transfer, eg a chunk of a
+// multipart upload, as well as the progress of the whole file.
+func (acc *Account) WrapStreamCounter(in io.Reader, counter *Counter) io.Reader {
+	return &accountStream{
+		acc:     acc,
+		in:      in,
+		counter: counter,
+	}
+}
+
+// Counter counts the bytes read through a stream wrapped with
+// WrapStreamCounter.
+//
+// Unlike an Account it has no stats or goroutines of its own, so it
+// is cheap to make one for each part of a transfer.  It is safe to
+// read from other goroutines while the stream is being read.
+type Counter struct {
+	bytes int64 // atomic - must be first for alignment on 32 bit
+	size  int64
+}
+
+// NewCounter makes a new Counter for a part of size bytes.  size may
+// be -1 if it isn't known.
+func NewCounter(size int64) *Counter {
+	return &Counter{
+		size: size,
+	}
+}
+
+// add adds n bytes to the counter
+func (c *Counter) add(n int) {
+	atomic.AddInt64(&c.bytes, int64(n))
+}
+
+// Bytes returns the number of bytes counted so far
+func (c *Counter) Bytes() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+// Size returns the size passed to NewCounter
+func (c *Counter) Size() int64 {
+	return c.size
+}
+
 // accountStream accounts a single io.Reader into a parent *Account
 type accountStream struct {
-	acc *Account
-	in  io.Reader
+	acc     *Account
+	in      io.Reader
+	counter *Counter // if set the bytes are counted in here too
 }
 
 // OldStream return the underlying stream
@@ -307,14 +2298,19 @@ func (a *accountStream) SetStream(in io.Reader) {
 	a.in = in
 }
 
-// WrapStream wrap in in an accounter
+// WrapStream wrap in in an accounter keeping the counter if any
 func (a *accountStream) WrapStream(in io.Reader) io.Reader {
-	return a.acc.WrapStream(in)
+	return a.acc.WrapStreamCounter(in, a.counter)
 }
 
 // Read bytes from the object - see io.Reader
 func (a *accountStream) Read(p []byte) (n int, err error) {
-	return a.acc.read(a.in, p)
+	a.acc.waitResume()
+	n, err = a.acc.read(a.in, p)
+	if a.counter != nil && !a.acc.isClosed() {
+		a.counter.add(n)
+	}
+	return n, err
 }
 
 // Accounter accounts a stream allowing the accounting to be removed and re-added
@@ -8,3 +8,7 @@ package accounting
 // startSignalHandler() is Unix specific and does nothing under non-Unix
 // platforms.
 func startSignalHandler() {}
+
+// startStatsSignalHandler() is Unix specific and does nothing under
+// non-Unix platforms.
+func startStatsSignalHandler() {}
@@ -2,23 +2,35 @@ package accounting
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/VividCortex/ewma"
+	"github.com/ncw/rclone/fs"
 	"github.com/ncw/rclone/fs/asyncreader"
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/ncw/rclone/fs/hash"
 	"github.com/ncw/rclone/fstest/mockobject"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 // Check it satisfies the interfaces
 var (
-	_ io.ReadCloser = &Account{}
-	_ io.Reader     = &accountStream{}
-	_ Accounter     = &Account{}
-	_ Accounter     = &accountStream{}
+	_ io.ReadCloser  = &Account{}
+	_ io.Reader      = &accountStream{}
+	_ Accounter      = &Account{}
+	_ Accounter      = &accountStream{}
+	_ io.WriteCloser = &Account{}
+	_ io.WriterTo    = &Account{}
 )
 
 func TestNewAccountSizeName(t *testing.T) {
@@ -112,22 +124,106 @@ func TestAccountString(t *testing.T) {
 
 	// FIXME not an exhaustive test!
 
-	assert.Equal(t, "test:  0% /3, 0/s, -", strings.TrimSpace(acc.String()))
+	assert.Equal(t, "test:  0% /3, 0 B/s, -", strings.TrimSpace(acc.String()))
 
 	var buf = make([]byte, 2)
 	n, err := acc.Read(buf)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, n)
 
-	assert.Equal(t, "test: 66% /3, 0/s, -", strings.TrimSpace(acc.String()))
+	assert.Equal(t, "test: 66% /3, 0 B/s, -", strings.TrimSpace(acc.String()))
 
+	fs.Config.StatsShowAvgSpeed = true
+	assert.Regexp(t, `^test: 66% /3, 0 B/s \(avg .*/s\), -$`, strings.TrimSpace(acc.String()))
+	fs.Config.StatsShowAvgSpeed = false
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountStringUnknownSize(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, -1, "test")
+
+	assert.Equal(t, "test: 0 done, 0 B/s, 0s elapsed", strings.TrimSpace(acc.String()))
+
+	_, err := acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+	acc.statmu.Lock()
+	acc.start = acc.start.Add(-62 * time.Second)
+	acc.statmu.Unlock()
+	assert.Regexp(t, `^test: 2 done, .*/s, 1m2s elapsed`, strings.TrimSpace(acc.String()))
+
+	// Finding out the size switches to the percentage
+	acc.SetSize(4)
+	assert.Regexp(t, `^test: 50% /4, .*/s, .*$`, strings.TrimSpace(acc.String()))
+	assert.Equal(t, int64(4), acc.Snapshot().Size)
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountSizeNameRange(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4}))
+	acc := NewAccountSizeNameRange(in, 4, 1000, "test")
+	assert.True(t, acc.IsRange())
+	assert.Equal(t, int64(4), acc.Snapshot().Size)
+	assert.Equal(t, int64(1000), acc.FullSize())
+
+	// The progress is against the range
+	_, err := acc.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, "test: 75% /4 of 1000, 0 B/s, -", strings.TrimSpace(acc.String()))
+	assert.NoError(t, acc.Close())
+
+	// Unknown size of the object
+	acc = NewAccountSizeNameRange(in, 4, -1, "test")
+	assert.Equal(t, int64(-1), acc.FullSize())
+	assert.Equal(t, "test:  0% /4, 0 B/s, -", strings.TrimSpace(acc.String()))
+	assert.NoError(t, acc.Close())
+
+	// Not a range
+	acc = NewAccountSizeName(in, 4, "test")
+	assert.False(t, acc.IsRange())
+	assert.Equal(t, int64(4), acc.FullSize())
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountCheck(t *testing.T) {
+	var called bool
+	remove := Stats.OnTransferComplete(func(TransferSnapshot) { called = true })
+	defer remove()
+	bytesBefore, checkedBefore := Stats.GetBytes(), Stats.GetCheckedBytes()
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountCheck(in, 3, "test-check")
+	assert.Nil(t, Stats.inProgress.get("test-check"))
+	assert.True(t, Stats.checkProg.get("test-check") == acc)
+
+	// Counted as checked, not transferred
+	_, err := acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+	assert.Equal(t, bytesBefore, Stats.GetBytes())
+	assert.Equal(t, checkedBefore+2, Stats.GetCheckedBytes())
+	assert.Contains(t, Stats.String(), "Checked:")
+
+	// Not held up by --max-transfer
+	oldMaxTransfer := fs.Config.MaxTransfer
+	fs.Config.MaxTransfer = 0
+	_, err = acc.Read(make([]byte, 2))
+	fs.Config.MaxTransfer = oldMaxTransfer
+	require.NoError(t, err)
+	assert.Equal(t, checkedBefore+3, Stats.GetCheckedBytes())
+
+	// Not reported as a transfer
 	assert.NoError(t, acc.Close())
+	assert.Nil(t, Stats.checkProg.get("test-check"))
+	assert.False(t, called)
 }
 
 // Test the Accounter interface methods on Account and accountStream
 func TestAccountAccounter(t *testing.T) {
 	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
 	acc := NewAccountSizeName(in, 3, "test")
+	defer func() { _ = acc.Close() }()
 
 	assert.True(t, in == acc.OldStream())
 
@@ -181,3 +277,1557 @@ func TestAccountAccounter(t *testing.T) {
 	assert.True(t, wrap(in3) == in3)
 
 }
+
+func TestAccountWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	acc := NewAccountWriter(nopWriteCloser{out}, 3, "test-writer")
+	assert.Equal(t, acc, Stats.inProgress.get("test-writer"))
+
+	// WithBuffer should be a no-op on a writer
+	acc.WithBuffer()
+	assert.Nil(t, acc.in)
+
+	n, err := acc.Write([]byte{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte{1, 2}, out.Bytes())
+
+	assert.False(t, acc.start.IsZero())
+	assert.Equal(t, 2, acc.lpBytes)
+	assert.Equal(t, int64(2), acc.bytes)
+	assert.Equal(t, "test-writer: 66% /3, 0 B/s, -", strings.TrimSpace(acc.String()))
+
+	_, err = acc.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	assert.NoError(t, acc.Close())
+	assert.Nil(t, Stats.inProgress.get("test-writer"))
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestAccountSnapshot(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+
+	s := acc.Snapshot()
+	assert.Equal(t, "test", s.Name)
+	assert.True(t, s.Start.IsZero())
+	assert.Equal(t, int64(0), s.Bytes)
+	assert.Equal(t, int64(3), s.Size)
+	assert.Equal(t, 0, s.Percentage)
+	assert.True(t, s.PercentageValid)
+	assert.False(t, s.ETAValid)
+	assert.False(t, s.Buffered)
+
+	var buf = make([]byte, 2)
+	_, err := acc.Read(buf)
+	assert.NoError(t, err)
+
+	s = acc.Snapshot()
+	assert.False(t, s.Start.IsZero())
+	assert.Equal(t, int64(2), s.Bytes)
+	assert.Equal(t, 66, s.Percentage)
+	assert.True(t, s.BytesPerSecond > 0)
+
+	// The snapshot doesn't change with the Account
+	_, err = acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), s.Bytes)
+
+	assert.NoError(t, acc.Close())
+
+	// Unknown size
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3})), -1, "test")
+	s = acc.Snapshot()
+	assert.False(t, s.PercentageValid)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountSetBandwidthLimit(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	assert.Nil(t, acc.limiter)
+
+	acc.SetBandwidthLimit(1024 * 1024)
+	require.NotNil(t, acc.limiter)
+	limiter := acc.limiter
+	assert.Equal(t, rate.Limit(1024*1024), limiter.Limit())
+
+	// Changing the limit should keep the same limiter
+	acc.SetBandwidthLimit(2 * 1024 * 1024)
+	assert.True(t, limiter == acc.limiter)
+	assert.Equal(t, rate.Limit(2*1024*1024), limiter.Limit())
+
+	var buf = make([]byte, 3)
+	n, err := acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	acc.SetBandwidthLimit(0)
+	assert.Nil(t, acc.limiter)
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountProgressCallback(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+
+	var reads []int
+	var totals []int64
+	acc.SetProgressCallback(func(bytesThisRead int, totalBytes int64) {
+		// check we can call back into the Account
+		_ = acc.Snapshot()
+		reads = append(reads, bytesThisRead)
+		totals = append(totals, totalBytes)
+	})
+
+	var buf = make([]byte, 2)
+	_, err := acc.Read(buf)
+	assert.NoError(t, err)
+	_, err = acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, reads)
+	assert.Equal(t, []int64{2, 3}, totals)
+
+	acc.SetProgressCallback(nil)
+	_, err = acc.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, len(reads))
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestNewMovingAverage(t *testing.T) {
+	oldWindow := fs.Config.StatsAvgWindow
+	defer func() {
+		fs.Config.StatsAvgWindow = oldWindow
+	}()
+
+	fs.Config.StatsAvgWindow = 0
+	_, ok := newMovingAverage().(*ewma.SimpleEWMA)
+	assert.True(t, ok)
+
+	for _, window := range []time.Duration{time.Millisecond, 5 * time.Second, 24 * time.Hour} {
+		fs.Config.StatsAvgWindow = window
+		_, ok = newMovingAverage().(*ewma.VariableEWMA)
+		assert.True(t, ok, window.String())
+	}
+}
+
+func TestAccountIsStalled(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+
+	// Not stalled before the first read however long it takes
+	acc.lpTime = acc.lpTime.Add(-time.Hour)
+	assert.False(t, acc.IsStalled(time.Second))
+
+	var buf = make([]byte, 2)
+	_, err := acc.Read(buf)
+	assert.NoError(t, err)
+	assert.False(t, acc.IsStalled(time.Minute))
+
+	// Pretend the first read was a long time ago
+	acc.start = acc.start.Add(-2 * time.Minute)
+	assert.True(t, acc.IsStalled(time.Minute))
+	assert.Contains(t, acc.String(), "STALLED")
+
+	// Bytes in the last measurement should clear it
+	acc.lpBytes = 1
+	acc.averageTick(time.Now())
+	assert.False(t, acc.IsStalled(time.Minute))
+	assert.NotContains(t, acc.String(), "STALLED")
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountResetStats(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+
+	var buf = make([]byte, 2)
+	_, err := acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), acc.bytes)
+	assert.False(t, acc.start.IsZero())
+
+	acc.ResetStats()
+	assert.Equal(t, int64(0), acc.bytes)
+	assert.Equal(t, 0, acc.lpBytes)
+	assert.True(t, acc.start.IsZero())
+	assert.Equal(t, 0.0, acc.avg.Value())
+
+	// Retry the transfer with the stats reset
+	in2 := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc.RetryReader(in2)
+	buf = make([]byte, 3)
+	n, err := acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, int64(3), acc.bytes)
+	assert.Equal(t, "test: 100% /3, 0 B/s, 0s (retry 1)", strings.TrimSpace(acc.String()))
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountContextCancel(t *testing.T) {
+	r, w := io.Pipe()
+	defer func() {
+		_ = w.Close()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	acc := NewAccountSizeNameContext(ctx, r, 10, "test-cancel")
+	assert.Equal(t, acc, Stats.inProgress.get("test-cancel"))
+
+	// Start a read which blocks
+	errs := make(chan error, 1)
+	go func() {
+		_, err := acc.Read(make([]byte, 1))
+		errs <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Read not cancelled")
+	}
+
+	// Subsequent reads return the cancel error too
+	_, err := acc.Read(make([]byte, 1))
+	assert.Equal(t, context.Canceled, err)
+
+	// Should be finished
+	<-acc.exit
+	assert.Nil(t, Stats.inProgress.get("test-cancel"))
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountDiscardStats(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	Stats.lock.RLock()
+	before := Stats.bytes
+	Stats.lock.RUnlock()
+
+	_, err := acc.Read(make([]byte, 2))
+	assert.NoError(t, err)
+	Stats.lock.RLock()
+	assert.Equal(t, before+2, Stats.bytes)
+	Stats.lock.RUnlock()
+
+	acc.DiscardStats()
+	assert.Equal(t, int64(0), acc.bytes)
+	assert.True(t, acc.start.IsZero())
+	Stats.lock.RLock()
+	assert.Equal(t, before, Stats.bytes)
+	Stats.lock.RUnlock()
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountDrain(t *testing.T) {
+	for _, account := range []bool{false, true} {
+		const size = 2 * asyncreader.BufferSize
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, size)))
+		acc := NewAccountSizeName(in, size, "test-drain").WithBuffer()
+		_, ok := acc.in.(*asyncreader.AsyncReader)
+		require.True(t, ok)
+
+		n, err := acc.Read(make([]byte, 1))
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		require.NoError(t, acc.Drain(account))
+		assert.True(t, acc.closed)
+		if account {
+			assert.True(t, acc.bytes > 1)
+			assert.True(t, acc.bytes <= size)
+		} else {
+			assert.Equal(t, int64(1), acc.bytes)
+		}
+	}
+}
+
+func TestAccountMinSpeed(t *testing.T) {
+	oldMinSpeed, oldMinSpeedTime := fs.Config.MinSpeed, fs.Config.MinSpeedTime
+	defer func() {
+		fs.Config.MinSpeed, fs.Config.MinSpeedTime = oldMinSpeed, oldMinSpeedTime
+	}()
+	fs.Config.MinSpeed = 1000
+	fs.Config.MinSpeedTime = 3 * time.Second
+
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+	acc := NewAccountSizeName(in, 10, "test-min-speed")
+	now := time.Now()
+	tick := func() {
+		now = now.Add(time.Second)
+		acc.averageTick(now)
+	}
+
+	// Nothing counts until the first byte is read
+	for i := 0; i < 20; i++ {
+		tick()
+	}
+	assert.NoError(t, acc.cancelled())
+
+	_, err := acc.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	// Warming up and the first 2 seconds of being slow
+	for i := 0; i < int(ewma.WARMUP_SAMPLES)+3; i++ {
+		tick()
+	}
+	assert.NoError(t, acc.cancelled())
+
+	// Now it has been slow for 3 seconds
+	tick()
+	assert.Equal(t, ErrorTransferStalled, acc.cancelled())
+	_, err = acc.Read(make([]byte, 1))
+	assert.Equal(t, ErrorTransferStalled, err)
+	assert.True(t, fserrors.IsRetryError(err))
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountWithHash(t *testing.T) {
+	const want = "098f6bcd4621d373cade4e832627b4f6" // MD5 of "test"
+	in := ioutil.NopCloser(bytes.NewBufferString("bad data"))
+	acc := NewAccountSizeName(in, 4, "test-hash")
+	assert.Nil(t, acc.Sums())
+	_, err := acc.WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+
+	// Data from the failed attempt isn't hashed
+	_, err = acc.Read(make([]byte, 3))
+	require.NoError(t, err)
+	acc.RetryReader(ioutil.NopCloser(bytes.NewBufferString("test")))
+
+	data, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	assert.Equal(t, "test", string(data))
+	assert.Equal(t, map[hash.Type]string{hash.MD5: want}, acc.Sums())
+	require.NoError(t, acc.Close())
+
+	// Only the data read through the buffer is hashed
+	const size = 2 * asyncreader.BufferSize
+	in = ioutil.NopCloser(bytes.NewBuffer(make([]byte, size)))
+	acc, err = NewAccountSizeName(in, size, "test-hash-buffer").WithBuffer().WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+	_, err = io.ReadFull(acc, make([]byte, 4))
+	require.NoError(t, err)
+	assert.Equal(t, map[hash.Type]string{hash.MD5: "f1d3ff8443297732862df21dc4e57262"}, acc.Sums())
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountHashes(t *testing.T) {
+	const want = "098f6bcd4621d373cade4e832627b4f6" // MD5 of "test"
+	in := ioutil.NopCloser(bytes.NewBufferString("test"))
+	acc := NewAccountSizeName(in, 4, "test-hashes")
+	sums, complete := acc.Hashes()
+	assert.Nil(t, sums)
+	assert.False(t, complete)
+	_, err := acc.WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+
+	// Incomplete until the end of the stream is read
+	_, err = io.ReadFull(acc, make([]byte, 4))
+	require.NoError(t, err)
+	sums, complete = acc.Hashes()
+	assert.Equal(t, map[hash.Type]string{hash.MD5: want}, sums)
+	assert.False(t, complete)
+	_, err = acc.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_, complete = acc.Hashes()
+	assert.True(t, complete)
+
+	// Retrying starts again
+	acc.RetryReader(ioutil.NopCloser(bytes.NewBufferString("test")))
+	_, complete = acc.Hashes()
+	assert.False(t, complete)
+	require.NoError(t, acc.Close())
+
+	// Aborted transfers are incomplete
+	in = ioutil.NopCloser(bytes.NewBufferString("test"))
+	acc, err = NewAccountSizeName(in, 4, "test-hashes-abort").WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+	_, err = acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+	acc.cancel(ErrorTransferAborted)
+	_, err = ioutil.ReadAll(acc)
+	assert.Equal(t, ErrorTransferAborted, err)
+	_, complete = acc.Hashes()
+	assert.False(t, complete)
+	require.NoError(t, acc.Close())
+
+	// Writers are complete when closed
+	out := NewAccountWriter(nopWriteCloser{ioutil.Discard}, 4, "test-hashes-writer")
+	_, err = out.WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+	_, err = out.Write([]byte("test"))
+	require.NoError(t, err)
+	_, complete = out.Hashes()
+	assert.False(t, complete)
+	require.NoError(t, out.Close())
+	sums, complete = out.Hashes()
+	assert.Equal(t, map[hash.Type]string{hash.MD5: want}, sums)
+	assert.True(t, complete)
+}
+
+func TestAccountWriterContextCancel(t *testing.T) {
+	r, w := io.Pipe()
+	defer func() {
+		_ = r.Close()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	acc := NewAccountWriterContext(ctx, w, 10, "test-writer-cancel")
+
+	// Start a write which blocks as nothing reads the pipe
+	errs := make(chan error, 1)
+	go func() {
+		_, err := acc.Write([]byte{1})
+		errs <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write not cancelled")
+	}
+
+	_, err := acc.Write([]byte{1})
+	assert.Equal(t, context.Canceled, err)
+	<-acc.exit
+	assert.Nil(t, Stats.inProgress.get("test-writer-cancel"))
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountBufferAuto(t *testing.T) {
+	oldBufferAuto := fs.Config.BufferAuto
+	defer func() {
+		fs.Config.BufferAuto = oldBufferAuto
+	}()
+	fs.Config.BufferAuto = true
+
+	r, w := io.Pipe()
+	acc := NewAccountSizeName(r, -1, "test-buffer-auto").WithBuffer()
+	ar, ok := acc.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+	buffers := int(fs.Config.BufferSize / asyncreader.BufferSize)
+	max := bufferAutoMax * buffers
+	assert.Equal(t, bufferAutoStart, acc.Buffers())
+
+	// fill calls _tuneBuffersFill for ticks ticks with the buffer
+	// filled to fraction
+	fill := func(fraction float64, ticks int) int {
+		acc.statmu.Lock()
+		defer acc.statmu.Unlock()
+		for i := 0; i < ticks; i++ {
+			capacity := int64(ar.Buffers()) * asyncreader.BufferSize
+			acc._tuneBuffersFill(int64(fraction*float64(capacity)), capacity)
+		}
+		return ar.Buffers()
+	}
+
+	// Needs to be full consistently before growing
+	assert.Equal(t, bufferAutoStart, fill(1, bufferAutoTicks-1))
+	assert.Equal(t, bufferAutoStart, fill(0.5, 1))
+	assert.Equal(t, bufferAutoStart, fill(1, bufferAutoTicks-1))
+	assert.Equal(t, 2*bufferAutoStart, fill(1, 1))
+
+	// Up to the maximum
+	assert.Equal(t, max, fill(1, 100*bufferAutoTicks))
+
+	// Shrinks when empty down to 1
+	assert.Equal(t, max, fill(0, bufferAutoTicks-1))
+	assert.Equal(t, max/2, fill(0, 1))
+	assert.Equal(t, 1, fill(0, 100*bufferAutoTicks))
+	assert.Equal(t, 1, acc.Buffers())
+
+	// Unblock the async reader so it can be closed
+	assert.NoError(t, w.Close())
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountBufferStatic(t *testing.T) {
+	oldBufferAuto := fs.Config.BufferAuto
+	defer func() {
+		fs.Config.BufferAuto = oldBufferAuto
+	}()
+	fs.Config.BufferAuto = false
+
+	r, w := io.Pipe()
+	acc := NewAccountSizeName(r, -1, "test-buffer-static").WithBuffer()
+	buffers := int(fs.Config.BufferSize / asyncreader.BufferSize)
+	assert.Equal(t, buffers, acc.Buffers())
+
+	// Not tuned without --buffer-auto
+	acc.statmu.Lock()
+	acc.ticks = bufferAutoDelay
+	for i := 0; i < 10*bufferAutoTicks; i++ {
+		acc._tuneBuffers()
+	}
+	acc.statmu.Unlock()
+	assert.Equal(t, buffers, acc.Buffers())
+
+	assert.NoError(t, w.Close())
+	assert.NoError(t, acc.Close())
+
+	// No buffer
+	acc = NewAccountSizeName(r, -1, "test-buffer-none")
+	assert.Equal(t, 0, acc.Buffers())
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountAttempts(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	assert.Equal(t, 1, acc.Attempts())
+	assert.NotContains(t, acc.String(), "retry")
+
+	// Reopening with UpdateReader isn't a retry
+	acc.UpdateReader(ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3})))
+	assert.Equal(t, 1, acc.Attempts())
+
+	acc.RetryReader(ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3})))
+	acc.RetryReader(ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3})))
+	assert.Equal(t, 3, acc.Attempts())
+	assert.True(t, strings.HasSuffix(acc.String(), " (retry 2)"), acc.String())
+
+	// ResetStats doesn't change the attempts
+	acc.ResetStats()
+	assert.Equal(t, 3, acc.Attempts())
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountStatsLight(t *testing.T) {
+	old := fs.Config.StatsLight
+	fs.Config.StatsLight = true
+	defer func() { fs.Config.StatsLight = old }()
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	assert.Nil(t, acc.avg)
+	averages.mu.Lock()
+	_, found := averages.accs[acc]
+	averages.mu.Unlock()
+	assert.False(t, found)
+
+	buf := make([]byte, 2)
+	n, err := acc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// The current speed is the average since the start
+	bps, current := acc.speed()
+	assert.True(t, bps > 0)
+	assert.Equal(t, bps, current)
+	_, ok := acc.eta()
+	assert.True(t, ok)
+	assert.False(t, acc.IsStalled(time.Nanosecond))
+
+	acc.ResetStats()
+	assert.Nil(t, acc.avg)
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountWithBufferMemoryLimit(t *testing.T) {
+	defer asyncreader.SetMemoryLimit(0)
+	asyncreader.SetMemoryLimit(int64(fs.Config.BufferSize))
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+	acc1 := NewAccountSizeName(in, -1, "test1").WithBuffer()
+	_, ok := acc1.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+
+	// No memory left so no buffer
+	acc2 := NewAccountSizeName(in, -1, "test2").WithBuffer()
+	_, ok = acc2.in.(*asyncreader.AsyncReader)
+	require.False(t, ok)
+	assert.NoError(t, acc2.Close())
+
+	s := NewStats()
+	assert.Contains(t, s.String(), "Buffer:")
+	assert.NoError(t, acc1.Close())
+
+	// The memory is given back on Close
+	acc2 = NewAccountSizeName(in, -1, "test2").WithBuffer()
+	_, ok = acc2.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+	assert.NoError(t, acc2.Close())
+
+	asyncreader.SetMemoryLimit(0)
+	assert.NotContains(t, s.String(), "Buffer:")
+}
+
+func TestAccountFirstByte(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeNameContext(ctx, in, 3, "test")
+	assert.Equal(t, time.Duration(0), acc.Snapshot().FirstByte)
+	assert.NotContains(t, s.String(), "First byte:")
+	_, ok := acc.TimeToFirstByte()
+	assert.False(t, ok)
+
+	// Measured from Start if called
+	time.Sleep(10 * time.Millisecond)
+	started := time.Now()
+	acc.Start()
+	buf := make([]byte, 1)
+	time.Sleep(10 * time.Millisecond)
+	_, err := acc.Read(buf)
+	require.NoError(t, err)
+	ttfb := acc.Snapshot().FirstByte
+	assert.True(t, ttfb >= 10*time.Millisecond && ttfb <= time.Since(started), ttfb)
+	got, ok := acc.TimeToFirstByte()
+	assert.True(t, ok)
+	assert.Equal(t, ttfb, got)
+
+	// Doesn't change after the first byte
+	acc.Start()
+	_, err = acc.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, ttfb, acc.Snapshot().FirstByte)
+	assert.Contains(t, s.String(), "First byte:")
+
+	var snapshot TransferSnapshot
+	s.OnTransferComplete(func(ts TransferSnapshot) { snapshot = ts })
+	assert.NoError(t, acc.Close())
+	assert.Equal(t, ttfb, snapshot.FirstByte)
+}
+
+func TestAccountPauseResume(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+	acc := NewAccountSizeName(in, 100, "test")
+	buf := make([]byte, 10)
+	_, err := acc.Read(buf)
+	require.NoError(t, err)
+	assert.False(t, acc.IsPaused())
+
+	acc.Pause()
+	assert.True(t, acc.IsPaused())
+	assert.Contains(t, acc.String(), ", PAUSED")
+	bps, _ := acc.speed()
+
+	read := make(chan struct{})
+	go func() {
+		_, err := acc.Read(buf)
+		assert.NoError(t, err)
+		close(read)
+	}()
+	select {
+	case <-read:
+		t.Fatal("read while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The time paused isn't counted
+	bpsPaused, _ := acc.speed()
+	assert.Equal(t, bps, bpsPaused)
+	before := acc.Snapshot().Start
+	acc.Resume()
+	assert.False(t, acc.IsPaused())
+	assert.True(t, acc.Snapshot().Start.Sub(before) >= 50*time.Millisecond)
+	<-read
+	assert.NotContains(t, acc.String(), "PAUSED")
+
+	// Closing releases a paused read
+	acc.Pause()
+	read = make(chan struct{})
+	go func() {
+		_, _ = acc.Read(buf)
+		close(read)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, acc.Close())
+	<-read
+}
+
+func TestAccountAddServerSideBytes(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+	acc := NewAccountSizeName(in, 100, "test")
+	buf := make([]byte, 50)
+	_, err := acc.Read(buf)
+	require.NoError(t, err)
+	acc.statmu.Lock()
+	acc.avg.Set(1000)
+	acc.statmu.Unlock()
+	// Pause so the speeds don't change with time
+	acc.Pause()
+	bps, current := acc.speed()
+	eta, ok := acc.eta()
+	require.True(t, ok)
+
+	// Half the bytes on the wire
+	acc.AddServerSideBytes(25)
+	wireBps, wireCurrent := acc.speed()
+	assert.InDelta(t, bps/2, wireBps, 1e-9)
+	assert.InDelta(t, current/2, wireCurrent, 1e-9)
+	assert.Equal(t, int64(25), acc.Snapshot().WireBytes)
+
+	// The progress and ETA are still of the bytes read
+	a, b := acc.progress()
+	assert.Equal(t, int64(50), a)
+	assert.Equal(t, int64(100), b)
+	wireEta, ok := acc.eta()
+	require.True(t, ok)
+	assert.Equal(t, eta, wireEta)
+	assert.Contains(t, acc.String(), "50% /100")
+
+	acc.Resume()
+	acc.ResetStats()
+	assert.Equal(t, int64(0), acc.Snapshot().WireBytes)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountCompressionRatio(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+	acc := NewAccountSizeName(in, 100, "test")
+	_, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+
+	// No bytes on the wire
+	_, ok := acc.CompressionRatio()
+	assert.False(t, ok)
+
+	// Not finished
+	acc.AddServerSideBytes(25)
+	_, ok = acc.CompressionRatio()
+	assert.False(t, ok)
+
+	require.NoError(t, acc.Close())
+	ratio, ok := acc.CompressionRatio()
+	require.True(t, ok)
+	assert.Equal(t, 4.0, ratio)
+
+	// Bigger on the wire
+	in = ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+	acc = NewAccountSizeName(in, 10, "test")
+	_, err = ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	acc.AddServerSideBytes(20)
+	require.NoError(t, acc.Close())
+	ratio, ok = acc.CompressionRatio()
+	require.True(t, ok)
+	assert.Equal(t, 0.5, ratio)
+}
+
+// slowReader is a reader which advances a fakeClock by the next of
+// delays every read
+type slowReader struct {
+	c      *fakeClock
+	delays []time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (n int, err error) {
+	if len(r.delays) == 0 {
+		return 0, io.EOF
+	}
+	r.c.advance(r.delays[0])
+	r.delays = r.delays[1:]
+	return 1, nil
+}
+
+func TestAccountReadLatencies(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	oldReadLatency := fs.Config.StatsReadLatency
+	defer func() {
+		fs.Config.StatsReadLatency = oldReadLatency
+	}()
+
+	// Not timed unless configured
+	fs.Config.StatsReadLatency = false
+	acc := NewAccountSizeName(ioutil.NopCloser(&slowReader{c: c, delays: []time.Duration{time.Second}}), 1, "test")
+	_, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	_, _, _, ok := acc.ReadLatencies()
+	assert.False(t, ok)
+	require.NoError(t, acc.Close())
+
+	// 98 fast reads and 2 slow ones
+	fs.Config.StatsReadLatency = true
+	var delays []time.Duration
+	for i := 0; i < 98; i++ {
+		delays = append(delays, 500*time.Microsecond)
+	}
+	delays = append(delays, 2*time.Second, 2*time.Second)
+	acc = NewAccountSizeName(ioutil.NopCloser(&slowReader{c: c, delays: delays}), 100, "test")
+	_, _, _, ok = acc.ReadLatencies()
+	assert.False(t, ok)
+	_, err = ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	p50, p95, p99, ok := acc.ReadLatencies()
+	require.True(t, ok)
+	assert.True(t, p50 > 250*time.Microsecond && p50 <= 500*time.Microsecond, p50)
+	assert.True(t, p95 > 250*time.Microsecond && p95 <= 500*time.Microsecond, p95)
+	assert.True(t, p99 > time.Second && p99 <= 2500*time.Millisecond, p99)
+	require.NoError(t, acc.Close())
+
+	// The reads of a stream copied with its WriteTo are timed too
+	inner := &writerToReader{Reader: &slowReader{c: c, delays: delays}}
+	in := struct {
+		io.WriterTo
+		io.ReadCloser
+	}{inner, ioutil.NopCloser(inner)}
+	acc = NewAccountSizeName(in, 100, "test")
+	_, err = io.Copy(ioutil.Discard, acc)
+	require.NoError(t, err)
+	require.True(t, inner.used)
+	p50, p95, p99, ok = acc.ReadLatencies()
+	require.True(t, ok)
+	assert.True(t, p50 > 250*time.Microsecond && p50 <= 500*time.Microsecond, p50)
+	assert.True(t, p95 > 250*time.Microsecond && p95 <= 500*time.Microsecond, p95)
+	assert.True(t, p99 > time.Second && p99 <= 2500*time.Millisecond, p99)
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountDone(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	done := acc.Done()
+	assert.True(t, done == acc.Done())
+	select {
+	case <-done:
+		t.Fatal("done before close")
+	default:
+	}
+	assert.NoError(t, acc.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("not done after close")
+	}
+	assert.True(t, done == acc.Done())
+}
+
+func TestTruncateName(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		length int
+		mode   string
+		want   string
+	}{
+		{"short.mp4", 40, "left", "short.mp4"},
+		{"mylongvideofile.mp4", 0, "middle", "mylongvideofile.mp4"},
+		{"mylongvideofile.mp4", 19, "middle", "mylongvideofile.mp4"},
+		{"mylongvideofile.mp4", 16, "left", "...ongvideofile.mp4"},
+		{"mylongvideofile.mp4", 11, "right", "mylongvideo..."},
+		{"mylongvideofile.mp4", 11, "middle", "mylong...e.mp4"},
+		{"mylongvideofile.tar.gz", 6, "middle", "myl....gz"},
+		{"mylongvideofile.extension", 12, "middle", "my....extension"},
+		{"mylongvideofile.extension", 8, "middle", "mylo...sion"},
+		{"ǅǅǅǅǅǅǅǅǅǅ", 4, "middle", "ǅǅ...ǅǅ"},
+		{"日本語のファイル.txt", 10, "left", "...ァイル.txt"},
+		{"日本語のファイル.txt", 9, "left", "...イル.txt"},
+		{"日本語のファイル.txt", 7, "right", "日本語..."},
+		{"日本語のファイル.txt", 10, "middle", "日本語....txt"},
+	} {
+		got := truncateName(test.name, test.length, test.mode)
+		assert.Equal(t, test.want, got, "%q %d %s", test.name, test.length, test.mode)
+	}
+}
+
+func TestStringSetAlignment(t *testing.T) {
+	ip := newInProgress()
+	ss := stringSet{"a.txt": 1, "日本語.txt": 1, "queued": 1}
+	for _, name := range []string{"a.txt", "日本語.txt"} {
+		acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 1, name)
+		defer func() { _ = acc.Close() }()
+		ip.set(acc)
+	}
+	assert.Equal(t, []string{
+		" *      a.txt:  0% /1, 0 B/s, -",
+		" * queued",
+		" * 日本語.txt:  0% /1, 0 B/s, -",
+	}, ss.Strings(ip))
+}
+
+func TestStringSetSameName(t *testing.T) {
+	ip := newInProgress()
+	ss := stringSet{}
+	ss.add("a.txt")
+	ss.add("a.txt")
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 1, "a.txt")
+	defer func() { _ = acc.Close() }()
+	ip.set(acc)
+
+	// The one which hasn't been opened yet is shown without stats
+	assert.Equal(t, []string{" * a.txt:  0% /1, 0 B/s, -", " * a.txt"}, ss.Strings(ip))
+
+	ss.remove("a.txt")
+	assert.Equal(t, []string{" * a.txt:  0% /1, 0 B/s, -"}, ss.Strings(ip))
+	ss.remove("a.txt")
+	assert.Equal(t, 0, len(ss))
+}
+
+func TestAccountGetBufferedReader(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, -1, "test").WithBuffer()
+	_, ok := acc.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+
+	// Reads from the buffer without accounting
+	r := acc.GetBufferedReader()
+	buf := make([]byte, 2)
+	n, err := io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte{1, 2}, buf)
+	assert.Equal(t, int64(0), acc.Snapshot().Bytes)
+
+	// Reads from the same stream as the Account
+	n, err = acc.Read(buf)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, byte(3), buf[0])
+
+	// Follows the stream when it is replaced
+	acc.UpdateReader(ioutil.NopCloser(bytes.NewBuffer([]byte{4})))
+	n, err = r.Read(buf)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, byte(4), buf[0])
+
+	assert.NoError(t, r.Close())
+	assert.True(t, acc.closed)
+
+	w := NewAccountWriter(nopWriteCloser{ioutil.Discard}, 1, "test")
+	assert.Nil(t, w.GetBufferedReader())
+	assert.NoError(t, w.Close())
+}
+
+func TestAccountBuffered(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	_, _, ok := acc.Buffered()
+	assert.False(t, ok)
+	assert.NotContains(t, acc.String(), "(buf")
+	assert.NoError(t, acc.Close())
+
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc = NewAccountSizeName(in, -1, "test").WithBuffer()
+	_, capacity, ok := acc.Buffered()
+	assert.True(t, ok)
+	assert.Equal(t, int64(fs.Config.BufferSize), capacity)
+	assert.Contains(t, acc.String(), "(buf 0%)")
+
+	acc.StopBuffering()
+	_, _, ok = acc.Buffered()
+	assert.False(t, ok)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountSetAverageWindow(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	_, ok := acc.avg.(*ewma.SimpleEWMA)
+	assert.True(t, ok)
+
+	acc.SetAverageWindow(5 * time.Second)
+	_, ok = acc.avg.(*ewma.VariableEWMA)
+	assert.True(t, ok)
+
+	// The window is kept when the stats are reset
+	acc.ResetStats()
+	_, ok = acc.avg.(*ewma.VariableEWMA)
+	assert.True(t, ok)
+
+	acc.SetAverageWindow(0)
+	_, ok = acc.avg.(*ewma.SimpleEWMA)
+	assert.True(t, ok)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountWriteTo(t *testing.T) {
+	data := bytes.Repeat([]byte("hello"), 3*writeToBufferSize/5+7)
+	in := ioutil.NopCloser(bytes.NewBuffer(data))
+	acc := NewAccountSizeName(in, int64(len(data)), "test")
+	var progress int
+	acc.SetProgressCallback(func(n int, total int64) { progress++ })
+
+	out := &bytes.Buffer{}
+	n, err := io.Copy(out, acc)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, out.Bytes())
+	assert.Equal(t, int64(len(data)), acc.Snapshot().Bytes)
+	assert.True(t, progress >= 4, progress)
+
+	// Errors from the reader are returned
+	acc.cancel(ErrorTransferAborted)
+	_, err = acc.WriteTo(out)
+	assert.Equal(t, ErrorTransferAborted, err)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountWriteToPauseClose(t *testing.T) {
+	data := make([]byte, 4*asyncreader.BufferSize)
+	in := ioutil.NopCloser(bytes.NewBuffer(data))
+	acc := NewAccountSizeName(in, -1, "test-writeto-pause").WithBuffer()
+	_, ok := acc.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+
+	// A WriteTo paused in the async buffer doesn't hold up Close
+	acc.Pause()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := acc.WriteTo(ioutil.Discard)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- acc.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hung while WriteTo was paused")
+	}
+	assert.Equal(t, errAccountClosed, <-errs)
+}
+
+// errorReader returns n bytes of data then err
+type errorReader struct {
+	n   int
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (n int, err error) {
+	if r.n <= 0 {
+		return 0, r.err
+	}
+	n = len(p)
+	if n > r.n {
+		n = r.n
+	}
+	r.n -= n
+	return n, nil
+}
+
+func TestAccountReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	in := ioutil.NopCloser(&errorReader{n: 3, err: readErr})
+	acc := NewAccountSizeName(in, 10, "test-read-error")
+	_, err := ioutil.ReadAll(acc)
+	require.Error(t, err)
+	assert.Equal(t, "test-read-error: read failed at offset 3: connection reset", err.Error())
+	readError, ok := err.(*ReadError)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), readError.Offset)
+	assert.Equal(t, readErr, errors.Cause(err))
+	require.NoError(t, acc.Close())
+
+	// Retry errors are still retried
+	in = ioutil.NopCloser(&errorReader{err: fserrors.RetryErrorf("retry me")})
+	acc = NewAccountSizeName(in, 10, "test-read-error-retry")
+	_, err = acc.Read(make([]byte, 1))
+	assert.True(t, fserrors.IsRetryError(err))
+	require.NoError(t, acc.Close())
+
+	// EOF errors aren't wrapped
+	in = ioutil.NopCloser(&errorReader{err: io.ErrUnexpectedEOF})
+	acc = NewAccountSizeName(in, 10, "test-read-error-eof")
+	_, err = acc.Read(make([]byte, 1))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	require.NoError(t, acc.Close())
+}
+
+// writerToReader is an io.Reader which is also an io.WriterTo
+type writerToReader struct {
+	io.Reader
+	used bool
+}
+
+func (r *writerToReader) WriteTo(w io.Writer) (n int64, err error) {
+	r.used = true
+	return io.Copy(w, r.Reader)
+}
+
+func TestAccountWriteToDelegates(t *testing.T) {
+	data := bytes.Repeat([]byte("hello"), writeToBufferSize)
+	inner := &writerToReader{Reader: bytes.NewReader(data)}
+	in := struct {
+		io.WriterTo
+		io.ReadCloser
+	}{inner, ioutil.NopCloser(inner)}
+	acc, err := NewAccountSizeName(in, int64(len(data)), "test-delegate").WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+	var progress int
+	acc.SetProgressCallback(func(n int, total int64) {
+		assert.True(t, n <= writeToBufferSize)
+		progress++
+	})
+
+	out := &bytes.Buffer{}
+	n, err := acc.WriteTo(out)
+	require.NoError(t, err)
+	assert.True(t, inner.used)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, out.Bytes())
+	assert.Equal(t, int64(len(data)), acc.Snapshot().Bytes)
+	assert.True(t, progress >= 5, progress)
+	_, complete := acc.Hashes()
+	assert.True(t, complete)
+	require.NoError(t, acc.Close())
+
+	// Through the async buffer
+	const size = 3*asyncreader.BufferSize + 17
+	data = make([]byte, size)
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewReader(data)), size, "test-delegate-buffer").WithBuffer()
+	_, ok := acc.in.(*asyncreader.AsyncReader)
+	require.True(t, ok)
+	out.Reset()
+	n, err = io.Copy(out, acc)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), n)
+	assert.Equal(t, size, out.Len())
+	assert.Equal(t, int64(size), acc.Snapshot().Bytes)
+	require.NoError(t, acc.Close())
+
+	// Errors from the stream have the offset
+	inner = &writerToReader{Reader: io.MultiReader(bytes.NewReader([]byte{1, 2}), &errorReader{err: errors.New("boom")})}
+	in.WriterTo, in.ReadCloser = inner, ioutil.NopCloser(inner)
+	acc = NewAccountSizeName(in, 10, "test-delegate-error")
+	_, err = acc.WriteTo(out)
+	assert.EqualError(t, err, "test-delegate-error: read failed at offset 2: boom")
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountSetName(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	s.Transferring("hashing")
+	acc := NewAccountSizeNameContext(ctx, in, 3, "hashing")
+	assert.True(t, s.inProgress.get("hashing") == acc)
+
+	acc.SetName("uploading")
+	assert.Equal(t, "uploading", acc.Name())
+	assert.Nil(t, s.inProgress.get("hashing"))
+	assert.True(t, s.inProgress.get("uploading") == acc)
+	assert.Regexp(t, `^uploading: `, strings.TrimSpace(acc.String()))
+	assert.Equal(t, "uploading", acc.Snapshot().Name)
+
+	// The stats still show the transfer under its new name
+	out := s.String()
+	assert.Contains(t, out, "Transferring:\n * uploading:  0% /3, 0 B/s, -\n")
+	assert.NotContains(t, out, " * hashing")
+
+	require.NoError(t, acc.Close())
+	s.DoneTransferring("hashing", true)
+	assert.Nil(t, s.inProgress.get("uploading"))
+	assert.NotContains(t, s.String(), "Transferring:")
+
+	// A finished transfer doesn't remove another with the same name
+	acc1 := NewAccountSizeNameContext(ctx, ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "same")
+	acc2 := NewAccountSizeNameContext(ctx, ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "same")
+	require.NoError(t, acc1.Close())
+	assert.True(t, s.inProgress.get("same") == acc2)
+	require.NoError(t, acc2.Close())
+}
+
+func TestAccountStringThrottled(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	assert.Equal(t, "test:  0% /3, 0 B/s, -", strings.TrimSpace(acc.StringThrottled(time.Hour)))
+
+	// Cached until the interval is up
+	_, err := acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+	assert.Equal(t, "test:  0% /3, 0 B/s, -", strings.TrimSpace(acc.StringThrottled(time.Hour)))
+
+	acc.statmu.Lock()
+	acc.strAt = acc.strAt.Add(-time.Hour)
+	acc.statmu.Unlock()
+	assert.Contains(t, acc.StringThrottled(time.Hour), "test: 66% /3")
+	assert.Contains(t, acc.StringThrottled(0), "test: 66% /3")
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountIsBuffered(t *testing.T) {
+	// Too small to buffer
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test-small").WithBuffer()
+	assert.False(t, acc.IsBuffered())
+	assert.Equal(t, 0, acc.Buffers())
+	require.NoError(t, acc.Close())
+
+	in = ioutil.NopCloser(bytes.NewBuffer(nil))
+	acc = NewAccountSizeName(in, 3*asyncreader.BufferSize, "test-big").WithBuffer()
+	assert.True(t, acc.IsBuffered())
+	assert.True(t, acc.Snapshot().Buffered)
+	assert.Equal(t, 3, acc.Buffers())
+	acc.StopBuffering()
+	assert.False(t, acc.IsBuffered())
+	assert.Equal(t, 0, acc.Buffers())
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountIdleTimeout(t *testing.T) {
+	// A transfer which makes progress isn't aborted
+	r, w := io.Pipe()
+	acc := NewAccountSizeName(r, -1, "test")
+	acc.SetIdleTimeout(100 * time.Millisecond)
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(20 * time.Millisecond)
+			_, _ = w.Write([]byte{1})
+		}
+		_ = w.Close()
+	}()
+	data, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	assert.Equal(t, 10, len(data))
+	require.NoError(t, acc.Close())
+
+	// One which doesn't is
+	r, w = io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc = NewAccountSizeName(r, -1, "test")
+	acc.SetIdleTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err = acc.Read(make([]byte, 1))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	require.IsType(t, &IdleTimeoutError{}, err)
+	assert.Equal(t, "test: transfer timed out: no data transferred for 50ms", err.Error())
+	assert.True(t, fserrors.IsRetryError(err))
+	_, err = acc.Read(make([]byte, 1))
+	assert.IsType(t, &IdleTimeoutError{}, err)
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountIdleTimeoutBuffered(t *testing.T) {
+	// The buffer is full but nothing is being read from it
+	data := make([]byte, 1024)
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	go func() { _, _ = w.Write(data) }()
+	acc := NewAccountSizeName(r, -1, "test").WithBuffer()
+	require.True(t, acc.IsBuffered())
+	acc.SetIdleTimeout(50 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	_, err := acc.Read(make([]byte, 1))
+	assert.IsType(t, &IdleTimeoutError{}, err)
+	assert.NoError(t, acc.Close())
+
+	// Pausing doesn't count as being idle
+	r, w = io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc = NewAccountSizeName(r, -1, "test")
+	acc.SetIdleTimeout(50 * time.Millisecond)
+	acc.Pause()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, acc.cancelled())
+	acc.Resume()
+	time.Sleep(100 * time.Millisecond)
+	assert.IsType(t, &IdleTimeoutError{}, acc.cancelled())
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountReadCloseRace(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	for i := 0; i < 20; i++ {
+		acc := NewAccountSizeNameContext(ctx, ioutil.NopCloser(zeroReader{}), -1, "test")
+		stream := acc.WrapStream(zeroReader{})
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for _, in := range []io.Reader{acc, stream, stream} {
+			wg.Add(1)
+			go func(in io.Reader) {
+				defer wg.Done()
+				buf := make([]byte, 16)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					_, _ = in.Read(buf)
+				}
+			}(in)
+		}
+		time.Sleep(time.Millisecond)
+		require.NoError(t, acc.Close())
+
+		// Reads after the Close still return the data but
+		// aren't accounted
+		bytes := s.GetBytes()
+		accBytes := acc.Snapshot().Bytes
+		n, err := stream.Read(make([]byte, 16))
+		assert.NoError(t, err)
+		assert.Equal(t, 16, n)
+		close(stop)
+		wg.Wait()
+		assert.Equal(t, bytes, s.GetBytes())
+		assert.Equal(t, accBytes, acc.Snapshot().Bytes)
+		assert.Nil(t, s.inProgress.get("test"))
+	}
+}
+
+func TestAccountSetAlreadyDone(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 40)))
+	acc := NewAccountSizeName(in, 100, "test")
+	assert.Error(t, acc.SetAlreadyDone(-1))
+	assert.Error(t, acc.SetAlreadyDone(101))
+	require.NoError(t, acc.SetAlreadyDone(60))
+
+	// The percentage is right straight away
+	s := acc.Snapshot()
+	assert.Equal(t, int64(0), s.Bytes)
+	assert.Equal(t, int64(60), s.AlreadyDone)
+	assert.Equal(t, 60, s.Percentage)
+	assert.Contains(t, acc.String(), "60% /100")
+
+	_, err := acc.Read(make([]byte, 10))
+	require.NoError(t, err)
+	a, b := acc.progress()
+	assert.Equal(t, int64(70), a)
+	assert.Equal(t, int64(100), b)
+
+	// but only the bytes read count towards the bytes transferred
+	s = acc.Snapshot()
+	assert.Equal(t, int64(10), s.Bytes)
+	assert.Equal(t, 70, s.Percentage)
+	require.NoError(t, acc.Close())
+
+	// Any amount is OK if the size is unknown
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), -1, "test")
+	assert.NoError(t, acc.SetAlreadyDone(1000))
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountWrapStreamCounter(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer(nil))
+	acc := NewAccountSizeName(in, 6, "test")
+	counter := NewCounter(4)
+	assert.Equal(t, int64(4), counter.Size())
+
+	r := acc.WrapStreamCounter(bytes.NewBuffer([]byte{1, 2, 3, 4}), counter)
+	n, err := r.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, int64(3), counter.Bytes())
+	assert.Equal(t, int64(3), acc.Snapshot().Bytes)
+
+	// The counter is kept if the stream is unwrapped and rewrapped
+	unwrapped, wrap := UnWrap(r)
+	r = wrap(unwrapped)
+	_, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), counter.Bytes())
+
+	// Other streams only count in the parent
+	_, err = ioutil.ReadAll(acc.WrapStream(bytes.NewBuffer([]byte{5, 6})))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), counter.Bytes())
+	assert.Equal(t, int64(6), acc.Snapshot().Bytes)
+	require.NoError(t, acc.Close())
+}
+
+func TestPercentageString(t *testing.T) {
+	oldDecimals, oldSize := fs.Config.StatsPercentDecimals, fs.Config.StatsDecimalsSize
+	defer func() {
+		fs.Config.StatsPercentDecimals, fs.Config.StatsDecimalsSize = oldDecimals, oldSize
+	}()
+	fs.Config.StatsPercentDecimals = 1
+	fs.Config.StatsDecimalsSize = 1000
+	for _, test := range []struct {
+		bytes, size int64
+		want        string
+	}{
+		{0, 999, " 0%"},
+		{998, 999, "99%"},
+		{999, 999, "100%"},
+		{0, 1000, " 0.0%"},
+		{34, 1000, " 3.4%"},
+		{999, 1000, "99.9%"},
+		{1000, 1000, "100.0%"},
+		{500e9 - 1, 500e9, "99.9%"},
+		{1<<62 - 1, 1 << 62, "99.9%"},
+	} {
+		assert.Equal(t, test.want, percentageString(test.bytes, test.size), fmt.Sprintf("%d/%d", test.bytes, test.size))
+	}
+
+	fs.Config.StatsPercentDecimals = 2
+	assert.Equal(t, " 3.45%", percentageString(3456, 100000))
+
+	// The stats line uses it
+	fs.Config.StatsPercentDecimals = 1
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(make([]byte, 34))), 1000, "test")
+	_, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	assert.Contains(t, acc.String(), "test:  3.4% /1000,")
+	require.NoError(t, acc.Close())
+}
+
+func TestRoundETA(t *testing.T) {
+	oldResolution := fs.Config.StatsETAResolution
+	defer func() {
+		fs.Config.StatsETAResolution = oldResolution
+	}()
+	for _, test := range []struct {
+		resolution time.Duration
+		eta        time.Duration
+		want       time.Duration
+	}{
+		{0, 83 * time.Minute, 83 * time.Minute},
+		{0, -time.Second, 0},
+		{10 * time.Second, -time.Second, 0},
+		{10 * time.Second, 59 * time.Second, 59 * time.Second},
+		{10 * time.Second, time.Minute, time.Minute},
+		{10 * time.Second, 61 * time.Second, time.Minute},
+		{10 * time.Second, 65 * time.Second, 70 * time.Second},
+		{time.Minute, 83*time.Minute + 29*time.Second, 83 * time.Minute},
+		{time.Minute, 83*time.Minute + 30*time.Second, 84 * time.Minute},
+		{time.Hour, 2 * time.Minute, 2 * time.Minute},
+	} {
+		fs.Config.StatsETAResolution = test.resolution
+		assert.Equal(t, test.want, roundETA(test.eta), fmt.Sprintf("%v at %v", test.eta, test.resolution))
+	}
+
+	// calculateETA rounds
+	fs.Config.StatsETAResolution = time.Minute
+	eta, ok := calculateETA(1000, 1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 17*time.Minute, eta)
+}
+
+func TestETAString(t *testing.T) {
+	oldShow := fs.Config.StatsShowDoneTime
+	defer func() {
+		fs.Config.StatsShowDoneTime = oldShow
+	}()
+	// A zone with an odd offset to check the rounding is local
+	zone := time.FixedZone("test", 5*3600+45*60)
+	now := time.Date(2019, 3, 4, 20, 8, 20, 0, zone)
+
+	fs.Config.StatsShowDoneTime = false
+	assert.Equal(t, "7h32m0s", etaString(7*time.Hour+32*time.Minute, now))
+
+	fs.Config.StatsShowDoneTime = true
+	for _, test := range []struct {
+		eta  time.Duration
+		want string
+	}{
+		{10 * time.Second, "10s (done ~20:09)"},
+		{50 * time.Second, "50s (done ~20:09)"},
+		{7*time.Hour + 32*time.Minute, "7h32m0s (done ~03:40)"},
+		{7*time.Hour + 34*time.Minute, "7h34m0s (done ~03:40)"},
+		{50 * time.Hour, "50h0m0s (done ~2019-03-06 22:00)"},
+	} {
+		assert.Equal(t, test.want, etaString(test.eta, now), test.eta.String())
+	}
+}
+
+func TestAccountAbort(t *testing.T) {
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc := NewAccountSizeName(r, 100, "test-abort")
+	assert.NoError(t, acc.Err())
+
+	// A Read blocked in the stream returns the cause
+	errs := make(chan error)
+	go func() {
+		_, err := acc.Read(make([]byte, 10))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cause := errors.New("user aborted")
+	acc.Abort(cause)
+	assert.Equal(t, cause, <-errs)
+
+	// and so do all the Reads after
+	_, err := acc.Read(make([]byte, 10))
+	assert.Equal(t, cause, err)
+	assert.Equal(t, cause, acc.Err())
+	assert.Nil(t, Stats.inProgress.get("test-abort"))
+
+	// Aborting again keeps the first cause
+	acc.Abort(errors.New("potato"))
+	assert.Equal(t, cause, acc.Err())
+	assert.NoError(t, acc.Close())
+
+	// nil is ErrorTransferAborted
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "test-abort-nil")
+	acc.Abort(nil)
+	assert.Equal(t, ErrorTransferAborted, acc.Err())
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountSetMaxBytes(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4, 5, 6}))
+	acc := NewAccountSizeName(in, -1, "test")
+	acc.SetMaxBytes(4)
+
+	// The bytes up to the limit are returned with the error
+	buf := make([]byte, 3)
+	n, err := acc.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = acc.Read(buf)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []byte{4}, buf[:n])
+	require.IsType(t, &MaxBytesError{}, err)
+	assert.Equal(t, int64(4), err.(*MaxBytesError).Max)
+	assert.Equal(t, "test: read more than the limit of 4 bytes", err.Error())
+	assert.Equal(t, int64(4), acc.Snapshot().Bytes)
+
+	// and the transfer is aborted
+	assert.Equal(t, err, acc.Err())
+	_, err2 := acc.Read(buf)
+	assert.Equal(t, err, err2)
+	require.NoError(t, acc.Close())
+
+	// Exactly the size is fine
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4}))
+	acc = NewAccountSizeName(in, 4, "test").WithSizeLimit()
+	_, err = ioutil.ReadAll(acc)
+	assert.NoError(t, err)
+	require.NoError(t, acc.Close())
+
+	// but more isn't
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4, 5}))
+	acc = NewAccountSizeName(in, 4, "test").WithSizeLimit()
+	data, err := ioutil.ReadAll(acc)
+	assert.IsType(t, &MaxBytesError{}, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, data)
+	require.NoError(t, acc.Close())
+
+	// WithSizeLimit does nothing if the size isn't known
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4, 5}))
+	acc = NewAccountSizeName(in, -1, "test").WithSizeLimit()
+	_, err = ioutil.ReadAll(acc)
+	assert.NoError(t, err)
+	require.NoError(t, acc.Close())
+	// The limit applies to io.Copy through the async buffer too
+	in = ioutil.NopCloser(bytes.NewBuffer(make([]byte, 3*1024*1024)))
+	acc = NewAccountSizeName(in, -1, "test").WithBuffer()
+	_, ok := acc.in.(io.WriterTo)
+	require.True(t, ok)
+	acc.SetMaxBytes(100)
+	out := &bytes.Buffer{}
+	n64, err := io.Copy(out, acc)
+	assert.IsType(t, &MaxBytesError{}, err)
+	assert.Equal(t, int64(100), n64)
+	assert.Equal(t, 100, out.Len())
+	assert.Equal(t, int64(100), acc.Snapshot().Bytes)
+	assert.Equal(t, err, acc.Err())
+	require.NoError(t, acc.Close())
+}
@@ -9,8 +9,6 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-
-	"github.com/ncw/rclone/fs"
 )
 
 // startSignalHandler() sets a signal handler to catch SIGUSR2 and toggle throttling.
@@ -22,15 +20,20 @@ func startSignalHandler() {
 		// This runs forever, but blocks until the signal is received.
 		for {
 			<-signals
-			tokenBucketMu.Lock()
-			bwLimitToggledOff = !bwLimitToggledOff
-			tokenBucket, prevTokenBucket = prevTokenBucket, tokenBucket
-			s := "disabled"
-			if tokenBucket != nil {
-				s = "enabled"
-			}
-			tokenBucketMu.Unlock()
-			fs.Logf(nil, "Bandwidth limit %s by user", s)
+			toggleBandwidthLimit()
+		}
+	}()
+}
+
+// startStatsSignalHandler() sets a signal handler to catch SIGUSR1 and log all the stats.
+func startStatsSignalHandler() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	go func() {
+		for {
+			<-signals
+			AggregateStats().LogFull()
 		}
 	}()
 }
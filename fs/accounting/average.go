@@ -0,0 +1,208 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+)
+
+// How often the moving averages are updated
+const (
+	averageInterval    = time.Second            // normally
+	minAverageInterval = 100 * time.Millisecond // at most this often for short windows
+)
+
+// tickInterval returns how often the moving averages are updated.
+//
+// This is averageInterval unless fs.Config.StatsAvgWindow is so short
+// that would make the moving average only a few ticks long, in which
+// case it is shortened so the window is at least 10 ticks.
+func tickInterval() time.Duration {
+	window := fs.Config.StatsAvgWindow
+	if window <= 0 || window >= 10*averageInterval {
+		return averageInterval
+	}
+	interval := window / 10
+	if interval < minAverageInterval {
+		interval = minAverageInterval
+	}
+	return interval
+}
+
+// speedHistory is a fixed size ring of the most recent speed samples
+type speedHistory struct {
+	samples []float64 // the samples - a ring once it is full
+	next    int       // index of the oldest sample once full
+}
+
+// newSpeedHistory makes a speedHistory which keeps size samples.  It
+// keeps none if size <= 0.
+func newSpeedHistory(size int) speedHistory {
+	if size <= 0 {
+		return speedHistory{}
+	}
+	return speedHistory{samples: make([]float64, 0, size)}
+}
+
+// add adds a sample, replacing the oldest if the ring is full
+func (h *speedHistory) add(sample float64) {
+	switch {
+	case cap(h.samples) == 0:
+	case len(h.samples) < cap(h.samples):
+		h.samples = append(h.samples, sample)
+	default:
+		h.samples[h.next] = sample
+		h.next = (h.next + 1) % len(h.samples)
+	}
+}
+
+// last returns a copy of the last n samples, oldest first, or fewer
+// if there aren't that many
+func (h *speedHistory) last(n int) []float64 {
+	size := len(h.samples)
+	if n > size {
+		n = size
+	}
+	if n <= 0 {
+		return nil
+	}
+	out := make([]float64, n)
+	start := h.next + size - n
+	for i := range out {
+		out[i] = h.samples[(start+i)%size]
+	}
+	return out
+}
+
+// average returns the mean of the last n samples.  ok is false if
+// there aren't that many.
+func (h *speedHistory) average(n int) (avg float64, ok bool) {
+	size := len(h.samples)
+	if n <= 0 || n > size {
+		return 0, false
+	}
+	start := h.next + size - n
+	for i := 0; i < n; i++ {
+		avg += h.samples[(start+i)%size]
+	}
+	return avg / float64(n), true
+}
+
+// reset removes all the samples
+func (h *speedHistory) reset() {
+	h.samples = h.samples[:0]
+	h.next = 0
+}
+
+// averageTicker is something which has its moving averages updated
+// by the averager, eg an Account, an AccountGroup or a StatsInfo
+// with subscribers
+type averageTicker interface {
+	averageTick(now time.Time)
+}
+
+// averager updates the moving averages of all the registered
+// Accounts from a single goroutine rather than having a goroutine
+// and ticker per Account.
+//
+// The goroutine is only running while there are Accounts registered.
+type averager struct {
+	mu      sync.Mutex
+	accs    map[averageTicker]struct{}
+	running bool // set if the goroutine is running
+}
+
+// averages is the global averager for all the Accounts
+var averages = newAverager()
+
+// newAverager makes a new averager
+func newAverager() *averager {
+	return &averager{
+		accs: make(map[averageTicker]struct{}),
+	}
+}
+
+// add registers acc to have its averages updated, starting the
+// goroutine if necessary
+func (a *averager) add(acc averageTicker) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accs[acc] = struct{}{}
+	if !a.running {
+		a.running = true
+		interval := tickInterval()
+		go a.loop(clk.NewTicker(interval), interval)
+	}
+}
+
+// remove stops acc having its averages updated
+func (a *averager) remove(acc averageTicker) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.accs, acc)
+}
+
+// accounts returns the registered Accounts, reusing the memory of buf
+// if possible.  If there are none it marks the goroutine as stopped
+// and returns nil.
+func (a *averager) accounts(buf []averageTicker) []averageTicker {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.accs) == 0 {
+		a.running = false
+		return nil
+	}
+	accs := buf[:0]
+	for acc := range a.accs {
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
+// addSpeedSamples adds the total speed at this tick of the Accounts
+// in accs to the StatsInfo they are accounted in.  Paused Accounts
+// count as not transferring.
+func addSpeedSamples(accs []averageTicker, interval time.Duration) {
+	totals := make(map[*StatsInfo]float64)
+	for _, ticker := range accs {
+		acc, ok := ticker.(*Account)
+		if !ok {
+			continue
+		}
+		acc.statmu.Lock()
+		speed := acc.lpSpeed
+		if acc.resume != nil {
+			speed = 0
+		}
+		acc.statmu.Unlock()
+		totals[acc.stats] += speed
+	}
+	for s, speed := range totals {
+		s.addSpeedSample(speed, interval)
+	}
+}
+
+// loop updates the averages of the registered Accounts and rebalances
+// their shares of the bandwidth limit every tick, which ticks every
+// interval, until there are none left
+func (a *averager) loop(tick ticker, interval time.Duration) {
+	defer tick.Stop()
+	var accs []averageTicker
+	for now := range tick.Chan() {
+		accs = a.accounts(accs)
+		if accs == nil {
+			return
+		}
+		for _, acc := range accs {
+			acc.averageTick(now)
+		}
+		rebalanceShares(accs)
+		addSpeedSamples(accs, interval)
+		publishSamples(accs, now)
+		// Don't keep finished Accounts alive until the next tick
+		for i := range accs {
+			accs[i] = nil
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package accounting
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAverageNoGoroutineLeak(t *testing.T) {
+	const n = 10000
+	averages.mu.Lock()
+	registered := len(averages.accs)
+	averages.mu.Unlock()
+	before := runtime.NumGoroutine()
+	accs := make([]*Account, n)
+	for i := range accs {
+		in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+		accs[i] = NewAccountSizeName(in, 1, fmt.Sprintf("leak-%d", i))
+	}
+	// Should only have started the averager goroutine at most
+	assert.True(t, runtime.NumGoroutine() <= before+1, "goroutines before %d after %d", before, runtime.NumGoroutine())
+	for _, acc := range accs {
+		assert.NoError(t, acc.Close())
+	}
+	averages.mu.Lock()
+	assert.Equal(t, registered, len(averages.accs))
+	averages.mu.Unlock()
+}
+
+func TestAverageTick(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	start := acc.lpTime
+
+	// A tick from before the Account was made should be ignored
+	acc.lpBytes = 100
+	acc.averageTick(start.Add(-time.Second))
+	assert.Equal(t, 100, acc.lpBytes)
+	assert.Equal(t, start, acc.lpTime)
+
+	now := start.Add(2 * time.Second)
+	acc.averageTick(now)
+	assert.Equal(t, 0, acc.lpBytes)
+	assert.Equal(t, now, acc.lpTime)
+	assert.Equal(t, 50.0, acc.avg.Value())
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestTickInterval(t *testing.T) {
+	oldWindow := fs.Config.StatsAvgWindow
+	defer func() {
+		fs.Config.StatsAvgWindow = oldWindow
+	}()
+	for _, test := range []struct {
+		window time.Duration
+		want   time.Duration
+	}{
+		{0, averageInterval},
+		{time.Hour, averageInterval},
+		{10 * time.Second, averageInterval},
+		{2 * time.Second, 200 * time.Millisecond},
+		{time.Second, minAverageInterval},
+		{time.Millisecond, minAverageInterval},
+	} {
+		fs.Config.StatsAvgWindow = test.window
+		assert.Equal(t, test.want, tickInterval(), test.window.String())
+	}
+}
+
+// BenchmarkAverageTick measures a tick of the averager with 5000
+// transfers in progress
+func BenchmarkAverageTick(b *testing.B) {
+	const n = 5000
+	accs := make([]*Account, n)
+	for i := range accs {
+		in := ioutil.NopCloser(bytes.NewBuffer(nil))
+		accs[i] = NewAccountSizeName(in, 1, fmt.Sprintf("bench-%d", i))
+	}
+	var buf []averageTicker
+	now := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(averageInterval)
+		buf = averages.accounts(buf)
+		for _, acc := range buf {
+			acc.averageTick(now)
+		}
+		rebalanceShares(buf)
+	}
+	b.StopTimer()
+	for _, acc := range accs {
+		_ = acc.Close()
+	}
+}
+
+func TestSpeedHistory(t *testing.T) {
+	h := newSpeedHistory(3)
+	assert.Nil(t, h.last(3))
+	h.add(1)
+	h.add(2)
+	assert.Equal(t, []float64{1, 2}, h.last(3))
+	assert.Equal(t, []float64{2}, h.last(1))
+	h.add(3)
+	h.add(4)
+	h.add(5)
+	assert.Equal(t, []float64{3, 4, 5}, h.last(3))
+	assert.Equal(t, []float64{4, 5}, h.last(2))
+	assert.Equal(t, []float64{3, 4, 5}, h.last(10))
+	assert.Nil(t, h.last(0))
+	h.reset()
+	assert.Nil(t, h.last(3))
+
+	// No samples are kept with a size of 0
+	h = newSpeedHistory(0)
+	h.add(1)
+	assert.Nil(t, h.last(1))
+}
+
+func TestAccountSpeedHistory(t *testing.T) {
+	oldHistory := fs.Config.StatsSpeedHistory
+	defer func() { fs.Config.StatsSpeedHistory = oldHistory }()
+	fs.Config.StatsSpeedHistory = 2
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	now := acc.lpTime
+	for _, n := range []int{10, 20, 30} {
+		acc.lpBytes = n
+		now = now.Add(time.Second)
+		acc.averageTick(now)
+	}
+	assert.Equal(t, []float64{20, 30}, acc.SpeedHistory(5))
+
+	acc.ResetStats()
+	assert.Nil(t, acc.SpeedHistory(5))
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestAccountWindowSpeed(t *testing.T) {
+	oldHistory, oldWindow := fs.Config.StatsSpeedHistory, fs.Config.StatsAvgWindow
+	defer func() { fs.Config.StatsSpeedHistory, fs.Config.StatsAvgWindow = oldHistory, oldWindow }()
+	fs.Config.StatsSpeedHistory = 4
+	fs.Config.StatsAvgWindow = 0
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test")
+	_, ok := acc.WindowSpeed(time.Minute)
+	assert.False(t, ok)
+
+	// Fast then slow
+	now := acc.lpTime
+	for _, n := range []int{1000, 1000, 10, 30} {
+		acc.lpBytes = n
+		now = now.Add(time.Second)
+		acc.averageTick(now)
+	}
+	bps, ok := acc.WindowSpeed(2 * time.Second)
+	require.True(t, ok)
+	assert.Equal(t, 20.0, bps)
+
+	// Rounded up to whole samples
+	bps, _ = acc.WindowSpeed(1500 * time.Millisecond)
+	assert.Equal(t, 20.0, bps)
+	bps, _ = acc.WindowSpeed(0)
+	assert.Equal(t, 30.0, bps)
+
+	// Cut short to the samples kept
+	bps, ok = acc.WindowSpeed(time.Hour)
+	require.True(t, ok)
+	assert.Equal(t, 510.0, bps)
+
+	assert.NoError(t, acc.Close())
+}
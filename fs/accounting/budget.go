@@ -0,0 +1,66 @@
+package accounting
+
+import (
+	"sync/atomic"
+
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/pkg/errors"
+)
+
+// ErrorByteBudgetExceeded is returned from Read (and Write) once the
+// budget set with SetByteBudget has been used up.  It is a fatal error
+// so the sync stops starting new transfers.
+var ErrorByteBudgetExceeded = fserrors.FatalError(errors.New("byte budget exceeded"))
+
+// The byte budget shared by all the StatsInfo - use atomic
+var (
+	byteBudget  int64 = -1 // bytes which may be transferred - < 0 for no budget
+	budgetSpent int64      // bytes transferred since the budget was set
+)
+
+// SetByteBudget allows n more bytes to be transferred in total by all
+// the transfers, whichever StatsInfo they are accounted in, eg to
+// limit the egress which has to be paid for.  A budget < 0 means there
+// is no budget, which is the default.
+//
+// Once the budget has been used up the next Read (or Write) of each
+// transfer returns ErrorByteBudgetExceeded instead of reading more, so
+// the transfers stop between reads rather than in the middle of one.
+// The read which uses up the budget is allowed to finish so the budget
+// may be exceeded by up to a read for each transfer.
+//
+// Bytes of transfers which are retried still count as they were
+// transferred.  The reads for checks don't count.
+func SetByteBudget(n int64) {
+	atomic.StoreInt64(&budgetSpent, 0)
+	atomic.StoreInt64(&byteBudget, n)
+}
+
+// ByteBudgetRemaining returns the bytes left of the budget set with
+// SetByteBudget, 0 once it has been used up.  ok is false if there is
+// no budget.
+func ByteBudgetRemaining() (remaining int64, ok bool) {
+	budget := atomic.LoadInt64(&byteBudget)
+	if budget < 0 {
+		return 0, false
+	}
+	remaining = budget - atomic.LoadInt64(&budgetSpent)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// spendBudget counts bytes transferred against the budget
+func spendBudget(bytes int64) {
+	atomic.AddInt64(&budgetSpent, bytes)
+}
+
+// checkByteBudget returns ErrorByteBudgetExceeded if the budget set
+// with SetByteBudget has been used up
+func checkByteBudget() error {
+	if remaining, ok := ByteBudgetRemaining(); ok && remaining <= 0 {
+		return ErrorByteBudgetExceeded
+	}
+	return nil
+}
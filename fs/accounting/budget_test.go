@@ -0,0 +1,64 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteBudget(t *testing.T) {
+	defer SetByteBudget(-1)
+
+	_, ok := ByteBudgetRemaining()
+	assert.False(t, ok)
+
+	SetByteBudget(5)
+	remaining, ok := ByteBudgetRemaining()
+	require.True(t, ok)
+	assert.Equal(t, int64(5), remaining)
+
+	// The budget is shared between the StatsInfo
+	s1, s2 := NewStats(), NewStats()
+	acc1 := NewAccountSizeNameContext(WithStats(context.Background(), s1), ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10))), 10, "one")
+	acc2 := NewAccountSizeNameContext(WithStats(context.Background(), s2), ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10))), 10, "two")
+	check := NewAccountCheck(ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10))), 10, "check")
+	n, err := acc1.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Contains(t, s1.String(), "Budget:           2 Bytes left\n")
+
+	// The read which uses up the budget finishes
+	n, err = acc2.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	remaining, ok = ByteBudgetRemaining()
+	require.True(t, ok)
+	assert.Equal(t, int64(0), remaining)
+
+	// Then no more can be read
+	_, err = acc1.Read(make([]byte, 1))
+	assert.Equal(t, ErrorByteBudgetExceeded, err)
+	assert.True(t, fserrors.IsFatalError(err))
+	_, err = acc2.Read(make([]byte, 1))
+	assert.Equal(t, ErrorByteBudgetExceeded, err)
+
+	// Except for checks
+	_, err = check.Read(make([]byte, 1))
+	assert.NoError(t, err)
+
+	// Setting a new budget lets the transfers carry on
+	SetByteBudget(100)
+	_, err = acc1.Read(make([]byte, 1))
+	assert.NoError(t, err)
+	remaining, _ = ByteBudgetRemaining()
+	assert.Equal(t, int64(99), remaining)
+
+	require.NoError(t, acc1.Close())
+	require.NoError(t, acc2.Close())
+	require.NoError(t, check.Close())
+}
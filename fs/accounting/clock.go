@@ -0,0 +1,71 @@
+package accounting
+
+import "time"
+
+// clock is where the accounting gets the time from so that tests can
+// control it
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	NewTimer(d time.Duration) timer
+	Sleep(d time.Duration)
+}
+
+// ticker is the part of a *time.Ticker the accounting uses
+type ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// timer is the part of a *time.Timer the accounting uses
+type timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+}
+
+// clk is the clock used by the accounting.  It is only changed by
+// tests, and only while no other goroutines are using it.
+var clk clock = realClock{}
+
+// realClock is a clock using the time package
+type realClock struct{}
+
+// Now returns the current time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a ticker which ticks every d
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// NewTimer returns a timer which fires once after d
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// Sleep pauses the current goroutine for d
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// realTicker is a ticker using a *time.Ticker
+type realTicker struct {
+	*time.Ticker
+}
+
+// Chan returns the channel the ticks are delivered on
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// realTimer is a timer using a *time.Timer
+type realTimer struct {
+	*time.Timer
+}
+
+// Chan returns the channel the timer fires on
+func (t realTimer) Chan() <-chan time.Time {
+	return t.C
+}
@@ -0,0 +1,444 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VividCortex/ewma"
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock which only moves when it is advanced
+//
+// Its tickers are real so the averager carries on working for any
+// other Accounts.  Tick Accounts by hand with averageTick(clk.Now()),
+// or run an averager loop on a manualTicker.
+//
+// Its timers, and so Sleep, only fire when it is advanced past them.
+// Use waitTimers to wait for a goroutine to start waiting on one.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer // timers which haven't fired or been stopped
+}
+
+// fakeTimer is a timer of a fakeClock
+type fakeTimer struct {
+	c  *fakeClock
+	at time.Time      // when it fires
+	ch chan time.Time // has room for the time it fired
+}
+
+// Chan returns the channel the timer fires on
+func (t *fakeTimer) Chan() <-chan time.Time {
+	return t.ch
+}
+
+// Stop stops the timer returning false if it had already fired
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, timer := range t.c.timers {
+		if timer == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NewTimer returns a timer which fires when the clock has been
+// advanced by d
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	return t
+}
+
+// Sleep waits until the clock has been advanced by d
+func (c *fakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).Chan()
+}
+
+// waitTimers waits until there are at least n timers waiting to fire
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		waiting := len(c.timers)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d timers - got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Now returns the fake time
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a real ticker
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	return realClock{}.NewTicker(d)
+}
+
+// advance moves the fake time on by d firing any timers which are due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			waiting = append(waiting, t)
+		} else {
+			t.ch <- c.now
+		}
+	}
+	c.timers = waiting
+}
+
+// useFakeClock replaces clk with a fakeClock returning a function to
+// put it back.
+//
+// The fake time is well in the future so that the ticks of the real
+// averager are ignored by Accounts made with it.
+func useFakeClock() (*fakeClock, func()) {
+	c := &fakeClock{now: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)}
+	old := clk
+	clk = c
+	return c, func() { clk = old }
+}
+
+// manualTicker is a ticker which only ticks when tick is called so the
+// averager loop can be run at exact times
+type manualTicker struct {
+	c       chan time.Time
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// newManualTicker makes a manualTicker
+func newManualTicker() *manualTicker {
+	return &manualTicker{
+		c:       make(chan time.Time),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Chan returns the channel the ticks are delivered on
+func (t *manualTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+// Stop marks the ticker as stopped
+func (t *manualTicker) Stop() {
+	t.once.Do(func() { close(t.stopped) })
+}
+
+// tick delivers a tick at now, waiting until it has been received
+func (t *manualTicker) tick(now time.Time) {
+	t.c <- now
+}
+
+func TestAccountSpeedFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 1000)))
+	acc := NewAccountSizeName(in, 1000, "test")
+	buf := make([]byte, 10)
+
+	// Read 10 bytes a second until the moving average has warmed up
+	ticks := int(ewma.WARMUP_SAMPLES) + 1
+	for i := 0; i < ticks; i++ {
+		_, err := acc.Read(buf)
+		require.NoError(t, err)
+		c.advance(time.Second)
+		acc.averageTick(clk.Now())
+	}
+	bps, current := acc.speed()
+	assert.Equal(t, 10.0, bps)
+	assert.InDelta(t, 10.0, current, 1e-9)
+
+	eta, ok := acc.eta()
+	require.True(t, ok)
+	assert.Equal(t, time.Duration(1000-10*ticks)*time.Second/10, eta)
+	assert.Equal(t, time.Duration(ticks)*time.Second, acc.elapsed())
+
+	// Taking twice as long halves the average speed
+	c.advance(time.Duration(ticks) * time.Second)
+	bps, _ = acc.speed()
+	assert.Equal(t, 5.0, bps)
+	require.NoError(t, acc.Close())
+}
+
+func TestAveragerLoopFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	oldWindow := fs.Config.StatsAvgWindow
+	defer func() { fs.Config.StatsAvgWindow = oldWindow }()
+	// A window makes a moving average which warms up
+	fs.Config.StatsAvgWindow = 20 * time.Second
+
+	// The samples are published after all the Accounts have been
+	// ticked so each one shows the tick has been processed
+	s := NewStats()
+	samples := make(chan StatsSample, 1)
+	s.subscribers.subs = map[int]chan StatsSample{0: samples}
+	ticks := int(ewma.WARMUP_SAMPLES) + 3
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100*ticks)))
+	acc := NewAccountSizeNameContext(WithStats(context.Background(), s), in, int64(100*ticks), "test")
+
+	// Register them by hand so the loop runs on the manual ticker
+	a := newAverager()
+	a.accs[s] = struct{}{}
+	a.accs[acc] = struct{}{}
+	a.running = true
+	tick := newManualTicker()
+	done := make(chan struct{})
+	go func() {
+		a.loop(tick, time.Second)
+		close(done)
+	}()
+
+	// Read 100 bytes a second.  The speed isn't known until the
+	// moving average has warmed up then it is exact.
+	buf := make([]byte, 100)
+	for i := 1; i <= ticks; i++ {
+		_, err := acc.Read(buf)
+		require.NoError(t, err)
+		c.advance(time.Second)
+		tick.tick(clk.Now())
+		sample := <-samples
+		assert.Equal(t, clk.Now(), sample.Time)
+		if i <= int(ewma.WARMUP_SAMPLES) {
+			assert.Equal(t, 0.0, sample.Speed, "tick %d", i)
+		} else {
+			assert.InDelta(t, 100.0, sample.Speed, 1e-9, "tick %d", i)
+		}
+		assert.Equal(t, int64(100*i), sample.Bytes)
+	}
+
+	// The loop stops when there are no Accounts left
+	a.remove(acc)
+	a.remove(s)
+	tick.tick(clk.Now())
+	<-done
+	<-tick.stopped
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountETAConvergenceFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	oldResolution := fs.Config.StatsETAResolution
+	defer func() { fs.Config.StatsETAResolution = oldResolution }()
+	fs.Config.StatsETAResolution = 0
+
+	const size = 100000
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, size)))
+	acc := NewAccountSizeName(in, size, "test")
+	read := func(n int) {
+		_, err := acc.Read(make([]byte, n))
+		require.NoError(t, err)
+		c.advance(time.Second)
+		acc.averageTick(clk.Now())
+	}
+
+	// At a steady speed the ETA is exact
+	done := 0
+	for i := 0; i < 5; i++ {
+		read(1000)
+		done += 1000
+		eta, ok := acc.eta()
+		require.True(t, ok)
+		assert.Equal(t, time.Duration((size-done)/1000)*time.Second, eta)
+	}
+
+	// When it slows down the ETA rises towards the new value
+	// without overshooting it
+	var last time.Duration
+	for i := 0; i < 120; i++ {
+		read(500)
+		done += 500
+		eta, ok := acc.eta()
+		require.True(t, ok)
+		exact := time.Duration((size-done)/500) * time.Second
+		assert.True(t, eta <= exact, "tick %d: %v > %v", i, eta, exact)
+		if i < 30 {
+			assert.True(t, eta >= last, "tick %d: %v < %v", i, eta, last)
+		}
+		last = eta
+	}
+	exact := time.Duration((size-done)/500) * time.Second
+	assert.InDelta(t, float64(exact), float64(last), float64(time.Second))
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountPausedStalledFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 1000)))
+	acc := NewAccountSizeName(in, 1000, "test")
+	assert.False(t, acc.IsStalled(time.Second), "not stalled before the first read")
+	c.advance(time.Hour)
+	acc.averageTick(clk.Now())
+	assert.False(t, acc.IsStalled(time.Second), "not stalled while connecting")
+
+	_, err := acc.Read(make([]byte, 100))
+	require.NoError(t, err)
+	c.advance(time.Second)
+	acc.averageTick(clk.Now())
+
+	// Stalled exactly threshold after the last tick with bytes
+	c.advance(stalledThreshold - time.Second - time.Nanosecond)
+	assert.False(t, acc.IsStalled(stalledThreshold-time.Second))
+	c.advance(time.Nanosecond)
+	assert.True(t, acc.IsStalled(stalledThreshold-time.Second))
+	assert.Equal(t, stalledThreshold, acc.elapsed())
+
+	// Not stalled while paused and the time paused doesn't count
+	acc.Pause()
+	c.advance(10 * time.Minute)
+	acc.averageTick(clk.Now())
+	assert.False(t, acc.IsStalled(time.Nanosecond))
+	assert.Equal(t, stalledThreshold, acc.elapsed())
+	assert.Equal(t, []float64{0, 100}, acc.SpeedHistory(10), "no samples while paused")
+	acc.Resume()
+	assert.True(t, acc.IsStalled(stalledThreshold-time.Second))
+	assert.False(t, acc.IsStalled(stalledThreshold))
+
+	// Reading again ends the stall from the next tick
+	_, err = acc.Read(make([]byte, 100))
+	require.NoError(t, err)
+	c.advance(time.Second)
+	acc.averageTick(clk.Now())
+	assert.False(t, acc.IsStalled(time.Second))
+	assert.Equal(t, stalledThreshold+time.Second, acc.elapsed())
+	assert.Equal(t, []float64{0, 100, 100}, acc.SpeedHistory(10))
+	require.NoError(t, acc.Close())
+}
+
+func TestAccountIdleTimeoutFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc := NewAccountSizeName(r, -1, "test")
+	acc.SetIdleTimeout(time.Minute)
+
+	// Not aborted until it has been idle for the whole timeout
+	c.waitTimers(t, 1)
+	c.advance(time.Minute - time.Second)
+	assert.NoError(t, acc.Err())
+	c.advance(time.Second)
+	select {
+	case <-acc.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer not aborted")
+	}
+	assert.Equal(t, &IdleTimeoutError{Name: "test", Timeout: time.Minute}, acc.Err())
+	assert.NoError(t, acc.Close())
+}
+
+func TestProbeBandwidthFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket, prevTokenBucket, bwLimitToggledOff = nil, nil, false
+		tokenBucketMu.Unlock()
+	}()
+	tokenBucketMu.Lock()
+	tokenBucket, prevTokenBucket, bwLimitToggledOff = newTokenBucket(1024), nil, false
+	tokenBucketMu.Unlock()
+
+	// The bandwidth is measured over exactly the time of the probe
+	result := make(chan float64)
+	go func() {
+		result <- probeBandwidth(10 * time.Second)
+	}()
+	c.waitTimers(t, 1)
+	limitBandwidth(10 * 1024 * 1024)
+	c.advance(10 * time.Second)
+	assert.Equal(t, float64(1024*1024), <-result)
+}
+
+func TestProbeOnceFakeClock(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket, prevTokenBucket, bwLimitToggledOff = nil, nil, false
+		tokenBucketMu.Unlock()
+	}()
+	tokenBucketMu.Lock()
+	tokenBucket, prevTokenBucket, bwLimitToggledOff = nil, nil, false
+	tokenBucketMu.Unlock()
+	require.False(t, transfersInProgress())
+
+	// Nothing is probed until a transfer is in progress
+	result := make(chan time.Duration)
+	go func() {
+		result <- probeOnce(50)
+	}()
+	c.waitTimers(t, 1)
+	c.advance(bwProbeWait)
+	c.waitTimers(t, 1)
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc := NewAccountSizeName(r, -1, "test")
+	c.advance(bwProbeWait)
+
+	// then the limit is set from what was measured
+	c.waitTimers(t, 1)
+	limitBandwidth(10 * 1024 * 1024)
+	c.advance(bwProbeDuration)
+	assert.Equal(t, bwProbeInterval, <-result)
+	bw, limited := bandwidthLimit()
+	assert.True(t, limited)
+	assert.Equal(t, fs.SizeSuffix(512*1024), bw)
+
+	// A probe which measured nothing is tried again soon
+	go func() {
+		result <- probeOnce(50)
+	}()
+	c.waitTimers(t, 1)
+	c.advance(bwProbeDuration)
+	assert.Equal(t, bwProbeRetry, <-result)
+
+	// as is one where the transfers finished during the probe,
+	// leaving the limit as it was
+	go func() {
+		result <- probeOnce(50)
+	}()
+	c.waitTimers(t, 1)
+	limitBandwidth(1024)
+	require.NoError(t, acc.Close())
+	c.advance(bwProbeDuration)
+	assert.Equal(t, bwProbeRetry, <-result)
+	bw, _ = bandwidthLimit()
+	assert.Equal(t, fs.SizeSuffix(512*1024), bw)
+}
@@ -0,0 +1,94 @@
+package accounting
+
+import (
+	"sort"
+	"sync"
+)
+
+// transferEvents holds the functions registered with OnTransferStart
+// and OnTransferEnd
+var transferEvents = struct {
+	mu    sync.Mutex
+	start map[int]func(name string, size int64)
+	end   map[int]func(name string, bytes int64, err error)
+	next  int // id of the next function added
+}{
+	start: make(map[int]func(name string, size int64)),
+	end:   make(map[int]func(name string, bytes int64, err error)),
+}
+
+// OnTransferStart registers fn to be called with the name and size
+// (-1 if unknown) of every transfer when its Account is made, whichever
+// StatsInfo it is accounted in.  Call the returned function to
+// unregister fn.
+//
+// fn is called without any accounting locks held on the goroutine
+// making the Account so it should be quick.
+func OnTransferStart(fn func(name string, size int64)) (remove func()) {
+	transferEvents.mu.Lock()
+	defer transferEvents.mu.Unlock()
+	id := transferEvents.next
+	transferEvents.next++
+	transferEvents.start[id] = fn
+	return func() {
+		transferEvents.mu.Lock()
+		defer transferEvents.mu.Unlock()
+		delete(transferEvents.start, id)
+	}
+}
+
+// OnTransferEnd registers fn to be called with the name, bytes
+// transferred and error (nil if successful) of every transfer when it
+// finishes, whichever StatsInfo it is accounted in.  Call the returned
+// function to unregister fn.
+//
+// fn is called without any accounting locks held on the goroutine
+// which finished the transfer so it should be quick.
+func OnTransferEnd(fn func(name string, bytes int64, err error)) (remove func()) {
+	transferEvents.mu.Lock()
+	defer transferEvents.mu.Unlock()
+	id := transferEvents.next
+	transferEvents.next++
+	transferEvents.end[id] = fn
+	return func() {
+		transferEvents.mu.Lock()
+		defer transferEvents.mu.Unlock()
+		delete(transferEvents.end, id)
+	}
+}
+
+// transferStarted calls the functions registered with OnTransferStart
+func transferStarted(name string, size int64) {
+	transferEvents.mu.Lock()
+	ids := make([]int, 0, len(transferEvents.start))
+	for id := range transferEvents.start {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fns := make([]func(string, int64), 0, len(ids))
+	for _, id := range ids {
+		fns = append(fns, transferEvents.start[id])
+	}
+	transferEvents.mu.Unlock()
+	for _, fn := range fns {
+		fn(name, size)
+	}
+}
+
+// transferEnded calls the functions registered with OnTransferEnd
+func transferEnded(name string, bytes int64, err error) {
+	transferEvents.mu.Lock()
+	ids := make([]int, 0, len(transferEvents.end))
+	for id := range transferEvents.end {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fns := make([]func(string, int64, error), 0, len(ids))
+	for _, id := range ids {
+		fns = append(fns, transferEvents.end[id])
+	}
+	transferEvents.mu.Unlock()
+	for _, fn := range fns {
+		fn(name, bytes, err)
+	}
+}
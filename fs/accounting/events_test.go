@@ -0,0 +1,54 @@
+package accounting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferEvents(t *testing.T) {
+	var events []string
+	remove1 := OnTransferStart(func(name string, size int64) {
+		events = append(events, fmt.Sprintf("start1 %s %d", name, size))
+	})
+	remove2 := OnTransferStart(func(name string, size int64) {
+		events = append(events, fmt.Sprintf("start2 %s %d", name, size))
+	})
+	remove3 := OnTransferEnd(func(name string, bytes int64, err error) {
+		// This would deadlock if the inProgress lock was held
+		_ = Stats.inProgress.count()
+		events = append(events, fmt.Sprintf("end %s %d %v", name, bytes, err))
+	})
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "test-events")
+	_, err := acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+	require.NoError(t, acc.Close())
+
+	acc = NewAccountSizeName(in, -1, "test-events-failed")
+	acc.Finish(errors.New("boom"))
+	require.NoError(t, acc.Close())
+
+	// Checks aren't transfers
+	require.NoError(t, NewAccountCheck(in, 3, "test-events-check").Close())
+
+	remove1()
+	remove2()
+	remove3()
+	require.NoError(t, NewAccountSizeName(in, 3, "test-events-removed").Close())
+
+	assert.Equal(t, []string{
+		"start1 test-events 3",
+		"start2 test-events 3",
+		"end test-events 2 <nil>",
+		"start1 test-events-failed -1",
+		"start2 test-events-failed -1",
+		"end test-events-failed 0 boom",
+	}, events)
+}
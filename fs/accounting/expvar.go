@@ -0,0 +1,52 @@
+package accounting
+
+import (
+	"expvar"
+	"sync"
+)
+
+// publishExpvarOnce makes sure the expvar variables are only
+// published once as expvar.Publish panics on duplicates
+var publishExpvarOnce sync.Once
+
+// PublishExpvar publishes the accounting stats of the global Stats and
+// any live jobs (see AggregateStats) as expvar variables, so they can
+// be seen at /debug/vars.  These are
+//
+//   - rclone.bytes - bytes transferred
+//   - rclone.errors - number of errors
+//   - rclone.transfers - number of transfers completed
+//   - rclone.transfersInProgress - number of transfers in progress
+//   - rclone.speed - current speed of all the transfers in bytes/s
+//   - rclone.transferring - the transfers in progress as returned by core/transferring
+//
+// The values are read when the variables are, so there is no cost
+// until then.  Nothing is published unless this is called so library
+// users aren't forced into the global expvar namespace.  It is safe to
+// call more than once.
+func PublishExpvar() {
+	publishExpvarOnce.Do(func() {
+		expvar.Publish("rclone.bytes", expvar.Func(func() interface{} {
+			return AggregateStats().GetBytes()
+		}))
+		expvar.Publish("rclone.errors", expvar.Func(func() interface{} {
+			return AggregateStats().GetErrors()
+		}))
+		expvar.Publish("rclone.transfers", expvar.Func(func() interface{} {
+			return AggregateStats().GetTransfers()
+		}))
+		expvar.Publish("rclone.transfersInProgress", expvar.Func(func() interface{} {
+			return AggregateStats().inProgress.count()
+		}))
+		expvar.Publish("rclone.speed", expvar.Func(func() interface{} {
+			return AggregateStats().inProgress.speed()
+		}))
+		expvar.Publish("rclone.transferring", expvar.Func(func() interface{} {
+			snapshots, _ := Transferring(TransferFilter{})
+			if snapshots == nil {
+				snapshots = []AccountSnapshot{}
+			}
+			return snapshots
+		}))
+	})
+}
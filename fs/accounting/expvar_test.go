@@ -0,0 +1,68 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	PublishExpvar()
+	PublishExpvar() // check it can be called twice
+
+	job := NewJobStats()
+	defer job.Remove()
+	ctx := WithStats(context.Background(), job)
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+	acc := NewAccountSizeNameContext(ctx, in, 100, "expvar-test")
+	_, err := acc.Read(make([]byte, 10))
+	require.NoError(t, err)
+
+	before := AggregateStats().GetBytes()
+	job.Bytes(1000)
+	assert.Equal(t, float64(before+1000), getExpvar(t, "rclone.bytes").(float64))
+	assert.True(t, getExpvar(t, "rclone.transfersInProgress").(float64) >= 1)
+	_ = getExpvar(t, "rclone.errors")
+	_ = getExpvar(t, "rclone.transfers")
+	_ = getExpvar(t, "rclone.speed")
+
+	var found bool
+	for _, transfer := range getExpvar(t, "rclone.transferring").([]interface{}) {
+		transfer := transfer.(map[string]interface{})
+		if transfer["name"] == "expvar-test" {
+			found = true
+			assert.Equal(t, 10.0, transfer["bytes"])
+		}
+	}
+	assert.True(t, found)
+
+	require.NoError(t, acc.Close())
+	assert.Equal(t, []interface{}{}, getExpvarTransferring(t))
+}
+
+// getExpvar returns the value of the expvar name decoded from JSON
+func getExpvar(t *testing.T, name string) interface{} {
+	v := expvar.Get(name)
+	require.NotNil(t, v, name)
+	var out interface{}
+	require.NoError(t, json.Unmarshal([]byte(v.String()), &out), name)
+	return out
+}
+
+// getExpvarTransferring returns rclone.transferring without any
+// transfers left over from other tests
+func getExpvarTransferring(t *testing.T) []interface{} {
+	out := []interface{}{}
+	for _, transfer := range getExpvar(t, "rclone.transferring").([]interface{}) {
+		if transfer.(map[string]interface{})["name"] == "expvar-test" {
+			out = append(out, transfer)
+		}
+	}
+	return out
+}
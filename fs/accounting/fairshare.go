@@ -0,0 +1,135 @@
+package accounting
+
+import (
+	"sort"
+
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// Fair sharing of the global bandwidth limit
+//
+// The global token bucket serves reads first come first served so one
+// fast transfer can starve the others.  To stop that each Account
+// which is transferring gets its own share of the limit which is
+// rebalanced every tickInterval() by rebalanceShares.  The global
+// token bucket is still used so the total never exceeds the limit.
+//
+// Each share is in proportion to the weight of the Account set with
+// SetWeight.  Accounts which aren't transferring, or are paused, don't
+// get a share, nor do checks unless --bwlimit-checks is set.
+
+// accountDemand is an Account, the speed it transferred at in the
+// last tick and its weight
+type accountDemand struct {
+	acc    *Account
+	demand float64
+	weight float64
+}
+
+// byDemand sorts accountDemand by increasing demand per weight
+type byDemand []accountDemand
+
+func (x byDemand) Len() int      { return len(x) }
+func (x byDemand) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x byDemand) Less(i, j int) bool {
+	return x[i].demand/x[i].weight < x[j].demand/x[j].weight
+}
+
+// rebalanceShares divides the global bandwidth limit fairly between
+// the Accounts in accs which transferred data through it in the last
+// tick and aren't paused.
+//
+// Accounts which used less than their share keep it so they can
+// speed up, and what they didn't use is divided between the rest.
+// If there is only one Account transferring it doesn't get a share
+// so it is only limited by the global limit as before.
+func rebalanceShares(accs []averageTicker) {
+	limit, limited := bandwidthLimit()
+	var active []accountDemand
+	for _, ticker := range accs {
+		acc, ok := ticker.(*Account)
+		if !ok {
+			continue
+		}
+		acc.statmu.Lock()
+		demand, weight := acc.lpSpeed, acc.weight
+		if acc.resume != nil || !acc.globallyLimited() {
+			// Paused, or a check which isn't limited, so not
+			// using any of the limit whatever the speed in the
+			// last tick was
+			demand = 0
+		}
+		acc.statmu.Unlock()
+		if weight < 1 {
+			weight = 1
+		}
+		if limited && demand > 0 {
+			active = append(active, accountDemand{acc: acc, demand: demand, weight: float64(weight)})
+		} else {
+			acc.setShare(0)
+		}
+	}
+	if len(active) <= 1 {
+		for _, a := range active {
+			a.acc.setShare(0)
+		}
+		return
+	}
+	sort.Sort(byDemand(active))
+	remaining := float64(limit)
+	var weights float64
+	for _, a := range active {
+		weights += a.weight
+	}
+	for _, a := range active {
+		share := remaining * a.weight / weights
+		a.acc.setShare(share)
+		if a.demand < share {
+			remaining -= a.demand
+		} else {
+			remaining -= share
+		}
+		weights -= a.weight
+	}
+}
+
+// SetWeight sets the weight of this transfer's share of the global
+// bandwidth limit relative to the other transfers, eg a transfer with
+// weight 2 gets twice the bandwidth of one with weight 1.  Weights
+// less than 1 are treated as 1, the default.
+func (acc *Account) SetWeight(weight int) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	acc.weight = weight
+}
+
+// setShare sets the share of the global bandwidth limit for this
+// transfer in bytes per second.  0 means it doesn't have a share.
+func (acc *Account) setShare(bytesPerSecond float64) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	switch {
+	case bytesPerSecond <= 0:
+		acc.share = nil
+	case acc.share == nil:
+		acc.share = newTokenBucket(fs.SizeSuffix(bytesPerSecond))
+	default:
+		acc.share.SetLimit(rate.Limit(bytesPerSecond))
+	}
+}
+
+// shareBandwidth sleeps for the correct amount of time for the
+// passage of n bytes according to the share of the global bandwidth
+// limit of this transfer
+func (acc *Account) shareBandwidth(n int) {
+	acc.statmu.Lock()
+	tb := acc.share
+	acc.statmu.Unlock()
+	if tb != nil {
+		err := waitTokens(tb, n)
+		if err != nil {
+			fs.Errorf(acc.Name(), "Token bucket error: %v", err)
+		}
+	}
+}
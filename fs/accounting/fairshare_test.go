@@ -0,0 +1,163 @@
+package accounting
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRebalanceShares(t *testing.T) {
+	var accs []*Account
+	var tickers []averageTicker
+	for i, speed := range []float64{2000, 100, 2000, 0} {
+		in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+		acc := NewAccountSizeName(in, 1, fmt.Sprintf("share-%d", i))
+		acc.lpSpeed = speed
+		accs = append(accs, acc)
+		tickers = append(tickers, acc)
+	}
+	defer func() {
+		for _, acc := range accs {
+			assert.NoError(t, acc.Close())
+		}
+	}()
+	share := func(acc *Account) rate.Limit {
+		acc.statmu.Lock()
+		defer acc.statmu.Unlock()
+		if acc.share == nil {
+			return 0
+		}
+		return acc.share.Limit()
+	}
+
+	// No shares without a limit
+	rebalanceShares(tickers)
+	for _, acc := range accs {
+		assert.Equal(t, rate.Limit(0), share(acc))
+	}
+
+	tokenBucketMu.Lock()
+	tokenBucket = rate.NewLimiter(1000, minBurstSize)
+	tokenBucketMu.Unlock()
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket = nil
+		tokenBucketMu.Unlock()
+	}()
+
+	// The slow transfer keeps an equal share and what it doesn't
+	// use is shared between the fast ones
+	rebalanceShares(tickers)
+	assert.InDelta(t, 450, float64(share(accs[0])), 1)
+	assert.InDelta(t, 1000.0/3, float64(share(accs[1])), 1)
+	assert.InDelta(t, 450, float64(share(accs[2])), 1)
+	assert.Equal(t, rate.Limit(0), share(accs[3]))
+
+	// Shares are in proportion to the weights
+	accs[2].SetWeight(3)
+	rebalanceShares(tickers)
+	assert.InDelta(t, 1000.0/5, float64(share(accs[1])), 1)
+	assert.InDelta(t, (1000-100)/4.0, float64(share(accs[0])), 1)
+	assert.InDelta(t, 3*(1000-100)/4.0, float64(share(accs[2])), 1)
+	assert.Equal(t, rate.Limit(0), share(accs[3]))
+
+	// A slow transfer with a big weight gives what it doesn't use
+	// to the others
+	accs[2].SetWeight(1)
+	accs[1].SetWeight(8)
+	rebalanceShares(tickers)
+	assert.InDelta(t, 800, float64(share(accs[1])), 1)
+	assert.InDelta(t, 450, float64(share(accs[0])), 1)
+	assert.InDelta(t, 450, float64(share(accs[2])), 1)
+	accs[1].SetWeight(0)
+
+	// A single transfer is only limited by the global limit
+	rebalanceShares(tickers[:1])
+	assert.Equal(t, rate.Limit(0), share(accs[0]))
+}
+
+func TestRebalanceSharesPaused(t *testing.T) {
+	var accs []*Account
+	var tickers []averageTicker
+	for i := 0; i < 2; i++ {
+		in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+		acc := NewAccountSizeName(in, 1, fmt.Sprintf("paused-%d", i))
+		acc.lpSpeed = 2000
+		accs = append(accs, acc)
+		tickers = append(tickers, acc)
+	}
+	defer func() {
+		for _, acc := range accs {
+			assert.NoError(t, acc.Close())
+		}
+	}()
+
+	tokenBucketMu.Lock()
+	tokenBucket = rate.NewLimiter(1000, minBurstSize)
+	tokenBucketMu.Unlock()
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket = nil
+		tokenBucketMu.Unlock()
+	}()
+
+	rebalanceShares(tickers)
+	assert.NotNil(t, accs[0].share)
+	assert.NotNil(t, accs[1].share)
+
+	// A paused transfer gives up its share so the other one is
+	// only limited by the global limit
+	accs[1].Pause()
+	rebalanceShares(tickers)
+	assert.Nil(t, accs[0].share)
+	assert.Nil(t, accs[1].share)
+
+	// and gets it back when it is resumed
+	accs[1].Resume()
+	rebalanceShares(tickers)
+	assert.NotNil(t, accs[0].share)
+	assert.NotNil(t, accs[1].share)
+}
+
+func TestRebalanceSharesCheck(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+	acc := NewAccountSizeName(in, 1, "upload")
+	acc.lpSpeed = 2000
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+	check := NewAccountCheck(in, 1, "hash")
+	check.lpSpeed = 2000
+	defer func() {
+		assert.NoError(t, acc.Close())
+		assert.NoError(t, check.Close())
+	}()
+	tickers := []averageTicker{acc, check}
+
+	tokenBucketMu.Lock()
+	tokenBucket = rate.NewLimiter(1000, minBurstSize)
+	tokenBucketMu.Unlock()
+	oldBwLimitChecks := fs.Config.BwLimitChecks
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket = nil
+		tokenBucketMu.Unlock()
+		fs.Config.BwLimitChecks = oldBwLimitChecks
+	}()
+
+	// A check doesn't use the limit so doesn't take a share from the
+	// upload
+	fs.Config.BwLimitChecks = false
+	rebalanceShares(tickers)
+	assert.Nil(t, acc.share)
+	assert.Nil(t, check.share)
+
+	// unless it is limited with --bwlimit-checks
+	fs.Config.BwLimitChecks = true
+	rebalanceShares(tickers)
+	assert.NotNil(t, acc.share)
+	assert.NotNil(t, check.share)
+}
@@ -0,0 +1,121 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VividCortex/ewma"
+)
+
+// AccountGroup combines the stats of several Accounts into one, for
+// example all the chunks of a file being uploaded in parallel.
+//
+// The group keeps its own moving average of the combined speed so
+// children can be added or finish at any time without disturbing it.
+type AccountGroup struct {
+	mu       sync.Mutex
+	name     string
+	children map[*Account]struct{} // children still in progress
+	bytes    int64                 // bytes done by all children including finished ones
+	size     int64                 // sum of the sizes of all the children
+	start    time.Time             // time of the first read of any child
+	lpTime   time.Time             // time of last average measurement
+	lpBytes  int                   // bytes read since last measurement
+	avg      ewma.MovingAverage    // moving average of the combined speed
+}
+
+// NewAccountGroup makes a new empty AccountGroup with the name given
+func NewAccountGroup(name string) *AccountGroup {
+	return &AccountGroup{
+		name:     name,
+		children: make(map[*Account]struct{}),
+		avg:      newMovingAverage(),
+		lpTime:   clk.Now(),
+	}
+}
+
+// Add acc to the group.  Any bytes it has already transferred and its
+// size are added to the group's totals.
+//
+// The child is removed from the group automatically when it finishes
+// but its bytes stay counted.
+func (g *AccountGroup) Add(acc *Account) {
+	acc.statmu.Lock()
+	acc.group = g
+	bytes, size, start := acc.bytes, acc.size, acc.start
+	acc.statmu.Unlock()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bytes += bytes
+	if size > 0 {
+		g.size += size
+	}
+	if !start.IsZero() && (g.start.IsZero() || start.Before(g.start)) {
+		g.start = start
+	}
+	if len(g.children) == 0 {
+		// Start updating the averages again
+		g.lpTime = clk.Now()
+		averages.add(g)
+	}
+	g.children[acc] = struct{}{}
+}
+
+// remove acc from the children when it has finished
+func (g *AccountGroup) remove(acc *Account) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.children[acc]; !ok {
+		return
+	}
+	delete(g.children, acc)
+	if len(g.children) == 0 {
+		averages.remove(g)
+	}
+}
+
+// accountBytes adds n bytes read by a child to the group
+func (g *AccountGroup) accountBytes(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.start.IsZero() {
+		g.start = clk.Now()
+	}
+	g.bytes += int64(n)
+	g.lpBytes += n
+}
+
+// averageTick adds the combined speed since the last tick to the
+// moving average
+func (g *AccountGroup) averageTick(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	elapsed := now.Sub(g.lpTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	g.avg.Add(float64(g.lpBytes) / elapsed)
+	g.lpBytes = 0
+	g.lpTime = now
+}
+
+// Snapshot returns a consistent copy of the combined stats for the
+// group
+func (g *AccountGroup) Snapshot() AccountSnapshot {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := AccountSnapshot{
+		Name:         g.name,
+		Start:        g.start,
+		Bytes:        g.bytes,
+		Size:         g.size,
+		CurrentSpeed: g.avg.Value(),
+	}
+	if g.bytes > 0 && !g.start.IsZero() {
+		s.BytesPerSecond = float64(g.bytes) / clk.Now().Sub(g.start).Seconds()
+	}
+	s.Percentage, s.PercentageValid = percentage(g.bytes, g.size)
+	s.ETA, s.ETAValid = calculateETA(g.size, g.bytes, s.CurrentSpeed)
+	return s
+}
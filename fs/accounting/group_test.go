@@ -0,0 +1,60 @@
+package accounting
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountGroup(t *testing.T) {
+	g := NewAccountGroup("file")
+	s := g.Snapshot()
+	assert.Equal(t, "file", s.Name)
+	assert.Equal(t, int64(0), s.Bytes)
+	assert.False(t, s.ETAValid)
+
+	in1 := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc1 := NewAccountSizeName(in1, 3, "file-part1")
+	_, err := acc1.Read(make([]byte, 1))
+	require.NoError(t, err)
+	// bytes read before joining should be counted
+	g.Add(acc1)
+
+	in2 := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3, 4, 5}))
+	acc2 := NewAccountSizeName(in2, 5, "file-part2")
+	g.Add(acc2)
+	_, err = acc2.Read(make([]byte, 2))
+	require.NoError(t, err)
+
+	s = g.Snapshot()
+	assert.Equal(t, int64(3), s.Bytes)
+	assert.Equal(t, int64(8), s.Size)
+	assert.False(t, s.Start.IsZero())
+
+	// Average should carry on across children finishing
+	g.lpBytes = 1000
+	g.averageTick(g.lpTime.Add(time.Second))
+	assert.Equal(t, 1000.0, g.avg.Value())
+	assert.NoError(t, acc1.Close())
+	g.mu.Lock()
+	assert.Equal(t, 1, len(g.children))
+	g.mu.Unlock()
+	s = g.Snapshot()
+	assert.Equal(t, int64(3), s.Bytes)
+	assert.Equal(t, 1000.0, s.CurrentSpeed)
+	assert.True(t, s.ETAValid)
+	assert.Equal(t, time.Duration(0), s.ETA)
+
+	assert.NoError(t, acc2.Close())
+	g.mu.Lock()
+	assert.Equal(t, 0, len(g.children))
+	g.mu.Unlock()
+	averages.mu.Lock()
+	_, registered := averages.accs[g]
+	averages.mu.Unlock()
+	assert.False(t, registered)
+}
@@ -0,0 +1,119 @@
+package accounting
+
+import "time"
+
+// histogram is a fixed size histogram of observations so it uses
+// bounded memory however many observations are added.
+//
+// It isn't safe for concurrent use so should be protected by the
+// lock of the thing which contains it.
+type histogram struct {
+	bounds []float64 // upper bounds of the buckets in increasing order
+	counts []int64   // observations in each bucket - the last is for > the last bound
+	count  int64     // total number of observations
+	sum    float64   // sum of all the observations
+	min    float64   // smallest observation - valid if count > 0
+	max    float64   // largest observation - valid if count > 0
+}
+
+// newHistogram makes a new histogram with the bucket upper bounds
+// given which should be in increasing order
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// add an observation to the histogram
+func (h *histogram) add(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// cumulative returns the number of observations <= each bound
+func (h *histogram) cumulative() []int64 {
+	out := make([]int64, len(h.bounds))
+	var total int64
+	for i := range h.bounds {
+		total += h.counts[i]
+		out[i] = total
+	}
+	return out
+}
+
+// quantile estimates the value below which a fraction q of the
+// observations fall by interpolating within the bucket it is in.  It
+// returns 0 if there are no observations and the last bound if it is
+// above them.
+func (h *histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var total int64
+	for i, bound := range h.bounds {
+		if h.counts[i] > 0 && float64(total+h.counts[i]) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = h.bounds[i-1]
+			}
+			return lower + (bound-lower)*(rank-float64(total))/float64(h.counts[i])
+		}
+		total += h.counts[i]
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// secondsToDuration converts seconds to a time.Duration rounded to
+// the millisecond
+func secondsToDuration(seconds float64) time.Duration {
+	d := time.Duration(seconds * float64(time.Second))
+	return d - d%time.Millisecond
+}
+
+// durationBounds are the bucket bounds in seconds used for timing
+// whole transfers
+var durationBounds = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+// speedBounds are the bucket bounds in bytes/s used for the average
+// speed of whole transfers
+var speedBounds = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+
+// firstByteBounds are the bucket bounds in seconds used for timing
+// the first byte of transfers
+var firstByteBounds = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// latencyBounds are the bucket bounds in seconds used for timing the
+// reads of transfers with --stats-read-latency
+var latencyBounds = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// merge adds the observations in o, which must have the same bounds,
+// to h
+func (h *histogram) merge(o *histogram) {
+	if o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if h.count == 0 || o.max > h.max {
+		h.max = o.max
+	}
+	for i := range h.counts {
+		h.counts[i] += o.counts[i]
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
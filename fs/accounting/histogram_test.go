@@ -0,0 +1,65 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4})
+	assert.Equal(t, 0.0, h.quantile(0.5))
+
+	h.add(0.5)
+	h.add(1.5)
+	h.add(1.5)
+	h.add(3)
+	for _, test := range []struct {
+		q    float64
+		want float64
+	}{
+		{0, 0},
+		{0.25, 1},
+		{0.5, 1.5},
+		{0.75, 2},
+		{1, 4},
+	} {
+		assert.InDelta(t, test.want, h.quantile(test.q), 1e-9, "q=%v", test.q)
+	}
+
+	// Above the last bound
+	h.add(100)
+	h.add(100)
+	h.add(100)
+	h.add(100)
+	assert.Equal(t, 4.0, h.quantile(0.9))
+}
+
+func TestSecondsToDuration(t *testing.T) {
+	assert.Equal(t, 1500*time.Millisecond, secondsToDuration(1.5004))
+	assert.Equal(t, time.Duration(0), secondsToDuration(0))
+}
+
+func TestHistogramMinMax(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4})
+	h.add(3)
+	h.add(0.5)
+	h.add(100)
+	assert.Equal(t, 0.5, h.min)
+	assert.Equal(t, 100.0, h.max)
+
+	// Merging an empty histogram leaves them alone
+	out := newHistogram([]float64{1, 2, 4})
+	out.merge(newHistogram([]float64{1, 2, 4}))
+	out.merge(h)
+	assert.Equal(t, 0.5, out.min)
+	assert.Equal(t, 100.0, out.max)
+
+	o := newHistogram([]float64{1, 2, 4})
+	o.add(0.25)
+	out.merge(o)
+	assert.Equal(t, 0.25, out.min)
+	assert.Equal(t, 100.0, out.max)
+	assert.Equal(t, int64(4), out.count)
+}
@@ -1,12 +1,22 @@
 package accounting
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/ncw/rclone/fs"
+	"github.com/pkg/errors"
 )
 
-// inProgress holds a synchronized map of in progress transfers
+// ErrorTransferAmbiguous is the cause of the error returned when
+// looking up a transfer by a name which more than one transfer in
+// progress has.  Use the ID of the transfer instead.
+var ErrorTransferAmbiguous = errors.New("more than one transfer has that name")
+
+// inProgress holds a synchronized map of in progress transfers keyed
+// by the ID of their Account so transfers with the same name don't
+// clash
 type inProgress struct {
 	mu sync.Mutex
 	m  map[string]*Account
@@ -19,23 +29,125 @@ func newInProgress() *inProgress {
 	}
 }
 
-// set marks the name as in progress
-func (ip *inProgress) set(name string, acc *Account) {
+// set marks acc as in progress
+func (ip *inProgress) set(acc *Account) {
 	ip.mu.Lock()
 	defer ip.mu.Unlock()
-	ip.m[name] = acc
+	ip.m[acc.id] = acc
 }
 
-// clear marks the name as no longer in progress
-func (ip *inProgress) clear(name string) {
+// remove marks acc as no longer in progress
+func (ip *inProgress) remove(acc *Account) {
 	ip.mu.Lock()
 	defer ip.mu.Unlock()
-	delete(ip.m, name)
+	delete(ip.m, acc.id)
 }
 
-// get gets the account for name, of nil if not found
-func (ip *inProgress) get(name string) *Account {
+// rename changes the name of acc to name.  The lock is held so the
+// transfers in progress are never seen with a half changed name.
+func (ip *inProgress) rename(acc *Account, name string) {
 	ip.mu.Lock()
 	defer ip.mu.Unlock()
-	return ip.m[name]
+	acc.statmu.Lock()
+	acc.name = name
+	acc.statmu.Unlock()
+}
+
+// get gets the Account in progress for nameOrID as found by lookup,
+// or nil if there is not exactly one
+func (ip *inProgress) get(nameOrID string) *Account {
+	acc, _ := ip.lookup(nameOrID)
+	return acc
+}
+
+// lookup finds the Account in progress for nameOrID.  This is the
+// Account with that ID if there is one, otherwise the Account with
+// that name or with that name prefixed with its remote name and ":",
+// eg "remote:path/to/file".
+//
+// It returns an error if there is no such Account, or one with
+// ErrorTransferAmbiguous as the cause listing the IDs if more than
+// one has the name.
+func (ip *inProgress) lookup(nameOrID string) (*Account, error) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	if acc := ip.m[nameOrID]; acc != nil {
+		return acc, nil
+	}
+	var found []*Account
+	for _, acc := range ip.m {
+		acc.statmu.Lock()
+		name, remote := acc.name, acc.fsName
+		acc.statmu.Unlock()
+		if name == nameOrID || (remote != "" && remote+":"+name == nameOrID) {
+			found = append(found, acc)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.Errorf("transfer %q not found", nameOrID)
+	case 1:
+		return found[0], nil
+	}
+	sort.Sort(accountsByName(found))
+	ids := make([]string, 0, len(found))
+	for _, acc := range found {
+		ids = append(ids, acc.id)
+	}
+	return nil, errors.Wrapf(ErrorTransferAmbiguous, "transfer %q is one of %s", nameOrID, strings.Join(ids, ", "))
+}
+
+// accounts returns the Accounts of the transfers in progress
+func (ip *inProgress) accounts() []*Account {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	accs := make([]*Account, 0, len(ip.m))
+	for _, acc := range ip.m {
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
+// count returns the number of transfers in progress
+func (ip *inProgress) count() int {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	return len(ip.m)
+}
+
+// speed returns the sum of the current speeds of the transfers in
+// progress in bytes per second
+func (ip *inProgress) speed() (speed float64) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	for _, acc := range ip.m {
+		_, cur := acc.speed()
+		speed += cur
+	}
+	return speed
+}
+
+// snapshots returns a snapshot of each of the transfers in progress
+// sorted by name
+func (ip *inProgress) snapshots() []AccountSnapshot {
+	ip.mu.Lock()
+	snapshots := make([]AccountSnapshot, 0, len(ip.m))
+	for _, acc := range ip.m {
+		snapshots = append(snapshots, acc.Snapshot())
+	}
+	ip.mu.Unlock()
+	sort.Sort(snapshotsByName(snapshots))
+	return snapshots
+}
+
+// snapshotsByName sorts AccountSnapshot by name then ID
+type snapshotsByName []AccountSnapshot
+
+func (x snapshotsByName) Len() int      { return len(x) }
+func (x snapshotsByName) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x snapshotsByName) Less(i, j int) bool {
+	if x[i].Name != x[j].Name {
+		return x[i].Name < x[j].Name
+	}
+	return lessID(x[i].ID, x[j].ID)
 }
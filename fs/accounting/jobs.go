@@ -0,0 +1,140 @@
+package accounting
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// statsKey is the context key for the StatsInfo set with WithStats
+type statsKey struct{}
+
+// WithStats returns a copy of ctx carrying s.  Accounts made with a
+// context accounted in s rather than in the global Stats.
+func WithStats(ctx context.Context, s *StatsInfo) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// StatsFromContext returns the StatsInfo set on ctx with WithStats or
+// the global Stats if there isn't one
+func StatsFromContext(ctx context.Context) *StatsInfo {
+	if s, ok := ctx.Value(statsKey{}).(*StatsInfo); ok && s != nil {
+		return s
+	}
+	return Stats
+}
+
+// jobs holds the StatsInfo of all the live jobs
+var jobs = struct {
+	mu    sync.Mutex
+	stats []*StatsInfo
+}{
+	stats: []*StatsInfo{Stats},
+}
+
+// NewJobStats makes a StatsInfo for a job and adds it to the live jobs
+// whose stats are combined by AggregateStats.  Call Remove when the
+// job has finished.
+//
+// Pass it to the Accounts of the job with WithStats.
+func NewJobStats() *StatsInfo {
+	s := NewStats()
+	jobs.mu.Lock()
+	jobs.stats = append(jobs.stats, s)
+	jobs.mu.Unlock()
+	return s
+}
+
+// Remove removes s from the live jobs so it is no longer included in
+// AggregateStats
+func (s *StatsInfo) Remove() {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	for i, job := range jobs.stats {
+		if job == s {
+			jobs.stats = append(jobs.stats[:i], jobs.stats[i+1:]...)
+			return
+		}
+	}
+}
+
+// AggregateStats returns a StatsInfo which is the sum of the global
+// Stats and the stats of all the live jobs made with NewJobStats.
+//
+// The result is a copy which isn't updated.  Use it to show the stats
+// for the whole process, eg AggregateStats().Log()
+func AggregateStats() *StatsInfo {
+	jobs.mu.Lock()
+	live := append([]*StatsInfo(nil), jobs.stats...)
+	jobs.mu.Unlock()
+	if len(live) == 1 {
+		return live[0]
+	}
+	out := NewStats()
+	knownTotals := true
+	for _, s := range live {
+		s.lock.RLock()
+		out.bytes += s.GetBytes()
+		out.errors += s.errors
+		out.retryErrors += s.retryErrors
+		out.fatalErrors += s.fatalErrors
+		out.lowRetries += s.lowRetries
+		out.highRetries += s.highRetries
+		if s.lastError != nil {
+			out.lastError = s.lastError
+		}
+		out.checks += s.checks
+		out.transfers += s.transfers
+		out.deletes += s.deletes
+		out.retried += s.retried
+		out.uploaded += atomic.LoadInt64(&s.uploaded)
+		out.downloaded += atomic.LoadInt64(&s.downloaded)
+		out.checked += s.GetCheckedBytes()
+		out.serverSide += s.serverSide
+		out.skipped += s.skipped
+		out.skippedFiles += s.skippedFiles
+		out.filtered += s.filtered
+		out.filteredFiles += s.filteredFiles
+		for name, n := range s.checking {
+			out.checking[name] += n
+		}
+		for name, n := range s.transferring {
+			out.transferring[name] += n
+		}
+		if s.start.Before(out.start) {
+			out.start = s.start
+		}
+		if s.totalKnown {
+			out.totalBytes += s.totalBytes
+			out.totalFiles += s.totalFiles
+		} else if s.transfers > 0 || len(s.transferring) > 0 {
+			knownTotals = false
+		}
+		out.durations.merge(s.durations)
+		out.speeds.merge(s.speeds)
+		out.failed += s.failed
+		// The peaks of the jobs may have been at different
+		// times so show the fastest rather than adding them up
+		if s.peakShort > out.peakShort {
+			out.peakShort = s.peakShort
+		}
+		if s.peakLong > out.peakLong {
+			out.peakLong = s.peakLong
+		}
+		out.firstBytes.merge(s.firstBytes)
+		out.small.merge(&s.small)
+		s.inProgress.mu.Lock()
+		for id, acc := range s.inProgress.m {
+			out.inProgress.m[id] = acc
+		}
+		s.inProgress.mu.Unlock()
+		s.checkProg.mu.Lock()
+		for id, acc := range s.checkProg.m {
+			out.checkProg.m[id] = acc
+		}
+		s.checkProg.mu.Unlock()
+		s.lock.RUnlock()
+	}
+	out.totalKnown = knownTotals && (out.totalBytes > 0 || out.totalFiles > 0)
+	return out
+}
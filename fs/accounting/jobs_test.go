@@ -0,0 +1,55 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsFromContext(t *testing.T) {
+	assert.Equal(t, Stats, StatsFromContext(context.Background()))
+	s := NewStats()
+	assert.Equal(t, s, StatsFromContext(WithStats(context.Background(), s)))
+}
+
+func TestJobStats(t *testing.T) {
+	job1, job2 := NewJobStats(), NewJobStats()
+	defer job1.Remove()
+
+	// Accounts are only accounted in their own job
+	ctx := WithStats(context.Background(), job1)
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeNameContext(ctx, in, 3, "job1-file")
+	job1.Transferring("job1-file")
+	assert.Equal(t, acc, job1.inProgress.get("job1-file"))
+	assert.Nil(t, Stats.inProgress.get("job1-file"))
+	_, err := acc.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), job1.bytes)
+	assert.Equal(t, int64(0), job2.bytes)
+	job2.Errors(2)
+
+	// The aggregate includes all the live jobs
+	agg := AggregateStats()
+	assert.True(t, agg.bytes >= 3)
+	assert.True(t, agg.errors >= 2)
+	assert.Equal(t, acc, agg.inProgress.get("job1-file"))
+	assert.Contains(t, agg.String(), "job1-file")
+
+	// But not finished ones
+	job2.Remove()
+	job2.Remove()
+	jobs.mu.Lock()
+	for _, s := range jobs.stats {
+		assert.NotEqual(t, job2, s)
+	}
+	jobs.mu.Unlock()
+
+	job1.DoneTransferring("job1-file", true)
+	require.NoError(t, acc.Close())
+	assert.Nil(t, job1.inProgress.get("job1-file"))
+}
@@ -0,0 +1,192 @@
+package accounting
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// transferJSON is the JSON representation of an AccountSnapshot
+//
+// Values which aren't known are represented as null.  Don't change
+// the keys as scripts rely on them.
+type transferJSON struct {
+	Name       string     `json:"name"`
+	Bytes      int64      `json:"bytes"`
+	Size       *int64     `json:"size"`       // null if unknown
+	Percentage *int       `json:"percentage"` // null if size unknown
+	Speed      float64    `json:"speed"`      // average since start in bytes/s
+	SpeedAvg   float64    `json:"speedAvg"`   // moving average in bytes/s
+	ETA        *int64     `json:"eta"`        // seconds - null if unknown
+	Start      *time.Time `json:"start"`      // null if not started
+	ID         string     `json:"id"`         // unique ID of the transfer - "" if not opened yet
+}
+
+// newTransferJSON converts s into its JSON representation
+func newTransferJSON(s AccountSnapshot) transferJSON {
+	t := transferJSON{
+		Name:     s.Name,
+		ID:       s.ID,
+		Bytes:    s.Bytes,
+		Speed:    s.BytesPerSecond,
+		SpeedAvg: s.CurrentSpeed,
+	}
+	if s.Size >= 0 {
+		size := s.Size
+		t.Size = &size
+	}
+	if s.PercentageValid {
+		percentage := s.Percentage
+		t.Percentage = &percentage
+	}
+	if s.ETAValid {
+		eta := int64(s.ETA / time.Second)
+		t.ETA = &eta
+	}
+	if !s.Start.IsZero() {
+		start := s.Start
+		t.Start = &start
+	}
+	return t
+}
+
+// MarshalJSON returns the AccountSnapshot as JSON
+func (s AccountSnapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newTransferJSON(s))
+}
+
+// MarshalJSON returns a snapshot of the Account stats as JSON
+func (acc *Account) MarshalJSON() ([]byte, error) {
+	return acc.Snapshot().MarshalJSON()
+}
+
+// statsJSON is the JSON representation of the StatsInfo
+//
+// Don't change the keys as scripts rely on them.
+type statsJSON struct {
+	Bytes        int64          `json:"bytes"`
+	Speed        float64        `json:"speed"` // bytes/s
+	Errors       int64          `json:"errors"`
+	Checks       int64          `json:"checks"`
+	Transfers    int64          `json:"transfers"`
+	Deletes      int64          `json:"deletes"`
+	ElapsedTime  float64        `json:"elapsedTime"` // seconds
+	ETA          *int64         `json:"eta"`         // seconds - null if unknown
+	TotalBytes   *int64         `json:"totalBytes"`  // null if unknown
+	RetriedBytes int64          `json:"retriedBytes"`
+	Uploaded     int64          `json:"uploadedBytes"`
+	Downloaded   int64          `json:"downloadedBytes"`
+	ServerSide   int64          `json:"serverSideBytes"`
+	Failed       int64          `json:"failedTransfers"`
+	Durations    *digestJSON    `json:"transferTimes"`  // seconds - null if none finished
+	Speeds       *digestJSON    `json:"transferSpeeds"` // bytes/s - null if none finished
+	PeakShort    float64        `json:"peakSpeed1s"`    // bytes/s - 0 if not measured yet
+	PeakLong     float64        `json:"peakSpeed10s"`   // bytes/s - 0 if not measured yet
+	RetryErrors  int64          `json:"retryableErrors"`
+	FatalErrors  int64          `json:"fatalErrors"`
+	LowRetries   int64          `json:"lowLevelRetries"`
+	HighRetries  int64          `json:"highLevelRetries"`
+	Checked      int64          `json:"checkedBytes"`
+	CheckSpeed   float64        `json:"checkSpeed"` // bytes/s of the checks in progress
+	Checking     []string       `json:"checking"`
+	Transferring []transferJSON `json:"transferring"`
+
+	// files which didn't need transferring
+	SkippedBytes  int64 `json:"skippedBytes"` // already up to date
+	SkippedFiles  int64 `json:"skippedFiles"`
+	FilteredBytes int64 `json:"filteredBytes"` // excluded by the filters
+	FilteredFiles int64 `json:"filteredFiles"`
+}
+
+// digestJSON is the JSON representation of the distribution of the
+// observations in a histogram
+type digestJSON struct {
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// newDigestJSON summarises h or returns nil if it is empty
+func newDigestJSON(h *histogram) *digestJSON {
+	if h.count == 0 {
+		return nil
+	}
+	return &digestJSON{
+		Count:  h.count,
+		Min:    h.min,
+		Median: h.quantile(0.5),
+		P95:    h.quantile(0.95),
+		Max:    h.max,
+	}
+}
+
+// MarshalJSON returns the StatsInfo as JSON
+func (s *StatsInfo) MarshalJSON() ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	dt := clk.Now().Sub(s.start)
+	out := statsJSON{
+		Bytes:        s.GetBytes(),
+		Errors:       s.errors,
+		Checks:       s.checks,
+		Transfers:    s.transfers,
+		Deletes:      s.deletes,
+		ElapsedTime:  dt.Seconds(),
+		RetriedBytes: s.retried,
+		Uploaded:     atomic.LoadInt64(&s.uploaded),
+		Downloaded:   atomic.LoadInt64(&s.downloaded),
+		ServerSide:   s.serverSide,
+		Failed:       s.failed,
+		Durations:    newDigestJSON(s.durations),
+		Speeds:       newDigestJSON(s.speeds),
+		PeakShort:    s.peakShort,
+		PeakLong:     s.peakLong,
+		RetryErrors:  s.retryErrors,
+		FatalErrors:  s.fatalErrors,
+		LowRetries:   s.lowRetries,
+		HighRetries:  s.highRetries,
+		Checked:      s.GetCheckedBytes(),
+		CheckSpeed:   s.checkProg.speed(),
+		Checking:     make([]string, 0, len(s.checking)),
+		Transferring: []transferJSON{},
+
+		SkippedBytes:  s.skipped,
+		SkippedFiles:  s.skippedFiles,
+		FilteredBytes: s.filtered,
+		FilteredFiles: s.filteredFiles,
+	}
+	if dt > 0 {
+		out.Speed = float64(out.Bytes) / dt.Seconds()
+	}
+	if eta, ok := s._eta(); ok {
+		seconds := int64(eta / time.Second)
+		out.ETA = &seconds
+	}
+	if s.totalKnown {
+		totalBytes := s.totalBytes
+		out.TotalBytes = &totalBytes
+	}
+	for name := range s.checking {
+		out.Checking = append(out.Checking, name)
+	}
+	sort.Strings(out.Checking)
+	// Snapshot all the transfers in progress at once so they are
+	// consistent with each other
+	sorted := append(s.inProgress.snapshots(), s.transferring.placeholders(s.inProgress.accounts())...)
+	// In the same order as the stats
+	sortSnapshots(sorted)
+	for _, snapshot := range sorted {
+		out.Transferring = append(out.Transferring, newTransferJSON(snapshot))
+	}
+	return json.Marshal(out)
+}
+
+// StatsJSON returns the stats of the whole accounting subsystem as
+// JSON.  This is the global Stats combined with the stats of any live
+// jobs (see AggregateStats) including every transfer in progress.
+func StatsJSON() ([]byte, error) {
+	return json.Marshal(AggregateStats())
+}
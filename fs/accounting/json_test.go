@@ -0,0 +1,107 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountMarshalJSON(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, -1, "test")
+
+	out, err := json.Marshal(acc)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"test","bytes":0,"size":null,"percentage":null,"speed":0,"speedAvg":0,"eta":null,"start":null,"id":"`+acc.ID()+`"}`, string(out))
+
+	assert.NoError(t, acc.Close())
+
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc = NewAccountSizeName(in, 4, "test")
+	_, err = acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	out, err = json.Marshal(acc)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, 2.0, decoded["bytes"])
+	assert.Equal(t, 4.0, decoded["size"])
+	assert.Equal(t, 50.0, decoded["percentage"])
+	assert.NotNil(t, decoded["start"])
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestStatsMarshalJSON(t *testing.T) {
+	s := NewStats()
+	s.Bytes(10)
+	s.Checking("b")
+	s.Checking("a")
+	s.Transferring("file")
+
+	out, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Bytes        int64
+		ETA          *int64 `json:"eta"`
+		TotalBytes   *int64 `json:"totalBytes"`
+		Checking     []string
+		Transferring []map[string]interface{}
+	}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, int64(10), decoded.Bytes)
+	assert.Nil(t, decoded.ETA)
+	assert.Nil(t, decoded.TotalBytes)
+	assert.Equal(t, []string{"a", "b"}, decoded.Checking)
+	require.Equal(t, 1, len(decoded.Transferring))
+	assert.Equal(t, "file", decoded.Transferring[0]["name"])
+	assert.Nil(t, decoded.Transferring[0]["size"])
+	assert.Contains(t, string(out), `"failedTransfers":0,"transferTimes":null,"transferSpeeds":null`)
+
+	s.doneAccount(TransferSnapshot{Start: time.Now(), Duration: 2 * time.Second, AverageSpeed: 100})
+	out, err = json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"transferTimes":{"count":1,"min":2,`)
+	assert.Contains(t, string(out), `"max":2}`)
+}
+
+func TestStatsJSON(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	job.Transferring("queued")
+
+	// Transfers in progress are included even if they haven't
+	// been marked as transferring
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeNameContext(WithStats(context.Background(), job), in, 3, "reading")
+	_, err := acc.Read(make([]byte, 2))
+	require.NoError(t, err)
+
+	out, err := StatsJSON()
+	require.NoError(t, err)
+	var decoded struct {
+		Bytes        int64
+		Transferring []transferJSON
+	}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.True(t, decoded.Bytes >= 2)
+	names := map[string]transferJSON{}
+	for _, tr := range decoded.Transferring {
+		names[tr.Name] = tr
+	}
+	require.Contains(t, names, "queued")
+	require.Contains(t, names, "reading")
+	assert.Equal(t, int64(2), names["reading"].Bytes)
+	require.NotNil(t, names["reading"].Size)
+	assert.Equal(t, int64(3), *names["reading"].Size)
+
+	require.NoError(t, acc.Close())
+}
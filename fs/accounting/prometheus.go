@@ -0,0 +1,134 @@
+package accounting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxPrometheusTransfers is the maximum number of transfers in
+// progress which are exported with per file labels.  This stops the
+// number of time series growing without bound when there are lots of
+// transfers.
+const maxPrometheusTransfers = 100
+
+// The metrics are written in the Prometheus text exposition format by
+// hand rather than with a prometheus.Collector as the Prometheus client
+// library isn't a dependency of rclone.  To add them to the metrics of
+// a program which has its own *prometheus.Registry serve the output of
+// WritePrometheus after that of the registry, eg
+//
+//     mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//             mfs, _ := registry.Gather()
+//             for _, mf := range mfs {
+//                     _, _ = expfmt.MetricFamilyToText(w, mf)
+//             }
+//             _ = accounting.WritePrometheus(w)
+//     })
+//
+// The rclone metric names all start with rclone_ so they don't clash
+// with the names in the registry.
+
+// PrometheusHandler returns an http.Handler which serves the
+// accounting stats of the whole process as Prometheus metrics in the
+// text exposition format.
+//
+// Mount it on an http.ServeMux to use it, eg
+//
+//     mux.Handle("/metrics", accounting.PrometheusHandler())
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the accounting stats of the whole process as
+// Prometheus metrics in the text exposition format to w.  These are
+// the global Stats combined with the stats of any live jobs (see
+// AggregateStats) in the same way as StatsJSON.
+//
+// Use this to add the rclone metrics to the output of an existing
+// metrics handler.
+func WritePrometheus(w io.Writer) error {
+	_, err := w.Write(AggregateStats().prometheus())
+	return err
+}
+
+// writeMetric writes a single valued metric with its help and type
+func writeMetric(buf *bytes.Buffer, name, kind, help string, value float64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(buf, "%s %s\n", name, formatFloat(value))
+}
+
+// writeHistogram writes h as a Prometheus histogram
+func writeHistogram(buf *bytes.Buffer, name, help string, h *histogram) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for i, count := range h.cumulative() {
+		fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(h.bounds[i]), count)
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(buf, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count %d\n", name, h.count)
+}
+
+// labelEscaper escapes label values in the Prometheus way
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// transferLabels returns the labels of the per file metrics of s.  The
+// name isn't unique, eg when the same file is copied to two remotes,
+// so the ID of the transfer is added to keep the series apart.
+func transferLabels(s AccountSnapshot) string {
+	return fmt.Sprintf("id=\"%s\",name=\"%s\"", labelEscaper.Replace(s.ID), labelEscaper.Replace(s.Name))
+}
+
+// writeTransfers writes per file metrics for the transfers in progress
+// limited to maxPrometheusTransfers of them
+func writeTransfers(buf *bytes.Buffer, snapshots []AccountSnapshot) {
+	if len(snapshots) > maxPrometheusTransfers {
+		snapshots = snapshots[:maxPrometheusTransfers]
+	}
+	fmt.Fprintf(buf, "# HELP rclone_transfer_progress_bytes Bytes transferred so far by each transfer in progress.\n")
+	fmt.Fprintf(buf, "# TYPE rclone_transfer_progress_bytes gauge\n")
+	for _, s := range snapshots {
+		fmt.Fprintf(buf, "rclone_transfer_progress_bytes{%s} %d\n", transferLabels(s), s.Bytes)
+	}
+	fmt.Fprintf(buf, "# HELP rclone_transfer_speed_bytes Current speed of each transfer in progress.\n")
+	fmt.Fprintf(buf, "# TYPE rclone_transfer_speed_bytes gauge\n")
+	for _, s := range snapshots {
+		fmt.Fprintf(buf, "rclone_transfer_speed_bytes{%s} %s\n", transferLabels(s), formatFloat(s.CurrentSpeed))
+	}
+}
+
+// formatFloat formats f in the Prometheus way
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// prometheus returns the stats as Prometheus metrics
+func (s *StatsInfo) prometheus() []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	buf := &bytes.Buffer{}
+	writeMetric(buf, "rclone_transfer_bytes_total", "counter", "Total bytes transferred.", float64(s.GetBytes()))
+	writeMetric(buf, "rclone_bytes_uploaded_total", "counter", "Total bytes written to remotes.", float64(atomic.LoadInt64(&s.uploaded)))
+	writeMetric(buf, "rclone_bytes_downloaded_total", "counter", "Total bytes read from remotes.", float64(atomic.LoadInt64(&s.downloaded)))
+	writeMetric(buf, "rclone_bytes_server_side_total", "counter", "Total bytes copied server side.", float64(s.serverSide))
+	writeMetric(buf, "rclone_errors_total", "counter", "Total number of errors.", float64(s.errors))
+	writeMetric(buf, "rclone_checks_total", "counter", "Total number of files checked.", float64(s.checks))
+	writeMetric(buf, "rclone_transfers_total", "counter", "Total number of files transferred.", float64(s.transfers))
+	writeMetric(buf, "rclone_transfers_failed_total", "counter", "Total number of files which failed to transfer.", float64(s.failed))
+	writeMetric(buf, "rclone_transfers_in_progress", "gauge", "Number of transfers in progress.", float64(s.inProgress.count()))
+	writeMetric(buf, "rclone_speed_bytes_per_second", "gauge", "Current speed of all the transfers in progress.", s.inProgress.speed())
+	writeHistogram(buf, "rclone_transfer_duration_seconds", "Time taken by each transfer.", s.durations)
+	writeHistogram(buf, "rclone_transfer_speed_bytes_per_second", "Average speed of each transfer.", s.speeds)
+	writeHistogram(buf, "rclone_transfer_first_byte_seconds", "Time to the first byte of each transfer.", s.firstBytes)
+	writeTransfers(buf, s.inProgress.snapshots())
+	return buf.Bytes()
+}
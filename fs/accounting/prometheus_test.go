@@ -0,0 +1,101 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.add(v)
+	}
+	assert.Equal(t, []int64{2, 1, 1}, h.counts)
+	assert.Equal(t, []int64{2, 3}, h.cumulative())
+	assert.Equal(t, int64(4), h.count)
+	assert.Equal(t, 106.5, h.sum)
+}
+
+func TestPrometheus(t *testing.T) {
+	s := NewStats()
+	s.Bytes(1234)
+	s.Errors(2)
+	s.doneAccount(TransferSnapshot{Start: time.Now(), Duration: 2 * time.Second, AverageSpeed: 1000})
+	s.doneAccount(TransferSnapshot{Error: errors.New("failed")})
+	out := string(s.prometheus())
+	assert.Contains(t, out, "# TYPE rclone_transfer_bytes_total counter\nrclone_transfer_bytes_total 1234\n")
+	assert.Contains(t, out, "\nrclone_errors_total 2\n")
+	assert.Contains(t, out, "# TYPE rclone_transfers_in_progress gauge\n")
+	assert.Contains(t, out, "\nrclone_transfer_duration_seconds_bucket{le=\"1\"} 0\n")
+	assert.Contains(t, out, "\nrclone_transfer_duration_seconds_bucket{le=\"2.5\"} 1\n")
+	assert.Contains(t, out, "\nrclone_transfer_duration_seconds_bucket{le=\"+Inf\"} 1\n")
+	assert.Contains(t, out, "\nrclone_transfer_duration_seconds_count 1\n")
+	assert.Contains(t, out, "\nrclone_transfer_speed_bytes_per_second_bucket{le=\"1024\"} 1\n")
+	assert.Contains(t, out, "\nrclone_transfers_failed_total 1\n")
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", PrometheusHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "rclone_transfer_bytes_total")
+}
+
+func TestPrometheusTransfers(t *testing.T) {
+	s := NewStats()
+	for i := 0; i < maxPrometheusTransfers+10; i++ {
+		name := fmt.Sprintf("file%03d", i)
+		s.inProgress.set(&Account{id: newAccountID(), name: name, avg: newMovingAverage()})
+	}
+	acc := &Account{id: newAccountID(), name: `a"b`, bytes: 42, avg: newMovingAverage()}
+	s.inProgress.set(acc)
+	out := string(s.prometheus())
+	assert.Contains(t, out, "# TYPE rclone_transfer_progress_bytes gauge\n")
+	assert.Contains(t, out, "\nrclone_transfer_progress_bytes{id=\""+acc.ID()+"\",name=\"a\\\"b\"} 42\n")
+	assert.Regexp(t, "\nrclone_transfer_speed_bytes\\{id=\"#\\d+\",name=\"file000\"\\} 0\n", out)
+	assert.Equal(t, maxPrometheusTransfers, strings.Count(out, "\nrclone_transfer_progress_bytes{"))
+
+	// Transfers with the same name are different series
+	s = NewStats()
+	s.inProgress.set(&Account{id: newAccountID(), name: "same", avg: newMovingAverage()})
+	s.inProgress.set(&Account{id: newAccountID(), name: "same", avg: newMovingAverage()})
+	out = string(s.prometheus())
+	series := regexp.MustCompile(`\nrclone_transfer_speed_bytes(\{[^}]*\})`).FindAllStringSubmatch(out, -1)
+	require.Equal(t, 2, len(series))
+	assert.NotEqual(t, series[0][1], series[1][1])
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "rclone_transfers_in_progress")
+}
+
+func TestWritePrometheusJobs(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	ctx := WithStats(context.Background(), job)
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeNameContext(ctx, in, 3, "job-metrics-file")
+	defer func() { _ = acc.Close() }()
+
+	// Transfers accounted to a job are in the metrics too
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "name=\"job-metrics-file\"")
+}
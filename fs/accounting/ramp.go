@@ -0,0 +1,115 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// Ramping up to the global bandwidth limit with --bwlimit-ramp
+//
+// Starting to send at the full limit straight away fills the buffers
+// of the routers on the way causing latency spikes for everything else
+// using the link.  Instead the first read after the global limit has
+// been idle starts a ramp from bwRampStart of the limit up to all of
+// it over --bwlimit-ramp.
+//
+// There is one ramp for all the transfers as they share the link.
+// Transfers which start while it is in progress join it rather than
+// starting one of their own, and transfers which start once it is
+// over go at the full limit straight away.
+//
+// The ramp doesn't have a token bucket of its own.  Instead reads
+// take more tokens from the global token bucket than they read,
+// 1/fraction times as many, so the global bucket stays empty during
+// the ramp and there is no burst when it finishes.  This means the
+// ramp follows any changes of the limit too.
+
+// Parameters of the ramp
+const (
+	bwRampStart = 0.1                    // fraction of the limit the ramp starts at
+	bwRampIdle  = time.Second            // idle time after which the next read starts a new ramp
+	bwRampStep  = 100 * time.Millisecond // the ramp takes reads in chunks of about this long
+)
+
+// bwRamp is the state of the ramp
+var bwRamp struct {
+	mu     sync.Mutex
+	active int       // number of reads waiting on the global limit
+	start  time.Time // when the current ramp started
+	last   time.Time // when the global limit last finished a read
+}
+
+// rampAcquire is called at now when a read starts waiting on the
+// global limit.  This starts a new ramp if the limit has been idle.
+func rampAcquire(now time.Time) {
+	bwRamp.mu.Lock()
+	defer bwRamp.mu.Unlock()
+	if bwRamp.active == 0 && (bwRamp.last.IsZero() || now.Sub(bwRamp.last) > bwRampIdle) {
+		bwRamp.start = now
+	}
+	bwRamp.active++
+}
+
+// rampRelease is called at now when a read has finished waiting on
+// the global limit
+func rampRelease(now time.Time) {
+	bwRamp.mu.Lock()
+	defer bwRamp.mu.Unlock()
+	bwRamp.active--
+	bwRamp.last = now
+}
+
+// rampFraction returns the fraction of the global bandwidth limit the
+// reads may use at now, 1 if the ramp is over
+func rampFraction(now time.Time) float64 {
+	ramp := fs.Config.BwLimitRamp
+	if ramp <= 0 {
+		return 1
+	}
+	bwRamp.mu.Lock()
+	elapsed := now.Sub(bwRamp.start)
+	bwRamp.mu.Unlock()
+	if elapsed >= ramp {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return bwRampStart + (1-bwRampStart)*float64(elapsed)/float64(ramp)
+}
+
+// waitRamped sleeps until tb, the global token bucket, allows n bytes
+// to pass, slowed down by the ramp if it is in progress.
+//
+// The bytes are taken a step of the ramp at a time so the rate goes up
+// during big reads too.
+func waitRamped(tb *rate.Limiter, n int) error {
+	if fs.Config.BwLimitRamp <= 0 || tb.Limit() == rate.Inf {
+		return waitTokens(tb, n)
+	}
+	rampAcquire(clk.Now())
+	defer func() {
+		rampRelease(clk.Now())
+	}()
+	for n > 0 {
+		fraction := rampFraction(clk.Now())
+		if fraction >= 1 {
+			return waitTokens(tb, n)
+		}
+		chunk := int(float64(tb.Limit()) * fraction * bwRampStep.Seconds())
+		if chunk < 1 {
+			chunk = 1
+		}
+		if chunk > n {
+			chunk = n
+		}
+		if err := waitTokens(tb, int(float64(chunk)/fraction)); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// useRamp sets --bwlimit-ramp to ramp with a fresh ramp returning a
+// function to put it back
+func useRamp(ramp time.Duration) func() {
+	oldRamp := fs.Config.BwLimitRamp
+	fs.Config.BwLimitRamp = ramp
+	bwRamp.mu.Lock()
+	bwRamp.active, bwRamp.start, bwRamp.last = 0, time.Time{}, time.Time{}
+	bwRamp.mu.Unlock()
+	return func() {
+		fs.Config.BwLimitRamp = oldRamp
+	}
+}
+
+func TestRampFraction(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	defer useRamp(10 * time.Second)()
+
+	// The first read starts the ramp
+	rampAcquire(clk.Now())
+	assert.InDelta(t, bwRampStart, rampFraction(clk.Now()), 1e-9)
+	c.advance(5 * time.Second)
+	assert.InDelta(t, bwRampStart+(1-bwRampStart)/2, rampFraction(clk.Now()), 1e-9)
+
+	// A read starting while another is waiting joins the ramp
+	rampAcquire(clk.Now())
+	rampRelease(clk.Now())
+	assert.InDelta(t, bwRampStart+(1-bwRampStart)/2, rampFraction(clk.Now()), 1e-9)
+	rampRelease(clk.Now())
+
+	// As does one starting soon after
+	c.advance(bwRampIdle / 2)
+	rampAcquire(clk.Now())
+	rampRelease(clk.Now())
+	c.advance(5 * time.Second)
+	assert.Equal(t, 1.0, rampFraction(clk.Now()))
+
+	// Being idle starts a new ramp
+	c.advance(2 * bwRampIdle)
+	rampAcquire(clk.Now())
+	assert.InDelta(t, bwRampStart, rampFraction(clk.Now()), 1e-9)
+	rampRelease(clk.Now())
+
+	// No ramp unless configured
+	fs.Config.BwLimitRamp = 0
+	assert.Equal(t, 1.0, rampFraction(clk.Now()))
+}
+
+func TestWaitRamped(t *testing.T) {
+	_, restore := useFakeClock()
+	defer restore()
+	defer useRamp(10 * time.Second)()
+
+	// At the start of the ramp reads take 1/bwRampStart times as many
+	// tokens.  At 1000/s with 10 in the bucket 5 bytes wait for 40.
+	tb := rate.NewLimiter(1000, 10)
+	start := time.Now()
+	assert.NoError(t, waitRamped(tb, 5))
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+
+	// Unlimited buckets aren't ramped
+	assert.NoError(t, waitRamped(rate.NewLimiter(rate.Inf, 10), 1000))
+
+	bwRamp.mu.Lock()
+	assert.Equal(t, 0, bwRamp.active)
+	bwRamp.mu.Unlock()
+}
@@ -0,0 +1,123 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+)
+
+// StatsSample is a sample of a StatsInfo sent to the subscribers
+// added with Subscribe every time the moving averages are updated
+type StatsSample struct {
+	Time         time.Time         // when the sample was taken
+	Bytes        int64             // bytes transferred
+	Speed        float64           // current total speed of the transfers in progress in bytes/s
+	Errors       int64             // number of errors
+	Checks       int64             // number of checks done
+	Transfers    int64             // number of transfers done
+	Transferring []AccountSnapshot // the transfers in progress in the --stats-sort order - shared so don't modify
+}
+
+// subscribers holds the channels added with Subscribe
+type subscribers struct {
+	mu   sync.Mutex
+	subs map[int]chan StatsSample
+	next int // id of the next subscriber
+}
+
+// Subscribe returns a channel which is sent a StatsSample of s every
+// time the moving averages are updated, once a second by default, for
+// as long as there are subscribers.  Use this rather than polling
+// String to show the stats, eg in a user interface.
+//
+// The channel has room for buffer samples.  Samples are dropped
+// rather than holding up the accounting if it is full, so read it
+// promptly.
+//
+// Call cancel to unsubscribe.  This closes the channel.  It is safe
+// to call more than once.
+func (s *StatsInfo) Subscribe(buffer int) (samples <-chan StatsSample, cancel func()) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	ch := make(chan StatsSample, buffer)
+	sub := &s.subscribers
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	id := sub.next
+	sub.next++
+	if len(sub.subs) == 0 {
+		if sub.subs == nil {
+			sub.subs = make(map[int]chan StatsSample)
+		}
+		// Tick even if there are no transfers - see publishSamples
+		averages.add(s)
+	}
+	sub.subs[id] = ch
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			sub.mu.Lock()
+			defer sub.mu.Unlock()
+			delete(sub.subs, id)
+			// Close with the lock held so publish can't send on it
+			close(ch)
+			if len(sub.subs) == 0 {
+				averages.remove(s)
+			}
+		})
+	}
+}
+
+// averageTick is called by the averager while s has subscribers.  The
+// samples are sent by publishSamples once all the Accounts have been
+// updated instead.
+func (s *StatsInfo) averageTick(now time.Time) {}
+
+// sample returns a StatsSample of s taken at now
+func (s *StatsInfo) sample(now time.Time) StatsSample {
+	s.lock.RLock()
+	sample := StatsSample{
+		Time:      now,
+		Bytes:     s.GetBytes(),
+		Errors:    s.errors,
+		Checks:    s.checks,
+		Transfers: s.transfers,
+	}
+	s.lock.RUnlock()
+	sample.Speed = s.inProgress.speed()
+	sample.Transferring = s.inProgress.snapshots()
+	sortSnapshots(sample.Transferring)
+	return sample
+}
+
+// publish sends a sample of s taken at now to each of its subscribers
+// which has room for it
+func (s *StatsInfo) publish(now time.Time) {
+	sub := &s.subscribers
+	sub.mu.Lock()
+	n := len(sub.subs)
+	sub.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	sample := s.sample(now)
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for _, ch := range sub.subs {
+		select {
+		case ch <- sample:
+		default:
+			// Drop the sample rather than wait for a slow subscriber
+		}
+	}
+}
+
+// publishSamples sends samples to the subscribers of the StatsInfo in
+// accs, after the Accounts have been updated for the tick at now
+func publishSamples(accs []averageTicker, now time.Time) {
+	for _, ticker := range accs {
+		if s, ok := ticker.(*StatsInfo); ok {
+			s.publish(now)
+		}
+	}
+}
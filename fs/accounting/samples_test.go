@@ -0,0 +1,82 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsSubscribe(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+	acc := NewAccountSizeNameContext(ctx, in, 10, "test-subscribe")
+	_, err := acc.Read(make([]byte, 4))
+	require.NoError(t, err)
+	s.Errors(1)
+
+	samples, cancel := s.Subscribe(1)
+	averages.mu.Lock()
+	_, registered := averages.accs[s]
+	averages.mu.Unlock()
+	assert.True(t, registered)
+
+	// Send a sample without waiting for the ticker
+	now := time.Now()
+	publishSamples([]averageTicker{acc, s}, now)
+	sample := <-samples
+	assert.Equal(t, now, sample.Time)
+	assert.Equal(t, int64(4), sample.Bytes)
+	assert.Equal(t, int64(1), sample.Errors)
+	require.Equal(t, 1, len(sample.Transferring))
+	assert.Equal(t, "test-subscribe", sample.Transferring[0].Name)
+
+	// Samples are dropped when the channel is full
+	publishSamples([]averageTicker{s}, now.Add(time.Second))
+	publishSamples([]averageTicker{s}, now.Add(2*time.Second))
+	sample = <-samples
+	assert.Equal(t, now.Add(time.Second), sample.Time)
+	select {
+	case <-samples:
+		t.Fatal("sample should have been dropped")
+	default:
+	}
+
+	// Cancel closes the channel and unregisters
+	cancel()
+	cancel()
+	_, ok := <-samples
+	assert.False(t, ok)
+	averages.mu.Lock()
+	_, registered = averages.accs[s]
+	averages.mu.Unlock()
+	assert.False(t, registered)
+	publishSamples([]averageTicker{s}, now)
+
+	require.NoError(t, acc.Close())
+}
+
+func TestStatsSubscribeTicks(t *testing.T) {
+	oldAvgWindow := fs.Config.StatsAvgWindow
+	defer func() {
+		fs.Config.StatsAvgWindow = oldAvgWindow
+	}()
+	fs.Config.StatsAvgWindow = time.Second
+
+	// Samples are sent from the averager even with no transfers
+	s := NewStats()
+	samples, cancel := s.Subscribe(10)
+	defer cancel()
+	select {
+	case sample := <-samples:
+		assert.Equal(t, 0, len(sample.Transferring))
+	case <-time.After(10 * time.Second):
+		t.Fatal("no sample received")
+	}
+}
@@ -0,0 +1,96 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+)
+
+// smallFilesWindow is the number of seconds the speed of the small
+// files is averaged over
+const smallFilesWindow = 5
+
+// smallFiles adds up the bytes of the transfers of files smaller than
+// --stats-small-files so their combined speed can be shown on one
+// line.  Each of them is usually over too quickly to have a speed of
+// its own.
+//
+// The bytes are kept in a bucket for each second of the window so
+// there is nothing to update when nothing is being transferred.
+type smallFiles struct {
+	mu      sync.Mutex
+	first   time.Time               // time of the first byte
+	bytes   [smallFilesWindow]int64 // bytes transferred in each second
+	seconds [smallFilesWindow]int64 // unix time of the second in bytes
+}
+
+// add counts n bytes transferred at now
+func (sf *smallFiles) add(n int, now time.Time) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.first.IsZero() {
+		sf.first = now
+	}
+	sec := now.Unix()
+	i := sec % smallFilesWindow
+	if sf.seconds[i] != sec {
+		sf.seconds[i] = sec
+		sf.bytes[i] = 0
+	}
+	sf.bytes[i] += int64(n)
+}
+
+// merge adds the bytes of other to sf
+func (sf *smallFiles) merge(other *smallFiles) {
+	other.mu.Lock()
+	first, bytes, seconds := other.first, other.bytes, other.seconds
+	other.mu.Unlock()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.first.IsZero() || (!first.IsZero() && first.Before(sf.first)) {
+		sf.first = first
+	}
+	for i, sec := range seconds {
+		switch {
+		case sec == sf.seconds[i]:
+			sf.bytes[i] += bytes[i]
+		case sec > sf.seconds[i]:
+			sf.seconds[i] = sec
+			sf.bytes[i] = bytes[i]
+		}
+	}
+}
+
+// speed returns the combined speed of the small files over the last
+// smallFilesWindow seconds, or since the first byte if that is
+// sooner, in bytes per second
+func (sf *smallFiles) speed(now time.Time) float64 {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.first.IsZero() {
+		return 0
+	}
+	sec := now.Unix()
+	var total int64
+	for i, s := range sf.seconds {
+		if s > sec-smallFilesWindow && s <= sec {
+			total += sf.bytes[i]
+		}
+	}
+	window := time.Duration(smallFilesWindow) * time.Second
+	if elapsed := now.Sub(sf.first); elapsed < window {
+		window = elapsed
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	return float64(total) / window.Seconds()
+}
+
+// reset forgets all the bytes
+func (sf *smallFiles) reset() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.first = time.Time{}
+	sf.bytes = [smallFilesWindow]int64{}
+	sf.seconds = [smallFilesWindow]int64{}
+}
@@ -0,0 +1,77 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmallFilesSpeed(t *testing.T) {
+	var sf smallFiles
+	t0 := time.Unix(1000, 0)
+	assert.Equal(t, 0.0, sf.speed(t0))
+
+	// Averaged since the first byte until the window is full
+	sf.add(100, t0)
+	sf.add(100, t0.Add(500*time.Millisecond))
+	assert.Equal(t, 200.0, sf.speed(t0.Add(500*time.Millisecond)))
+	sf.add(200, t0.Add(1500*time.Millisecond))
+	assert.Equal(t, 200.0, sf.speed(t0.Add(2*time.Second)))
+
+	// Then over the last smallFilesWindow seconds
+	later := t0.Add(smallFilesWindow * time.Second)
+	sf.add(500, later)
+	assert.Equal(t, float64(200+500)/smallFilesWindow, sf.speed(later))
+	assert.Equal(t, 0.0, sf.speed(later.Add(smallFilesWindow*time.Second)))
+
+	// Merging adds up the same seconds
+	var other smallFiles
+	other.add(500, later)
+	sf.merge(&other)
+	assert.Equal(t, float64(200+1000)/smallFilesWindow, sf.speed(later))
+
+	sf.reset()
+	assert.Equal(t, 0.0, sf.speed(later))
+}
+
+func TestStatsSmallFiles(t *testing.T) {
+	oldSmallFiles := fs.Config.StatsSmallFiles
+	defer func() {
+		fs.Config.StatsSmallFiles = oldSmallFiles
+	}()
+	fs.Config.StatsSmallFiles = 10
+
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	newAcc := func(size int64, name string) *Account {
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, size)))
+		s.Transferring(name)
+		return NewAccountSizeNameContext(ctx, in, size, name)
+	}
+	small1 := newAcc(5, "small1")
+	small2 := newAcc(5, "small2")
+	big := newAcc(100, "big")
+	_, err := small1.Read(make([]byte, 5))
+	require.NoError(t, err)
+
+	out := s.String()
+	assert.Contains(t, out, " * 2 small files: ")
+	assert.Contains(t, out, "big: ")
+	assert.NotContains(t, out, "small1: ")
+
+	// Off lists them all
+	fs.Config.StatsSmallFiles = 0
+	out = s.String()
+	assert.NotContains(t, out, "small files")
+	assert.Contains(t, out, "small1: ")
+
+	for _, acc := range []*Account{small1, small2, big} {
+		require.NoError(t, acc.Close())
+	}
+}
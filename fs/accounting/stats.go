@@ -3,15 +3,21 @@ package accounting
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fs/asyncreader"
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/ncw/rclone/fs/hash"
 )
 
 var (
-	// Stats is global statistics counter
+	// Stats is global statistics counter.  It is used by Accounts
+	// unless another StatsInfo is set on their context with WithStats.
 	Stats = NewStats()
 )
 
@@ -22,8 +28,14 @@ func init() {
 
 // StatsInfo accounts all transfers
 type StatsInfo struct {
+	// These are updated with atomics for every read so they don't
+	// contend on lock.  They must stay at the start of the struct
+	// so they are 64 bit aligned on 32 bit platforms.
+	bytes        int64 // bytes transferred
+	uploaded     int64 // bytes written to remotes
+	downloaded   int64 // bytes read from remotes
+	checked      int64 // bytes read for checks - see NewAccountCheck
 	lock         sync.RWMutex
-	bytes        int64
 	errors       int64
 	lastError    error
 	checks       int64
@@ -33,6 +45,50 @@ type StatsInfo struct {
 	deletes      int64
 	start        time.Time
 	inProgress   *inProgress
+	totalKnown   bool       // set if the totals below are known
+	totalBytes   int64      // total bytes to be transferred in this job
+	totalFiles   int64      // total files to be transferred in this job
+	totalBase    int64      // bytes done when ResetTotals was called - see _doneBytes
+	filesBase    int64      // files transferred when ResetTotals was called
+	durations    *histogram // time taken by each successful transfer in seconds
+	speeds       *histogram // average speed of each successful transfer in bytes/s
+	failed       int64      // transfers which finished with an error
+	firstBytes   *histogram // time to first byte of each transfer in seconds
+	retried      int64      // bytes discarded by transfers which were retried
+	serverSide   int64      // bytes copied server side
+	callbackMu   sync.Mutex // protects the callbacks
+	callbacks    map[int]func(TransferSnapshot)
+	nextCallback int        // id of the next callback added
+	pauseMu      sync.Mutex // held while pausing or resuming all the transfers
+	paused       bool       // set by PauseAll - guarded by pauseMu
+
+	// total speed of the transfers at each recent average tick
+	// for the peak speeds - see addSpeedSample
+	recent    speedHistory
+	peakShort float64 // fastest total speed over peakShortWindow in bytes/s
+	peakLong  float64 // fastest total speed over peakLongWindow in bytes/s
+
+	// errors broken down by whether a retry of the sync could fix
+	// them - these add up to errors
+	retryErrors int64 // errors which can be retried
+	fatalErrors int64 // fatal or no retry errors
+	lowRetries  int64 // low level retries done - see LowLevelRetry
+	highRetries int64 // retryable errors reset by ResetErrors to retry the sync
+
+	// checks in progress made with NewAccountCheck
+	checkProg *inProgress
+
+	// combined speed of the transfers under --stats-small-files
+	small smallFiles
+
+	// channels to send samples to - see Subscribe
+	subscribers subscribers
+
+	// files which didn't need transferring - see AddSkipped and AddFiltered
+	skipped       int64 // bytes of the files which were already up to date
+	skippedFiles  int64 // number of files which were already up to date
+	filtered      int64 // bytes of the files excluded by the filters
+	filteredFiles int64 // number of files excluded by the filters
 }
 
 // NewStats cretates an initialised StatsInfo
@@ -40,66 +96,699 @@ func NewStats() *StatsInfo {
 	return &StatsInfo{
 		checking:     make(stringSet, fs.Config.Checkers),
 		transferring: make(stringSet, fs.Config.Transfers),
-		start:        time.Now(),
+		start:        clk.Now(),
 		inProgress:   newInProgress(),
+		checkProg:    newInProgress(),
+		durations:    newHistogram(durationBounds),
+		speeds:       newHistogram(speedBounds),
+		firstBytes:   newHistogram(firstByteBounds),
+		callbacks:    make(map[int]func(TransferSnapshot)),
 	}
 }
 
-// String convert the StatsInfo to a string for printing
+// TransferSnapshot is a record of a finished transfer passed to the
+// callbacks registered with OnTransferComplete
+type TransferSnapshot struct {
+	ID           string        // unique ID of the Account - see Account.ID
+	Name         string        // name of the transfer
+	Size         int64         // size of the transfer or -1 if unknown
+	Bytes        int64         // bytes transferred
+	Start        time.Time     // time of the first read - zero if nothing was read
+	Duration     time.Duration // time since the first read
+	AverageSpeed float64       // bytes per second over the whole transfer
+	FirstByte    time.Duration // time from the start to the first byte - 0 if none
+	Error        error         // error the transfer finished with or nil
+
+	Retries int                  // number of times the transfer was retried
+	Hashes  map[hash.Type]string // hashes set up by WithHash if complete - nil otherwise
+}
+
+// OnTransferComplete registers fn to be called with a TransferSnapshot
+// every time a transfer finishes.  Call the returned function to
+// unregister fn.
+//
+// fn is called without any accounting locks held on the goroutine
+// which finished the transfer so it should be quick.
+func (s *StatsInfo) OnTransferComplete(fn func(TransferSnapshot)) (remove func()) {
+	s.callbackMu.Lock()
+	defer s.callbackMu.Unlock()
+	id := s.nextCallback
+	s.nextCallback++
+	s.callbacks[id] = fn
+	return func() {
+		s.callbackMu.Lock()
+		defer s.callbackMu.Unlock()
+		delete(s.callbacks, id)
+	}
+}
+
+// transferComplete calls the callbacks registered with
+// OnTransferComplete with snapshot
+func (s *StatsInfo) transferComplete(snapshot TransferSnapshot) {
+	s.callbackMu.Lock()
+	ids := make([]int, 0, len(s.callbacks))
+	for id := range s.callbacks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fns := make([]func(TransferSnapshot), 0, len(ids))
+	for _, id := range ids {
+		fns = append(fns, s.callbacks[id])
+	}
+	s.callbackMu.Unlock()
+	for _, fn := range fns {
+		fn(snapshot)
+	}
+}
+
+// String convert the StatsInfo to a string for printing - on one line
+// if --stats-one-line is set or all the stats otherwise
 func (s *StatsInfo) String() string {
+	if fs.Config.StatsOneLine {
+		return s.OneLine()
+	}
+	return s.Full()
+}
+
+// OneLine returns the main stats on one line for cron emails and logs
+// which are read by scripts, prefixed with the time if
+// --stats-one-line-date is set, eg
+//
+//	1.234G / 5.000G, 24%, 3.200 MB/s, ETA 19m3s, errors 0, checks 1234, transfers 321
+//
+// The total and percentage are "-" if the total isn't known.  There is
+// no trailing newline.  Use Full for the transfers in progress.
+func (s *StatsInfo) OneLine() string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	dt := time.Now().Sub(s.start)
+	now := clk.Now()
+	transferred := s.GetBytes()
+	speed := 0.0
+	if dt := now.Sub(s.start); dt > 0 {
+		speed = float64(transferred) / dt.Seconds()
+	}
+	total, percent := "-", "-"
+	if s.totalKnown {
+		total = sizeString(fs.SizeSuffix(s.totalBytes))
+		if s.totalBytes > 0 {
+			percent = fmt.Sprintf("%d%%", percentDone(s._doneBytes(), s.totalBytes))
+		}
+	}
+	etas := "-"
+	if eta, ok := s._eta(); ok {
+		etas = eta.String()
+	}
+	line := fmt.Sprintf("%s / %s, %s, %s, ETA %s, errors %d, checks %d, transfers %d",
+		sizeString(fs.SizeSuffix(transferred)), total, percent, FormatRate(speed), etas,
+		s.errors, s.checks, s.transfers)
+	if fs.Config.StatsOneLineDate {
+		line = now.Format(time.RFC3339) + " " + line
+	}
+	return line
+}
+
+// percentDone returns done as a whole percentage of total, which must
+// be > 0, rounded down and at most 100
+func percentDone(done, total int64) int64 {
+	percent := int64(float64(done) * 100 / float64(total))
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// Full returns all the stats, including the transfers in progress,
+// as a block of lines whatever --stats-one-line says
+func (s *StatsInfo) Full() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	transferred := s.GetBytes()
+	uploaded := atomic.LoadInt64(&s.uploaded)
+	downloaded := atomic.LoadInt64(&s.downloaded)
+	dt := clk.Now().Sub(s.start)
 	dtSeconds := dt.Seconds()
 	speed := 0.0
 	if dt > 0 {
-		speed = float64(s.bytes) / dtSeconds
+		speed = float64(transferred) / dtSeconds
 	}
 	dtRounded := dt - (dt % (time.Second / 10))
 	buf := &bytes.Buffer{}
+	etas := "-"
+	if eta, ok := s._eta(); ok {
+		if eta > 0 {
+			etas = etaString(eta, clk.Now())
+		} else {
+			etas = eta.String()
+		}
+	}
+	transfers := fmt.Sprintf("%10d", s.transfers)
+	if s.totalKnown {
+		transfers += fmt.Sprintf(" / %d", s.totalFiles)
+	}
 
-	if fs.Config.DataRateUnit == "bits" {
-		speed = speed * 8
+	errs := fmt.Sprintf("%10d", s.errors)
+	if s.errors > 0 {
+		errs += fmt.Sprintf(" (retryable %d, fatal %d)", s.retryErrors, s.fatalErrors)
 	}
 
 	fmt.Fprintf(buf, `
 Transferred:   %10s (%s)
-Errors:        %10d
+Errors:        %s
 Checks:        %10d
-Transferred:   %10d
+Transferred:   %s
 Elapsed time:  %10v
+ETA:           %10s
 `,
-		fs.SizeSuffix(s.bytes).Unit("Bytes"), fs.SizeSuffix(speed).Unit(strings.Title(fs.Config.DataRateUnit)+"/s"),
-		s.errors,
+		FormatSize(transferred), FormatRate(speed),
+		errs,
 		s.checks,
-		s.transfers,
-		dtRounded)
+		transfers,
+		dtRounded,
+		etas)
+	if s.IsPaused() {
+		buf.WriteString("Paused:        all transfers\n")
+	}
+	if s.retried > 0 {
+		fmt.Fprintf(buf, "Retried:       %10s\n", FormatSize(s.retried))
+	}
+	if s.lowRetries > 0 || s.highRetries > 0 {
+		fmt.Fprintf(buf, "Retries:       %10d (low level), %d (high level)\n", s.lowRetries, s.highRetries)
+	}
+	if uploaded > 0 || downloaded > 0 {
+		fmt.Fprintf(buf, "Uploaded:      %10s\n", FormatSize(uploaded))
+		fmt.Fprintf(buf, "Downloaded:    %10s\n", FormatSize(downloaded))
+	}
+	if s.serverSide > 0 {
+		fmt.Fprintf(buf, "Server side:   %10s\n", FormatSize(s.serverSide))
+	}
+	if checked := s.GetCheckedBytes(); checked > 0 {
+		// Show the current speed so slow checks don't look hung
+		fmt.Fprintf(buf, "Checked:       %10s (%s)\n", FormatSize(checked), FormatRate(s.checkProg.speed()))
+	}
+	if s.skippedFiles > 0 {
+		fmt.Fprintf(buf, "Up to date:    %10s (%d files)\n", FormatSize(s.skipped), s.skippedFiles)
+	}
+	if s.filteredFiles > 0 {
+		fmt.Fprintf(buf, "Filtered:      %10s (%d files)\n", FormatSize(s.filtered), s.filteredFiles)
+	}
+	if h := s.durations; h.count > 0 {
+		fmt.Fprintf(buf, "Durations:     %10v (min), %v (median), %v (95%%), %v (max)\n",
+			secondsToDuration(h.min), secondsToDuration(h.quantile(0.5)), secondsToDuration(h.quantile(0.95)), secondsToDuration(h.max))
+	}
+	if h := s.speeds; h.count > 0 {
+		fmt.Fprintf(buf, "Speeds:        %10s (min), %s (median), %s (95%%), %s (max)\n",
+			FormatRate(h.min), FormatRate(h.quantile(0.5)), FormatRate(h.quantile(0.95)), FormatRate(h.max))
+	}
+	if s.failed > 0 {
+		fmt.Fprintf(buf, "Failed:        %10d\n", s.failed)
+	}
+	if s.firstBytes.count > 0 {
+		fmt.Fprintf(buf, "First byte:    %10v (median), %v (90%%)\n", secondsToDuration(s.firstBytes.quantile(0.5)), secondsToDuration(s.firstBytes.quantile(0.9)))
+	}
+	if used, limit := asyncreader.MemoryUsed(); limit > 0 {
+		fmt.Fprintf(buf, "Buffer:        %10s / %s\n", FormatSize(used), FormatSize(limit))
+	}
+	if max := int64(fs.Config.MaxTransfer); max >= 0 {
+		left := max - transferred
+		if left < 0 {
+			left = 0
+		}
+		fmt.Fprintf(buf, "Max transfer:  %10s left of %s\n", FormatSize(left), FormatSize(max))
+	}
+	if remaining, ok := ByteBudgetRemaining(); ok {
+		fmt.Fprintf(buf, "Budget:        %10s left\n", FormatSize(remaining))
+	}
+	if s.peakShort > 0 {
+		fmt.Fprintf(buf, "Peak speed:    %10s (%v), %s (%v)\n", FormatRate(s.peakShort), peakShortWindow, FormatRate(s.peakLong), peakLongWindow)
+	}
+	if bw, limited := bandwidthLimit(); limited {
+		fmt.Fprintf(buf, "Bwlimit:       %10s\n", FormatRate(float64(bw)))
+	}
+	for _, limit := range remoteBandwidthLimits() {
+		bw := "off"
+		if limit.bandwidth > 0 {
+			bw = FormatRate(float64(limit.bandwidth))
+		}
+		fmt.Fprintf(buf, "%-15s%10s, %s transferred\n", "Bwlimit "+limit.name+":", bw, FormatSize(limit.bytes))
+	}
 	if len(s.checking) > 0 {
-		fmt.Fprintf(buf, "Checking:\n%s\n", s.checking)
+		fmt.Fprintf(buf, "Checking:\n%s\n", s.checking.String(s.checkProg))
 	}
 	if len(s.transferring) > 0 {
-		fmt.Fprintf(buf, "Transferring:\n%s\n", s.transferring)
+		lines, small := s.transferring.stringsHideSmall(s.inProgress)
+		if small > 0 {
+			lines = append(lines, fmt.Sprintf(" * %d small files: %s", small, FormatRate(s.small.speed(clk.Now()))))
+		}
+		fmt.Fprintf(buf, "Transferring:\n%s\n", strings.Join(lines, "\n"))
 	}
 	return buf.String()
 }
 
+// FormatRate formats bps bytes per second for the stats, as bits per
+// second with a "b/s" suffix if --stats-unit bits is in use or as
+// bytes with a "B/s" suffix otherwise, eg "1.500 MB/s".  The
+// multipliers are set by --size-unit.
+func FormatRate(bps float64) string {
+	unit := "B/s"
+	if fs.Config.DataRateUnit == "bits" {
+		bps *= 8
+		unit = "b/s"
+	}
+	// Round to the nearest rather than truncating
+	return sizeUnit(fs.SizeSuffix(bps+0.5), unit)
+}
+
+// FormatSize returns bytes as a string with a unit, eg "1.500 MBytes",
+// with the multipliers set by fs.Config.SizeUnit.
+func FormatSize(bytes int64) string {
+	return sizeUnit(fs.SizeSuffix(bytes), "Bytes")
+}
+
+// sizeUnit returns x as a string with unit using the multipliers set
+// by fs.Config.SizeUnit
+func sizeUnit(x fs.SizeSuffix, unit string) string {
+	if fs.Config.SizeUnit == "si" {
+		return x.SIUnit(unit)
+	}
+	return x.Unit(unit)
+}
+
+// sizeString returns x as a string without a unit using the
+// multipliers set by fs.Config.SizeUnit
+func sizeString(x fs.SizeSuffix) string {
+	if fs.Config.SizeUnit == "si" {
+		return x.SIString()
+	}
+	return x.String()
+}
+
+// _eta returns the ETA of the whole job from the totals and the
+// current speed of the transfers in progress.  If the ETA cannot be
+// determined 'ok' returns false.
+//
+// If the number of files is known then this allows for only
+// --transfers of them running at once using jobETA.
+//
+// Call with lock held.
+func (s *StatsInfo) _eta() (eta time.Duration, ok bool) {
+	if !s.totalKnown {
+		return 0, false
+	}
+	left := s.totalBytes - s._doneBytes()
+	if left <= 0 {
+		return 0, true
+	}
+	speed := s.inProgress.speed()
+	if speed <= 0 {
+		return 0, false
+	}
+	filesLeft := s.totalFiles - s.transfers
+	if filesLeft <= 0 {
+		seconds := float64(left) / speed
+		return roundETA(time.Second * time.Duration(int64(seconds))), true
+	}
+	active := s.inProgress.count()
+	if active < 1 {
+		active = 1
+	}
+	return roundETA(jobETA(left, filesLeft, fs.Config.Transfers, speed/float64(active))), true
+}
+
+// jobETA estimates the time to transfer bytesLeft bytes in filesLeft
+// files if each transfer goes at speed bytes/s and only transfers of
+// them run at once, rounded to full seconds.
+//
+// The files are taken to be the same size so they are done in waves
+// of transfers files, and the last wave takes as long as the others
+// however few files are in it.
+func jobETA(bytesLeft, filesLeft int64, transfers int, speed float64) time.Duration {
+	if transfers < 1 {
+		transfers = 1
+	}
+	waves := (filesLeft + int64(transfers) - 1) / int64(transfers)
+	fileSize := float64(bytesLeft) / float64(filesLeft)
+	seconds := float64(waves) * fileSize / speed
+	return time.Second * time.Duration(int64(seconds))
+}
+
+// _doneBytes returns the bytes of the job which have been done, which
+// includes those copied server side as they are counted in the totals
+// but not in the bytes transferred - call with lock held
+func (s *StatsInfo) _doneBytes() int64 {
+	return s.GetBytes() + s.serverSide
+}
+
+// SetTotalBytes sets the total number of bytes to be transferred by
+// the job, or since ResetTotals was called.  It may be called again if
+// the total grows.
+//
+// Until this is called the stats won't show an ETA for the job.
+func (s *StatsInfo) SetTotalBytes(totalBytes int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.totalKnown = true
+	s.totalBytes = s.totalBase + totalBytes
+}
+
+// SetTotalTransfers sets the total number of files to be transferred
+// by the job, or since ResetTotals was called.  It may be called again
+// if the total grows.
+func (s *StatsInfo) SetTotalTransfers(totalTransfers int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.totalKnown = true
+	s.totalFiles = s.filesBase + totalTransfers
+}
+
+// ResetTotals forgets the totals set with SetTotalBytes and
+// SetTotalTransfers, eg before a retry of the sync works out the
+// totals for what is left to do.  The totals set after this are of
+// what is left so they are added to what has been done already to
+// compare them with the bytes and transfers which count up over all
+// the tries.
+func (s *StatsInfo) ResetTotals() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.totalKnown = false
+	s.totalBase = s._doneBytes()
+	s.filesBase = s.transfers
+	s.totalBytes = s.totalBase
+	s.totalFiles = s.filesBase
+}
+
+// doneAccount records the stats of a finished Account
+//
+// Transfers which errored are only counted as they would distort the
+// distribution of the durations and speeds.
+func (s *StatsInfo) doneAccount(snapshot TransferSnapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if snapshot.Error != nil {
+		s.failed++
+		return
+	}
+	if snapshot.Start.IsZero() {
+		return
+	}
+	s.durations.add(snapshot.Duration.Seconds())
+	s.speeds.add(snapshot.AverageSpeed)
+}
+
+// firstByte records the time to first byte of a transfer
+func (s *StatsInfo) firstByte(ttfb time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.firstBytes.add(ttfb.Seconds())
+}
+
+// AbortTransfer aborts the transfer in progress with the ID or name
+// nameOrID.  Its stream is closed, which unblocks a Read waiting on it
+// if the backend supports that, and all its Reads return
+// ErrorTransferAborted.
+//
+// A name may be prefixed with the name of the remote, eg
+// "remote:path/to/file", to tell apart transfers with the same name.
+// It returns an error if there is no such transfer in progress, or one
+// with ErrorTransferAmbiguous as the cause if the name matches more
+// than one, in which case nothing is aborted.
+func (s *StatsInfo) AbortTransfer(nameOrID string) error {
+	acc, err := s.inProgress.lookup(nameOrID)
+	if err != nil {
+		return err
+	}
+	fs.Infof(acc.Name(), "Aborting transfer %s", acc.id)
+	acc.Abort(ErrorTransferAborted)
+	return nil
+}
+
+// TransferDone returns a channel which is closed when the transfer in
+// progress with the ID or name nameOrID finishes, found in the same
+// way as AbortTransfer does.
+func (s *StatsInfo) TransferDone(nameOrID string) (<-chan struct{}, error) {
+	acc, err := s.inProgress.lookup(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	return acc.Done(), nil
+}
+
+// PauseAll pauses all the transfers in progress, and any started
+// before ResumeAll is called, with Account.Pause.
+func (s *StatsInfo) PauseAll() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	s.paused = true
+	for _, acc := range s.inProgress.accounts() {
+		acc.Pause()
+	}
+}
+
+// ResumeAll resumes all the transfers paused with PauseAll or
+// Account.Pause.
+//
+// The transfers go through the bandwidth limiters as normal so they
+// can only burst as much as they could after being idle.
+func (s *StatsInfo) ResumeAll() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	s.paused = false
+	for _, acc := range s.inProgress.accounts() {
+		acc.Resume()
+	}
+}
+
+// IsPaused returns true if the transfers have been paused with
+// PauseAll
+func (s *StatsInfo) IsPaused() bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	return s.paused
+}
+
+// pauseIfPaused pauses acc if the transfers have been paused with
+// PauseAll
+func (s *StatsInfo) pauseIfPaused(acc *Account) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.paused {
+		acc.Pause()
+	}
+}
+
+// Windows the peak speeds are measured over
+const (
+	peakShortWindow = time.Second
+	peakLongWindow  = 10 * time.Second
+)
+
+// ticksIn returns the number of average ticks of interval in window,
+// which is at least 1
+func ticksIn(window, interval time.Duration) int {
+	n := int((window + interval/2) / interval)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// addSpeedSample adds the total speed of the transfers at an average
+// tick, interval after the last one, updating the peak speeds.
+//
+// A peak is only measured once there have been enough ticks to cover
+// its window.
+func (s *StatsInfo) addSpeedSample(speed float64, interval time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	short, long := ticksIn(peakShortWindow, interval), ticksIn(peakLongWindow, interval)
+	if cap(s.recent.samples) != long {
+		s.recent = newSpeedHistory(long)
+	}
+	s.recent.add(speed)
+	if avg, ok := s.recent.average(short); ok && avg > s.peakShort {
+		s.peakShort = avg
+	}
+	if avg, ok := s.recent.average(long); ok && avg > s.peakLong {
+		s.peakLong = avg
+	}
+}
+
+// PeakSpeeds returns the fastest total speed of the transfers in
+// bytes/s averaged over 1 second (short) and 10 seconds (long), or 0
+// if they haven't been measured yet.
+func (s *StatsInfo) PeakSpeeds() (short, long float64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.peakShort, s.peakLong
+}
+
 // Log outputs the StatsInfo to the log
 func (s *StatsInfo) Log() {
 	fs.LogLevelPrintf(fs.Config.StatsLogLevel, nil, "%v\n", s)
 }
 
+// LogFull outputs all the stats to the log even with --stats-one-line
+func (s *StatsInfo) LogFull() {
+	fs.LogLevelPrintf(fs.Config.StatsLogLevel, nil, "%s\n", s.Full())
+}
+
+// StartStatsSignalHandler makes rclone log all the stats of the jobs,
+// see AggregateStats, when it gets a SIGUSR1 so the transfers in
+// progress can be seen with --stats-one-line.  It does nothing on
+// platforms without SIGUSR1.
+func StartStatsSignalHandler() {
+	startStatsSignalHandler()
+}
+
 // Bytes updates the stats for bytes bytes
 func (s *StatsInfo) Bytes(bytes int64) {
+	atomic.AddInt64(&s.bytes, bytes)
+	spendBudget(bytes)
+}
+
+// GetBytes returns the number of bytes transferred so far
+func (s *StatsInfo) GetBytes() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+// transferBytes updates the stats for bytes bytes transferred in the
+// direction dir.
+//
+// The uploaded and downloaded bytes aren't reduced if the transfer is
+// retried as the data was still transferred.
+//
+// This is called for every read so it doesn't take the lock.
+func (s *StatsInfo) transferBytes(bytes int64, dir Direction) {
+	atomic.AddInt64(&s.bytes, bytes)
+	spendBudget(bytes)
+	if dir&Upload != 0 {
+		atomic.AddInt64(&s.uploaded, bytes)
+	}
+	if dir&Download != 0 {
+		atomic.AddInt64(&s.downloaded, bytes)
+	}
+}
+
+// checkBytes updates the stats for bytes bytes read for a check
+func (s *StatsInfo) checkBytes(bytes int64) {
+	atomic.AddInt64(&s.checked, bytes)
+}
+
+// GetCheckedBytes returns the number of bytes read for checks by
+// Accounts made with NewAccountCheck
+func (s *StatsInfo) GetCheckedBytes() int64 {
+	return atomic.LoadInt64(&s.checked)
+}
+
+// ServerSideBytes updates the stats for bytes bytes copied server
+// side.  These don't flow through rclone so they aren't counted in
+// the bytes transferred.
+func (s *StatsInfo) ServerSideBytes(bytes int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.serverSide += bytes
+}
+
+// AddSkipped counts a file of size bytes (-1 if unknown) which didn't
+// need transferring because it was already up to date, eg as found by
+// the comparison of the sync.
+func (s *StatsInfo) AddSkipped(size int64) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.bytes += bytes
+	s.skippedFiles++
+	if size > 0 {
+		s.skipped += size
+	}
 }
 
-// Errors updates the stats for errors
+// GetSkipped returns the bytes and number of the files counted with
+// AddSkipped
+func (s *StatsInfo) GetSkipped() (bytes, files int64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.skipped, s.skippedFiles
+}
+
+// AddFiltered counts a file of size bytes (-1 if unknown) which wasn't
+// transferred because it was excluded by the filters, eg --max-size.
+// These are counted separately from the files counted by AddSkipped.
+func (s *StatsInfo) AddFiltered(size int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.filteredFiles++
+	if size > 0 {
+		s.filtered += size
+	}
+}
+
+// GetFiltered returns the bytes and number of the files counted with
+// AddFiltered
+func (s *StatsInfo) GetFiltered() (bytes, files int64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.filtered, s.filteredFiles
+}
+
+// BytesRetried removes bytes transferred by a failed attempt at a
+// transfer from the bytes transferred and counts them as retried
+// instead, so each byte delivered is only counted once.
+func (s *StatsInfo) BytesRetried(bytes int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.AddInt64(&s.bytes, -bytes)
+	s.retried += bytes
+}
+
+// Errors updates the stats for errors.  They are counted as
+// retryable errors.
 func (s *StatsInfo) Errors(errors int64) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.errors += errors
+	s.retryErrors += errors
+}
+
+// GetRetryErrors returns the number of errors which retrying the
+// sync could fix.  These are the errors which aren't fatal or no
+// retry errors.
+func (s *StatsInfo) GetRetryErrors() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.retryErrors
+}
+
+// GetFatalErrors returns the number of fatal and no retry errors,
+// which retrying the sync won't fix.
+//
+// GetRetryErrors and GetFatalErrors add up to GetErrors.
+func (s *StatsInfo) GetFatalErrors() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.fatalErrors
+}
+
+// LowLevelRetry counts a low level retry, that is an operation which
+// failed and was tried again straight away.  These aren't errors
+// unless the last try fails too.
+func (s *StatsInfo) LowLevelRetry() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lowRetries++
+}
+
+// GetLowLevelRetries returns the number of low level retries counted
+// by LowLevelRetry
+func (s *StatsInfo) GetLowLevelRetries() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lowRetries
+}
+
+// GetHighLevelRetries returns the number of retryable errors which
+// were retried by retrying the sync, as counted by ResetErrors.
+func (s *StatsInfo) GetHighLevelRetries() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.highRetries
 }
 
 // GetErrors reads the number of errors
@@ -126,20 +815,135 @@ func (s *StatsInfo) Deletes(deletes int64) int64 {
 
 // ResetCounters sets the counters (bytes, checks, errors, transfers) to 0
 func (s *StatsInfo) ResetCounters() {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-	s.bytes = 0
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.StoreInt64(&s.bytes, 0)
+	atomic.StoreInt64(&s.uploaded, 0)
+	atomic.StoreInt64(&s.downloaded, 0)
+	atomic.StoreInt64(&s.checked, 0)
+	s._resetCounters()
+}
+
+// _resetCounters resets the counters ResetCounters does apart from
+// the atomic ones - call with lock held for writing
+func (s *StatsInfo) _resetCounters() {
 	s.errors = 0
+	s.retryErrors = 0
+	s.fatalErrors = 0
+	s.lowRetries = 0
+	s.highRetries = 0
 	s.checks = 0
 	s.transfers = 0
 	s.deletes = 0
+	s.totalKnown = false
+	s.totalBytes = 0
+	s.totalFiles = 0
+	s.totalBase = 0
+	s.filesBase = 0
+	s.retried = 0
+	s.serverSide = 0
+	s.durations = newHistogram(durationBounds)
+	s.speeds = newHistogram(speedBounds)
+	s.failed = 0
+	s.firstBytes = newHistogram(firstByteBounds)
+	s.recent.reset()
+	s.peakShort = 0
+	s.peakLong = 0
+	s.small.reset()
+	s.skipped = 0
+	s.skippedFiles = 0
+	s.filtered = 0
+	s.filteredFiles = 0
+}
+
+// StatsSnapshot is a record of the totals of a StatsInfo as returned
+// by Reset
+type StatsSnapshot struct {
+	Bytes        int64         // bytes transferred
+	Uploaded     int64         // bytes written to remotes
+	Downloaded   int64         // bytes read from remotes
+	Checked      int64         // bytes read for checks
+	ServerSide   int64         // bytes copied server side
+	RetriedBytes int64         // bytes discarded by transfers which were retried
+	Errors       int64         // number of errors
+	RetryErrors  int64         // errors which retrying could fix
+	FatalErrors  int64         // fatal and no retry errors
+	LowRetries   int64         // low level retries done
+	HighRetries  int64         // errors retried by retrying the sync
+	LastError    error         // last error or nil
+	Checks       int64         // number of checks done
+	Transfers    int64         // number of transfers done
+	Failed       int64         // number of transfers which failed
+	Deletes      int64         // number of deletes done
+	Start        time.Time     // time the stats were started or last reset
+	Elapsed      time.Duration // time since Start
+
+	// files which didn't need transferring
+	SkippedBytes  int64 // bytes of the files which were already up to date
+	SkippedFiles  int64 // number of files which were already up to date
+	FilteredBytes int64 // bytes of the files excluded by the filters
+	FilteredFiles int64 // number of files excluded by the filters
 }
 
-// ResetErrors sets the errors count to 0
+// Reset sets all the totals to 0 as if the StatsInfo had just been
+// made, returning what they were before.  Use this to report on each
+// run of a long running process.
+//
+// It is safe to call while transfers are in progress.  They carry
+// on as before and the bytes they transfer from now on are counted
+// in the new totals.
+func (s *StatsInfo) Reset() StatsSnapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := clk.Now()
+	// Swap the atomics so no bytes are lost between reading and
+	// resetting them
+	out := StatsSnapshot{
+		Bytes:        atomic.SwapInt64(&s.bytes, 0),
+		Uploaded:     atomic.SwapInt64(&s.uploaded, 0),
+		Downloaded:   atomic.SwapInt64(&s.downloaded, 0),
+		Checked:      atomic.SwapInt64(&s.checked, 0),
+		ServerSide:   s.serverSide,
+		RetriedBytes: s.retried,
+		Errors:       s.errors,
+		RetryErrors:  s.retryErrors,
+		FatalErrors:  s.fatalErrors,
+		LowRetries:   s.lowRetries,
+		HighRetries:  s.highRetries,
+		LastError:    s.lastError,
+		Checks:       s.checks,
+		Transfers:    s.transfers,
+		Failed:       s.failed,
+		Deletes:      s.deletes,
+		Start:        s.start,
+		Elapsed:      now.Sub(s.start),
+
+		SkippedBytes:  s.skipped,
+		SkippedFiles:  s.skippedFiles,
+		FilteredBytes: s.filtered,
+		FilteredFiles: s.filteredFiles,
+	}
+	s._resetCounters()
+	s.lastError = nil
+	s.start = now
+	return out
+}
+
+// ResetGlobalStats resets the totals of the global Stats returning
+// what they were before - see StatsInfo.Reset.
+func ResetGlobalStats() StatsSnapshot {
+	return Stats.Reset()
+}
+
+// ResetErrors sets the errors counts to 0.  Call this before retrying
+// the sync - the retryable errors are added to the high level retries.
 func (s *StatsInfo) ResetErrors() {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.highRetries += s.retryErrors
 	s.errors = 0
+	s.retryErrors = 0
+	s.fatalErrors = 0
 }
 
 // Errored returns whether there have been any errors
@@ -154,6 +958,11 @@ func (s *StatsInfo) Error(err error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.errors++
+	if fserrors.IsFatalError(err) || fserrors.IsNoRetryError(err) {
+		s.fatalErrors++
+	} else {
+		s.retryErrors++
+	}
 	s.lastError = err
 }
 
@@ -161,14 +970,14 @@ func (s *StatsInfo) Error(err error) {
 func (s *StatsInfo) Checking(remote string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.checking[remote] = struct{}{}
+	s.checking.add(remote)
 }
 
 // DoneChecking removes a check from the stats
 func (s *StatsInfo) DoneChecking(remote string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	delete(s.checking, remote)
+	s.checking.remove(remote)
 	s.checks++
 }
 
@@ -183,7 +992,7 @@ func (s *StatsInfo) GetTransfers() int64 {
 func (s *StatsInfo) Transferring(remote string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.transferring[remote] = struct{}{}
+	s.transferring.add(remote)
 }
 
 // DoneTransferring removes a transfer from the stats
@@ -192,7 +1001,7 @@ func (s *StatsInfo) Transferring(remote string) {
 func (s *StatsInfo) DoneTransferring(remote string, ok bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	delete(s.transferring, remote)
+	s.transferring.remove(remote)
 	if ok {
 		s.transfers++
 	}
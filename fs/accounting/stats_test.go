@@ -0,0 +1,735 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fs/fserrors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsETA(t *testing.T) {
+	oldTransfers := fs.Config.Transfers
+	defer func() { fs.Config.Transfers = oldTransfers }()
+	fs.Config.Transfers = 1
+	s := NewStats()
+
+	// Not known until the totals are set
+	_, ok := s._eta()
+	assert.False(t, ok)
+	assert.Contains(t, s.String(), "ETA:                    -\n")
+
+	s.SetTotalBytes(1000)
+	s.SetTotalTransfers(2)
+
+	// No speed yet
+	_, ok = s._eta()
+	assert.False(t, ok)
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1}))
+	acc := NewAccountSizeName(in, 1000, "test-eta")
+	s.inProgress.set(acc)
+	_, err := acc.Read(make([]byte, 1))
+	require.NoError(t, err)
+	acc.avg.Set(100)
+	s.Bytes(500)
+
+	eta, ok := s._eta()
+	require.True(t, ok)
+	assert.Equal(t, 5*time.Second, eta)
+	assert.Contains(t, s.String(), "ETA:                   5s\n")
+	assert.Contains(t, s.String(), "Transferred:            0 / 2\n")
+
+	// Coping with the total growing
+	s.SetTotalBytes(2000)
+	eta, ok = s._eta()
+	require.True(t, ok)
+	assert.Equal(t, 15*time.Second, eta)
+
+	// Both files left can run at once
+	fs.Config.Transfers = 4
+	eta, ok = s._eta()
+	require.True(t, ok)
+	assert.Equal(t, 7*time.Second, eta)
+
+	// Bytes copied server side are done too
+	s.ServerSideBytes(1000)
+	eta, ok = s._eta()
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Second, eta)
+
+	// All done
+	s.Bytes(500)
+	eta, ok = s._eta()
+	require.True(t, ok)
+	assert.Equal(t, time.Duration(0), eta)
+
+	// A retry sets the totals of what is left
+	s.DoneTransferring("test-eta", true)
+	s.ResetTotals()
+	_, ok = s._eta()
+	assert.False(t, ok)
+	s.SetTotalBytes(1000)
+	s.SetTotalTransfers(1)
+	assert.Contains(t, s.String(), "Transferred:            1 / 2\n")
+	eta, ok = s._eta()
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Second, eta)
+
+	assert.NoError(t, acc.Close())
+}
+
+func TestStatsOneLine(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	oldOneLine, oldDate := fs.Config.StatsOneLine, fs.Config.StatsOneLineDate
+	defer func() {
+		fs.Config.StatsOneLine, fs.Config.StatsOneLineDate = oldOneLine, oldDate
+	}()
+	s := NewStats()
+	s.Bytes(1024 * 1024)
+	s.Error(errors.New("potato"))
+	s.checks = 12
+	s.transfers = 3
+	c.advance(2 * time.Second)
+
+	// Total not known
+	assert.Equal(t, "1M / -, -, 512 kB/s, ETA -, errors 1, checks 12, transfers 3", s.OneLine())
+
+	s.SetTotalBytes(4 * 1024 * 1024)
+	assert.Equal(t, "1M / 4M, 25%, 512 kB/s, ETA -, errors 1, checks 12, transfers 3", s.OneLine())
+
+	// String uses it with --stats-one-line
+	assert.Contains(t, s.String(), "Transferred:")
+	fs.Config.StatsOneLine = true
+	assert.Equal(t, s.OneLine(), s.String())
+	assert.Contains(t, s.Full(), "Transferred:")
+
+	fs.Config.StatsOneLineDate = true
+	assert.Equal(t, "2100-01-01T00:00:02Z 1M / 4M, 25%, 512 kB/s, ETA -, errors 1, checks 12, transfers 3", s.OneLine())
+
+	// The rate is the same as in the full stats
+	oldUnit := fs.Config.DataRateUnit
+	defer func() { fs.Config.DataRateUnit = oldUnit }()
+	fs.Config.DataRateUnit = "bits"
+	assert.Contains(t, s.OneLine(), ", "+FormatRate(512*1024)+", ")
+	assert.Contains(t, FormatRate(512*1024), "b/s")
+}
+
+func TestPercentDone(t *testing.T) {
+	assert.Equal(t, int64(0), percentDone(0, 100))
+	assert.Equal(t, int64(24), percentDone(249, 1000))
+	assert.Equal(t, int64(100), percentDone(1000, 1000))
+	assert.Equal(t, int64(100), percentDone(2000, 1000))
+}
+
+func TestJobETA(t *testing.T) {
+	for _, test := range []struct {
+		bytesLeft int64
+		filesLeft int64
+		transfers int
+		speed     float64
+		want      time.Duration
+	}{
+		{1000, 1, 4, 100, 10 * time.Second},
+		{1000, 4, 4, 100, 2 * time.Second},
+		{1000, 5, 4, 100, 4 * time.Second},
+		{1000, 10, 1, 100, 10 * time.Second},
+		{1000, 10, 0, 100, 10 * time.Second},
+		{1000, 100, 10, 10, 10 * time.Second},
+	} {
+		got := jobETA(test.bytesLeft, test.filesLeft, test.transfers, test.speed)
+		assert.Equal(t, test.want, got, "%+v", test)
+	}
+}
+
+func TestStatsBytesRetried(t *testing.T) {
+	s := NewStats()
+	s.Bytes(100)
+	assert.NotContains(t, s.String(), "Retried:")
+
+	s.BytesRetried(40)
+	assert.Equal(t, int64(60), s.bytes)
+	assert.Equal(t, int64(40), s.retried)
+	assert.Contains(t, s.String(), "Retried:")
+
+	s.ResetCounters()
+	assert.Equal(t, int64(0), s.retried)
+}
+
+func TestStatsOnTransferComplete(t *testing.T) {
+	var got1, got2 []TransferSnapshot
+	remove1 := Stats.OnTransferComplete(func(s TransferSnapshot) {
+		got1 = append(got1, s)
+	})
+	remove2 := Stats.OnTransferComplete(func(s TransferSnapshot) {
+		// Mustn't be called with the locks held
+		_ = Stats.String()
+		got2 = append(got2, s)
+	})
+
+	in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc := NewAccountSizeName(in, 3, "complete-ok")
+	_, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	require.NoError(t, acc.Close())
+	require.NoError(t, acc.Close())
+
+	remove2()
+	in = ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	acc = NewAccountSizeName(in, 3, "complete-failed")
+	_, err = acc.Read(make([]byte, 1))
+	require.NoError(t, err)
+	failed := errors.New("failed")
+	acc.Finish(failed)
+	require.NoError(t, acc.Close())
+	remove1()
+
+	require.Equal(t, 2, len(got1))
+	assert.Equal(t, "complete-ok", got1[0].Name)
+	assert.Equal(t, int64(3), got1[0].Size)
+	assert.Equal(t, int64(3), got1[0].Bytes)
+	assert.False(t, got1[0].Start.IsZero())
+	assert.NoError(t, got1[0].Error)
+	assert.Equal(t, "complete-failed", got1[1].Name)
+	assert.Equal(t, int64(1), got1[1].Bytes)
+	assert.Equal(t, failed, got1[1].Error)
+
+	require.Equal(t, 1, len(got2))
+	assert.Equal(t, got1[0], got2[0])
+}
+
+func TestStatsAbortTransfer(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	acc := NewAccountSizeNameContext(ctx, r, 100, "test")
+
+	// A Read blocked in the stream is unblocked
+	errs := make(chan error)
+	go func() {
+		_, err := acc.Read(make([]byte, 10))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.AbortTransfer("test"))
+	assert.Equal(t, ErrorTransferAborted, <-errs)
+	_, err := acc.Read(make([]byte, 10))
+	assert.Equal(t, ErrorTransferAborted, err)
+	assert.NoError(t, acc.Close())
+
+	// It is no longer in progress
+	assert.Error(t, s.AbortTransfer("test"))
+}
+
+func TestStatsSameName(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	newAcc := func(remote string) *Account {
+		s.Transferring("a/x.txt")
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+		return NewAccountSizeNameContext(ctx, in, 10, "a/x.txt").WithRemoteName(remote)
+	}
+	acc1, acc2 := newAcc("remote1"), newAcc("remote2")
+	assert.NotEqual(t, acc1.ID(), acc2.ID())
+	assert.Equal(t, 2, s.inProgress.count())
+
+	// Both are shown prefixed with the remote
+	out := s.String()
+	assert.Contains(t, out, " * remote1:a/x.txt:  0% /10, 0 B/s, -\n")
+	assert.Contains(t, out, " * remote2:a/x.txt:  0% /10, 0 B/s, -\n")
+
+	// The name is ambiguous so nothing is aborted
+	_, err := s.TransferDone("a/x.txt")
+	assert.Equal(t, ErrorTransferAmbiguous, errors.Cause(err))
+	err = s.AbortTransfer("a/x.txt")
+	assert.Equal(t, ErrorTransferAmbiguous, errors.Cause(err))
+	assert.Contains(t, err.Error(), acc1.ID()+", "+acc2.ID())
+	assert.NoError(t, acc1.Err())
+	assert.NoError(t, acc2.Err())
+
+	// Finishing the first by ID leaves the second
+	done, err := s.TransferDone(acc1.ID())
+	require.NoError(t, err)
+	require.NoError(t, acc1.Close())
+	s.DoneTransferring("a/x.txt", true)
+	select {
+	case <-done:
+	default:
+		t.Error("transfer not done")
+	}
+	assert.True(t, s.inProgress.get("a/x.txt") == acc2)
+	assert.Contains(t, s.String(), " * a/x.txt:  0% /10, 0 B/s, -\n")
+
+	// The name prefixed with the remote finds it too
+	_, err = s.TransferDone("remote1:a/x.txt")
+	assert.Error(t, err)
+	require.NoError(t, s.AbortTransfer("remote2:a/x.txt"))
+	assert.Equal(t, ErrorTransferAborted, acc2.Err())
+	assert.NoError(t, acc2.Close())
+	s.DoneTransferring("a/x.txt", false)
+	assert.Equal(t, 0, len(s.transferring))
+}
+
+func TestStatsPauseAll(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	newAcc := func(name string) *Account {
+		in := ioutil.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+		return NewAccountSizeNameContext(ctx, in, 3, name)
+	}
+	acc1 := newAcc("test1")
+	assert.False(t, s.IsPaused())
+	assert.NotContains(t, s.String(), "Paused:")
+
+	s.PauseAll()
+	assert.True(t, s.IsPaused())
+	assert.True(t, acc1.IsPaused())
+	assert.Contains(t, s.String(), "Paused:")
+
+	// New transfers start paused
+	acc2 := newAcc("test2")
+	assert.True(t, acc2.IsPaused())
+
+	s.ResumeAll()
+	assert.False(t, s.IsPaused())
+	assert.False(t, acc1.IsPaused())
+	assert.False(t, acc2.IsPaused())
+	acc3 := newAcc("test3")
+	assert.False(t, acc3.IsPaused())
+
+	for _, acc := range []*Account{acc1, acc2, acc3} {
+		_, err := acc.Read(make([]byte, 3))
+		assert.NoError(t, err)
+		assert.NoError(t, acc.Close())
+	}
+}
+
+func TestStatsDirection(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	read := func(dir Direction) {
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+		acc := NewAccountSizeNameContext(ctx, in, 10, "test").WithDirection(dir)
+		_, err := ioutil.ReadAll(acc)
+		require.NoError(t, err)
+		require.NoError(t, acc.Close())
+	}
+	read(Upload)
+	read(Download)
+	read(Download)
+	read(Upload | Download)
+	read(0)
+	assert.Equal(t, int64(50), s.bytes)
+	assert.Equal(t, int64(20), s.uploaded)
+	assert.Equal(t, int64(30), s.downloaded)
+	assert.Contains(t, s.String(), "Uploaded:")
+	assert.NotContains(t, s.String(), "Server side:")
+
+	s.ServerSideBytes(100)
+	assert.Equal(t, int64(50), s.bytes)
+	assert.Contains(t, s.String(), "Server side:")
+
+	// Retries don't reduce the bytes transferred each way
+	s.BytesRetried(10)
+	assert.Equal(t, int64(20), s.uploaded)
+
+	s.ResetCounters()
+	assert.Equal(t, int64(0), s.uploaded)
+	assert.Equal(t, int64(0), s.downloaded)
+	assert.Equal(t, int64(0), s.serverSide)
+}
+
+// namedInfo is an fs.Info with a name
+type namedInfo struct {
+	fs.Info
+	name string
+}
+
+func (f namedInfo) Name() string { return f.name }
+
+func TestTransferDirection(t *testing.T) {
+	local, remote := namedInfo{name: "local"}, namedInfo{name: "s3"}
+	assert.Equal(t, Upload, TransferDirection(local, remote))
+	assert.Equal(t, Download, TransferDirection(remote, local))
+	assert.Equal(t, Upload|Download, TransferDirection(remote, remote))
+	assert.Equal(t, Direction(0), TransferDirection(local, local))
+	assert.Equal(t, Download, TransferDirection(remote, nil))
+	assert.Equal(t, Upload, TransferDirection(nil, remote))
+}
+
+func TestStatsTransferDistribution(t *testing.T) {
+	ctx := WithStats(context.Background(), NewStats())
+	s := StatsFromContext(ctx)
+	assert.NotContains(t, s.String(), "Durations:")
+
+	for i, size := range []int{10, 20, 30} {
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, size)))
+		acc := NewAccountSizeNameContext(ctx, in, int64(size), "file")
+		_, err := ioutil.ReadAll(acc)
+		require.NoError(t, err)
+		if i == 2 {
+			acc.Finish(errors.New("failed"))
+		}
+		require.NoError(t, acc.Close())
+	}
+
+	// Files which never started count as well as failed
+	in := ioutil.NopCloser(bytes.NewBuffer(nil))
+	acc := NewAccountSizeNameContext(ctx, in, 0, "unstarted")
+	acc.Finish(errors.New("failed"))
+	require.NoError(t, acc.Close())
+
+	s.lock.RLock()
+	assert.Equal(t, int64(2), s.durations.count)
+	assert.Equal(t, int64(2), s.speeds.count)
+	assert.Equal(t, int64(2), s.failed)
+	s.lock.RUnlock()
+
+	out := s.String()
+	assert.Contains(t, out, "Durations:")
+	assert.Contains(t, out, "Speeds:")
+	assert.Contains(t, out, "Failed:                 2\n")
+
+	s.ResetCounters()
+	assert.NotContains(t, s.String(), "Durations:")
+}
+
+func TestFormatRate(t *testing.T) {
+	oldUnit := fs.Config.DataRateUnit
+	defer func() { fs.Config.DataRateUnit = oldUnit }()
+	for _, test := range []struct {
+		unit string
+		bps  float64
+		want string
+	}{
+		{"bytes", 0, "0 B/s"},
+		{"bytes", 0.5, "1 B/s"},
+		{"bytes", 1000, "1000 B/s"},
+		{"bytes", 1536, "1.500 kB/s"},
+		{"bytes", 1023.6, "1 kB/s"},
+		{"bits", 0, "0 b/s"},
+		{"bits", 0.5, "4 b/s"},
+		{"bits", 128, "1 kb/s"},
+		{"bits", 1023.9, "7.999 kb/s"},
+		{"bits", 1.5 * 1024 * 1024, "12 Mb/s"},
+	} {
+		fs.Config.DataRateUnit = test.unit
+		assert.Equal(t, test.want, FormatRate(test.bps), "%+v", test)
+	}
+
+	// Used in the stats
+	fs.Config.DataRateUnit = "bits"
+	s := NewStats()
+	assert.Contains(t, s.String(), " b/s)\n")
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 1, "test")
+	assert.Contains(t, acc.String(), "0 b/s")
+	require.NoError(t, acc.Close())
+}
+
+func TestFormatSizeUnit(t *testing.T) {
+	oldRateUnit, oldSizeUnit := fs.Config.DataRateUnit, fs.Config.SizeUnit
+	defer func() { fs.Config.DataRateUnit, fs.Config.SizeUnit = oldRateUnit, oldSizeUnit }()
+	for _, test := range []struct {
+		rateUnit string
+		sizeUnit string
+		bps      float64
+		wantRate string
+		wantSize string
+	}{
+		{"bytes", "binary", 1536, "1.500 kB/s", "1.500 kBytes"},
+		{"bytes", "si", 1536, "1.536 kB/s", "1.536 kBytes"},
+		{"bytes", "si", 1000 * 1000, "1 MB/s", "1 MBytes"},
+		{"bits", "binary", 1024 * 1024 / 8, "1 Mb/s", "128 kBytes"},
+		{"bits", "si", 1000 * 1000 / 8, "1 Mb/s", "125 kBytes"},
+	} {
+		fs.Config.DataRateUnit, fs.Config.SizeUnit = test.rateUnit, test.sizeUnit
+		assert.Equal(t, test.wantRate, FormatRate(test.bps), "%+v", test)
+		assert.Equal(t, test.wantSize, FormatSize(int64(test.bps)), "%+v", test)
+	}
+
+	// Used in the stats
+	fs.Config.DataRateUnit, fs.Config.SizeUnit = "bytes", "si"
+	s := NewStats()
+	s.Bytes(1500)
+	assert.Contains(t, s.String(), "Transferred:   1.500 kBytes (")
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 2000, "test")
+	assert.Contains(t, acc.String(), " 0% /2k,")
+	require.NoError(t, acc.Close())
+}
+
+func TestStatsMaxTransfer(t *testing.T) {
+	oldMax, oldMode := fs.Config.MaxTransfer, fs.Config.MaxTransferMode
+	defer func() { fs.Config.MaxTransfer, fs.Config.MaxTransferMode = oldMax, oldMode }()
+	fs.Config.MaxTransfer = 4
+
+	for _, mode := range []string{"hard", "soft"} {
+		fs.Config.MaxTransferMode = mode
+		s := NewStats()
+		ctx := WithStats(context.Background(), s)
+		acc1 := NewAccountSizeNameContext(ctx, ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10))), 10, "started")
+		acc2 := NewAccountSizeNameContext(ctx, ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10))), 10, "new")
+
+		assert.Contains(t, s.String(), "Max transfer:     4 Bytes left of 4 Bytes\n")
+		n, err := acc1.Read(make([]byte, 3))
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Contains(t, s.String(), "Max transfer:     1 Bytes left of 4 Bytes\n")
+		n, err = acc1.Read(make([]byte, 3))
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		// New transfers can't start in either mode
+		_, err = acc2.Read(make([]byte, 1))
+		assert.Equal(t, ErrorMaxTransferLimitReached, err, mode)
+		assert.True(t, fserrors.IsFatalError(err))
+
+		// Transfers in progress can finish in soft mode, even
+		// if they are retried
+		_, err = acc1.Read(make([]byte, 1))
+		if mode == "soft" {
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, ErrorMaxTransferLimitReached, err)
+		}
+		acc1.ResetStats()
+		_, err = acc1.Read(make([]byte, 1))
+		if mode == "soft" {
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, ErrorMaxTransferLimitReached, err)
+		}
+
+		require.NoError(t, acc1.Close())
+		require.NoError(t, acc2.Close())
+	}
+}
+
+// zeroReader is an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// readParallel reads n chunks of size from each of goroutines Accounts
+// accounted in s at once
+func readParallel(s *StatsInfo, goroutines, n, size int) {
+	ctx := WithStats(context.Background(), s)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		acc := NewAccountSizeNameContext(ctx, ioutil.NopCloser(zeroReader{}), -1, fmt.Sprintf("parallel-%d", g))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, size)
+			for i := 0; i < n; i++ {
+				_, _ = acc.Read(buf)
+			}
+			_ = acc.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStatsBytesParallel(t *testing.T) {
+	s := NewStats()
+	readParallel(s, 16, 1000, 7)
+	assert.Equal(t, int64(16*1000*7), s.GetBytes())
+	s.lock.RLock()
+	assert.Equal(t, int64(0), s.uploaded)
+	s.lock.RUnlock()
+}
+
+// BenchmarkStatsBytesParallel measures the contention from 64
+// transfers doing small reads at once
+func BenchmarkStatsBytesParallel(b *testing.B) {
+	s := NewStats()
+	b.ResetTimer()
+	readParallel(s, 64, b.N, 64)
+	b.StopTimer()
+	if got, want := s.GetBytes(), int64(64*b.N*64); got != want {
+		b.Fatalf("want %d bytes got %d", want, got)
+	}
+}
+
+func TestStatsPeakSpeeds(t *testing.T) {
+	s := NewStats()
+	for i := 0; i < 9; i++ {
+		s.addSpeedSample(100, time.Second)
+	}
+	short, long := s.PeakSpeeds()
+	assert.Equal(t, 100.0, short)
+	assert.Equal(t, 0.0, long)
+	s.addSpeedSample(1000, time.Second)
+	s.addSpeedSample(100, time.Second)
+	short, long = s.PeakSpeeds()
+	assert.Equal(t, 1000.0, short)
+	assert.Equal(t, 190.0, long)
+	assert.Contains(t, s.String(), "Peak speed:      1000 B/s (1s), 190 B/s (10s)\n")
+	out, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"peakSpeed1s":1000,"peakSpeed10s":190`)
+
+	// With a shorter tick the peak is averaged over more ticks
+	s.ResetCounters()
+	for _, speed := range []float64{3000, 1000, 1000, 1000} {
+		s.addSpeedSample(speed, 250*time.Millisecond)
+	}
+	short, _ = s.PeakSpeeds()
+	assert.Equal(t, 1500.0, short)
+
+	s.ResetCounters()
+	short, long = s.PeakSpeeds()
+	assert.Equal(t, 0.0, short)
+	assert.Equal(t, 0.0, long)
+	assert.NotContains(t, s.String(), "Peak speed:")
+}
+
+func TestAddSpeedSamples(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	var accs []averageTicker
+	for _, speed := range []float64{100, 200, 400} {
+		acc := NewAccountSizeNameContext(ctx, ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "test")
+		acc.statmu.Lock()
+		acc.lpSpeed = speed
+		acc.statmu.Unlock()
+		accs = append(accs, acc)
+		defer func() { _ = acc.Close() }()
+	}
+	accs[2].(*Account).Pause()
+	addSpeedSamples(accs, time.Second)
+	short, _ := s.PeakSpeeds()
+	assert.Equal(t, 300.0, short)
+}
+
+func TestStatsReset(t *testing.T) {
+	s := NewStats()
+	ctx := WithStats(context.Background(), s)
+	start := s.start
+	s.Bytes(100)
+	s.Error(errors.New("potato"))
+	s.Checking("checked")
+	s.DoneChecking("checked")
+
+	// A transfer in progress over the reset
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 10)))
+	acc := NewAccountSizeNameContext(ctx, in, 10, "in-progress")
+	_, err := acc.Read(make([]byte, 4))
+	require.NoError(t, err)
+
+	before := s.Reset()
+	assert.Equal(t, int64(104), before.Bytes)
+	assert.Equal(t, int64(1), before.Errors)
+	assert.EqualError(t, before.LastError, "potato")
+	assert.Equal(t, int64(1), before.Checks)
+	assert.Equal(t, start, before.Start)
+	assert.True(t, before.Elapsed > 0)
+
+	assert.Equal(t, int64(0), s.GetBytes())
+	assert.Equal(t, int64(0), s.GetErrors())
+	assert.NoError(t, s.GetLastError())
+	assert.True(t, s.start.After(start))
+
+	// The transfer carries on and is counted in the new totals
+	assert.Equal(t, int64(4), acc.Snapshot().Bytes)
+	_, err = ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	require.NoError(t, acc.Close())
+	s.DoneTransferring("in-progress", true)
+	after := s.Reset()
+	assert.Equal(t, int64(6), after.Bytes)
+	assert.Equal(t, int64(1), after.Transfers)
+	assert.Equal(t, int64(0), after.Errors)
+}
+
+func TestStatsSkipped(t *testing.T) {
+	s := NewStats()
+	assert.NotContains(t, s.String(), "Up to date:")
+	assert.NotContains(t, s.String(), "Filtered:")
+
+	s.AddSkipped(1000)
+	s.AddSkipped(-1)
+	s.AddFiltered(500)
+	bytes, files := s.GetSkipped()
+	assert.Equal(t, int64(1000), bytes)
+	assert.Equal(t, int64(2), files)
+	bytes, files = s.GetFiltered()
+	assert.Equal(t, int64(500), bytes)
+	assert.Equal(t, int64(1), files)
+	assert.Contains(t, s.String(), "Up to date:    1000 Bytes (2 files)\n")
+	assert.Contains(t, s.String(), "Filtered:       500 Bytes (1 files)\n")
+
+	out, err := json.Marshal(s)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, 1000.0, decoded["skippedBytes"])
+	assert.Equal(t, 2.0, decoded["skippedFiles"])
+	assert.Equal(t, 500.0, decoded["filteredBytes"])
+	assert.Equal(t, 1.0, decoded["filteredFiles"])
+
+	snapshot := s.Reset()
+	assert.Equal(t, int64(1000), snapshot.SkippedBytes)
+	assert.Equal(t, int64(2), snapshot.SkippedFiles)
+	assert.Equal(t, int64(500), snapshot.FilteredBytes)
+	assert.Equal(t, int64(1), snapshot.FilteredFiles)
+	_, files = s.GetSkipped()
+	assert.Equal(t, int64(0), files)
+	_, files = s.GetFiltered()
+	assert.Equal(t, int64(0), files)
+}
+
+func TestStatsErrorKinds(t *testing.T) {
+	s := NewStats()
+	assert.NotContains(t, s.String(), "retryable")
+	assert.NotContains(t, s.String(), "Retries:")
+
+	s.LowLevelRetry()
+	s.LowLevelRetry()
+	s.Error(errors.New("retryable"))
+	s.Error(fserrors.RetryErrorf("retry me"))
+	s.Error(fserrors.FatalError(errors.New("fatal")))
+	s.Error(fserrors.NoRetryError(errors.New("no retry")))
+	assert.Equal(t, int64(4), s.GetErrors())
+	assert.Equal(t, int64(2), s.GetRetryErrors())
+	assert.Equal(t, int64(2), s.GetFatalErrors())
+	assert.Equal(t, int64(2), s.GetLowLevelRetries())
+	assert.Contains(t, s.String(), "Errors:                 4 (retryable 2, fatal 2)\n")
+	assert.Contains(t, s.String(), "Retries:                2 (low level), 0 (high level)\n")
+
+	// Retrying the sync counts the retryable errors as retried
+	s.ResetErrors()
+	assert.Equal(t, int64(0), s.GetErrors())
+	assert.Equal(t, int64(0), s.GetRetryErrors())
+	assert.Equal(t, int64(0), s.GetFatalErrors())
+	assert.Equal(t, int64(2), s.GetHighLevelRetries())
+	assert.Equal(t, int64(2), s.GetLowLevelRetries())
+
+	out, err := json.Marshal(s)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, 2.0, decoded["lowLevelRetries"])
+	assert.Equal(t, 2.0, decoded["highLevelRetries"])
+	assert.Equal(t, 0.0, decoded["fatalErrors"])
+
+	s.ResetCounters()
+	assert.Equal(t, int64(0), s.GetHighLevelRetries())
+	assert.Equal(t, int64(0), s.GetLowLevelRetries())
+}
@@ -1,31 +1,107 @@
 package accounting
 
 import (
-	"sort"
 	"strings"
+
+	"github.com/mattn/go-runewidth"
 )
 
-// stringSet holds a set of strings
-type stringSet map[string]struct{}
+// stringSet holds a set of strings counting how many times each has
+// been added, so the name of more than one transfer stays in the set
+// until all of them are done
+type stringSet map[string]int
+
+// add adds name to the set
+func (ss stringSet) add(name string) {
+	ss[name]++
+}
+
+// remove removes one of the name added to the set
+func (ss stringSet) remove(name string) {
+	if ss[name] <= 1 {
+		delete(ss, name)
+	} else {
+		ss[name]--
+	}
+}
+
+// Strings returns all the strings in the stringSet, using the stats
+// of the transfers in ip where possible.
+//
+// The names of the transfers are right aligned to the widest of them.
+func (ss stringSet) Strings(ip *inProgress) []string {
+	strings, _ := ss.strings(ip, false)
+	return strings
+}
+
+// stringsHideSmall is like Strings but leaves out the transfers of
+// files smaller than --stats-small-files, returning how many were
+// left out.
+func (ss stringSet) stringsHideSmall(ip *inProgress) (strings []string, small int) {
+	return ss.strings(ip, true)
+}
 
-// Strings returns all the strings in the stringSet
-func (ss stringSet) Strings() []string {
-	strings := make([]string, 0, len(ss))
-	for name := range ss {
-		var out string
-		if acc := Stats.inProgress.get(name); acc != nil {
-			out = acc.String()
+// strings does the work for Strings and stringsHideSmall
+//
+// The transfers are in the order set with --stats-sort - see
+// sortSnapshots.  Names in the set without a transfer in ip, eg ones
+// which haven't been opened yet, are shown without stats.
+func (ss stringSet) strings(ip *inProgress, hideSmall bool) (strings []string, small int) {
+	var inSet []*Account
+	for _, acc := range ip.accounts() {
+		if _, ok := ss[acc.first]; ok {
+			inSet = append(inSet, acc)
+		}
+	}
+	names := statsNames(inSet)
+	accs := make(map[string]*Account, len(inSet))
+	snapshots := ss.placeholders(inSet)
+	width := 0
+	for _, acc := range inSet {
+		snapshot := acc.Snapshot()
+		if hideSmall && acc.isSmall() {
+			small++
+			continue
+		}
+		if w := runewidth.StringWidth(names[acc]); w > width {
+			width = w
+		}
+		accs[snapshot.ID] = acc
+		snapshots = append(snapshots, snapshot)
+	}
+	sortSnapshots(snapshots)
+	strings = make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if acc := accs[snapshot.ID]; acc != nil {
+			strings = append(strings, " * "+acc.stringWidth(names[acc], width))
 		} else {
-			out = name
+			strings = append(strings, " * "+snapshot.Name)
+		}
+	}
+	return strings, small
+}
+
+// placeholders returns snapshots without stats of the names in ss
+// which don't have a transfer in accs, eg as it hasn't been opened
+// yet, one for each time the name was added.
+//
+// The Accounts are matched by the name they were made with, which is
+// the name added to the set, so they still match after SetName.
+func (ss stringSet) placeholders(accs []*Account) (snapshots []AccountSnapshot) {
+	found := make(map[string]int, len(accs))
+	for _, acc := range accs {
+		found[acc.first]++
+	}
+	for name, n := range ss {
+		for i := found[name]; i < n; i++ {
+			snapshots = append(snapshots, AccountSnapshot{Name: name, Size: -1})
 		}
-		strings = append(strings, " * "+out)
 	}
-	sorted := sort.StringSlice(strings)
-	sorted.Sort()
-	return sorted
+	return snapshots
 }
 
-// String returns all the file names in the stringSet joined by newline
-func (ss stringSet) String() string {
-	return strings.Join(ss.Strings(), "\n")
+// String returns all the file names in the stringSet joined by
+// newline, using the stats of the transfers in ip where possible
+func (ss stringSet) String(ip *inProgress) string {
+	return strings.Join(ss.Strings(ip), "\n")
 }
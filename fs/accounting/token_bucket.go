@@ -2,7 +2,9 @@ package accounting
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ncw/rclone/fs"
@@ -19,37 +21,139 @@ var (
 	bwLimitToggledOff = false
 	currLimitMu       sync.Mutex // protects changes to the timeslot
 	currLimit         fs.BwTimeSlot
+	limitedBytes      int64 // bytes passed through limitBandwidth - use atomic
+	remoteBuckets     = map[string]*remoteBucket{}
 )
 
-const maxBurstSize = 1 * 1024 * 1024 // must be bigger than the biggest request
+// remoteBucket is the bandwidth limit of the transfers to one remote
+// set with --bwlimit-remote
+type remoteBucket struct {
+	limiter *rate.Limiter // unlimited if the rate is rate.Inf
+	bytes   int64         // bytes limited by this bucket - use atomic
+}
+
+// Limits of the burst size of the token buckets
+const (
+	minBurstSize = 1 * 1024 * 1024 // must be bigger than the biggest request
+	maxBurstSize = 1 << 30         // so it fits in an int
+)
+
+// Timings for --bwlimit-percent
+const (
+	bwProbeInterval = 10 * time.Minute // time between probes
+	bwProbeDuration = 10 * time.Second // time each probe runs unlimited for
+	bwProbeRetry    = 30 * time.Second // time to the next probe if nothing was measured
+	bwProbeWait     = time.Second      // how often to look for transfers to probe
+)
+
+// burstSize returns the burst size for a token bucket with the
+// bandwidth given.  This is --bwlimit-burst if set or a second's worth
+// of the bandwidth otherwise, but at least minBurstSize.
+//
+// Bursts above the bandwidth are allowed up to this many bytes as long
+// as the average stays at the bandwidth, which lets protocols with an
+// overhead for each request reach the limit.
+func burstSize(bandwidth fs.SizeSuffix) int {
+	burst := int64(fs.Config.BwLimitBurst)
+	if burst <= 0 {
+		burst = int64(bandwidth)
+	}
+	if burst < minBurstSize {
+		burst = minBurstSize
+	}
+	if burst > maxBurstSize {
+		burst = maxBurstSize
+	}
+	return int(burst)
+}
 
 // make a new empty token bucket with the bandwidth given
+//
+// The burst size is set from the bandwidth by burstSize and doesn't
+// change if the limit of the bucket is changed later.
 func newTokenBucket(bandwidth fs.SizeSuffix) *rate.Limiter {
-	newTokenBucket := rate.NewLimiter(rate.Limit(bandwidth), maxBurstSize)
+	burst := burstSize(bandwidth)
+	newTokenBucket := rate.NewLimiter(rate.Limit(bandwidth), burst)
 	// empty the bucket
-	err := newTokenBucket.WaitN(context.Background(), maxBurstSize)
+	err := newTokenBucket.WaitN(context.Background(), burst)
 	if err != nil {
 		fs.Errorf(nil, "Failed to empty token bucket: %v", err)
 	}
 	return newTokenBucket
 }
 
+// waitTokens sleeps until n tokens are available from tb.  They are
+// taken at most the burst size of tb at a time as asking for more
+// than can ever be in the bucket at once fails.
+func waitTokens(tb *rate.Limiter, n int) error {
+	burst := tb.Burst()
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		if err := tb.WaitN(context.Background(), chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
 // StartTokenBucket starts the token bucket if necessary
 func StartTokenBucket() {
 	currLimitMu.Lock()
-	currLimit := fs.Config.BwLimit.LimitAt(time.Now())
+	currLimit = fs.Config.BwLimit.LimitAt(clk.Now())
 	currLimitMu.Unlock()
 
 	if currLimit.Bandwidth > 0 {
 		tokenBucket = newTokenBucket(currLimit.Bandwidth)
 		fs.Infof(nil, "Starting bandwidth limiter at %vBytes/s", &currLimit.Bandwidth)
+	}
 
-		// Start the SIGUSR2 signal handler to toggle bandwidth.
-		// This function does nothing in windows systems.
+	for name, bandwidth := range fs.Config.BwLimitRemote {
+		SetRemoteBandwidthLimit(name, bandwidth)
+		fs.Infof(nil, "Starting bandwidth limiter for %q at %vBytes/s", name, &bandwidth)
+	}
+
+	// Start the SIGUSR2 signal handler to toggle bandwidth if a
+	// limit is set now or may be set later by the timetable or
+	// --bwlimit-percent.
+	// This function does nothing in windows systems.
+	if currLimit.Bandwidth > 0 || len(fs.Config.BwLimit) > 1 || fs.Config.BwLimitPercent > 0 {
 		startSignalHandler()
 	}
 }
 
+// toggleBandwidthLimit toggles the global bandwidth limit between
+// unlimited and the configured limit.
+//
+// When toggling the limit back on and a timetable is in use, the
+// limit is taken from the timetable for the current time rather than
+// the limit which was active when it was toggled off.
+func toggleBandwidthLimit() {
+	currLimitMu.Lock()
+	defer currLimitMu.Unlock()
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+
+	bwLimitToggledOff = !bwLimitToggledOff
+	if !bwLimitToggledOff && len(fs.Config.BwLimit) > 1 {
+		currLimit = fs.Config.BwLimit.LimitAt(clk.Now())
+		prevTokenBucket = nil
+		if currLimit.Bandwidth > 0 {
+			prevTokenBucket = newTokenBucket(currLimit.Bandwidth)
+		}
+	}
+	tokenBucket, prevTokenBucket = prevTokenBucket, tokenBucket
+	if tokenBucket == nil {
+		fs.Logf(nil, "Bandwidth limit disabled by user")
+	} else {
+		bw := fs.SizeSuffix(tokenBucket.Limit())
+		fs.Logf(nil, "Bandwidth limit enabled by user at %vBytes/s", &bw)
+	}
+}
+
 // StartTokenTicker creates a ticker to update the bandwidth limiter every minute.
 func StartTokenTicker() {
 	// If the timetable has a single entry or was not specified, we don't need
@@ -58,10 +162,10 @@ func StartTokenTicker() {
 		return
 	}
 
-	ticker := time.NewTicker(time.Minute)
+	ticker := clk.NewTicker(time.Minute)
 	go func() {
-		for range ticker.C {
-			limitNow := fs.Config.BwLimit.LimitAt(time.Now())
+		for range ticker.Chan() {
+			limitNow := fs.Config.BwLimit.LimitAt(clk.Now())
 			currLimitMu.Lock()
 
 			if currLimit.Bandwidth != limitNow.Bandwidth {
@@ -78,8 +182,14 @@ func StartTokenTicker() {
 				}
 
 				// Set new bandwidth. If unlimited, set tokenbucket to nil.
+				// If there is a bucket already just change its rate so
+				// transfers in progress carry on smoothly.
 				if limitNow.Bandwidth > 0 {
-					*targetBucket = newTokenBucket(limitNow.Bandwidth)
+					if *targetBucket != nil {
+						(*targetBucket).SetLimit(rate.Limit(limitNow.Bandwidth))
+					} else {
+						*targetBucket = newTokenBucket(limitNow.Bandwidth)
+					}
 					if bwLimitToggledOff {
 						fs.Logf(nil, "Scheduled bandwidth change. "+
 							"Limit will be set to %vBytes/s when toggled on again.", &limitNow.Bandwidth)
@@ -99,20 +209,267 @@ func StartTokenTicker() {
 	}()
 }
 
+// StartBandwidthProber starts setting the bandwidth limit to
+// --bwlimit-percent of the measured bandwidth if it is set.
+//
+// Once transfers are in progress the limit is lifted for
+// bwProbeDuration to measure the bandwidth which can be achieved, then
+// the limit is set to the percentage of that until the next probe
+// bwProbeInterval later.  If nothing could be measured the probe is
+// tried again after bwProbeRetry instead.
+func StartBandwidthProber() {
+	percent := fs.Config.BwLimitPercent
+	if percent <= 0 {
+		return
+	}
+	fs.Infof(nil, "Starting bandwidth limiter at %d%% of the measured bandwidth", percent)
+	go func() {
+		for {
+			clk.Sleep(probeOnce(percent))
+		}
+	}()
+}
+
+// probeOnce waits for transfers to be in progress then measures the
+// bandwidth and sets the limit to percent of it, returning how long to
+// wait before the next probe.
+//
+// The measurement only counts if transfers were still in progress at
+// the end of the probe, otherwise a short job finishing would set a
+// tiny limit until the next probe.
+func probeOnce(percent int) (next time.Duration) {
+	for !transfersInProgress() {
+		clk.Sleep(bwProbeWait)
+	}
+	bps := probeBandwidth(bwProbeDuration)
+	if !transfersInProgress() {
+		bps = 0
+	}
+	if !setProbedLimit(percent, bps) {
+		return bwProbeRetry
+	}
+	return bwProbeInterval
+}
+
+// transfersInProgress returns true if any of the transfers in progress
+// are limited by the global bandwidth limit
+func transfersInProgress() bool {
+	for _, acc := range AggregateStats().inProgress.accounts() {
+		if acc.globallyLimited() {
+			return true
+		}
+	}
+	return false
+}
+
+// probeBandwidth lifts the global bandwidth limit for d and returns
+// the bandwidth in bytes/s measured while it was lifted.
+//
+// If the limit has been toggled off by the user it is left alone.
+func probeBandwidth(d time.Duration) (bps float64) {
+	tokenBucketMu.Lock()
+	var saved *rate.Limiter
+	if !bwLimitToggledOff {
+		saved, tokenBucket = tokenBucket, nil
+	}
+	tokenBucketMu.Unlock()
+
+	start := atomic.LoadInt64(&limitedBytes)
+	clk.Sleep(d)
+	n := atomic.LoadInt64(&limitedBytes) - start
+
+	tokenBucketMu.Lock()
+	if !bwLimitToggledOff && tokenBucket == nil {
+		tokenBucket = saved
+	}
+	tokenBucketMu.Unlock()
+	return float64(n) / d.Seconds()
+}
+
+// setProbedLimit sets the global bandwidth limit to percent of the
+// measured bandwidth bps, returning false if it wasn't set.
+//
+// The limit is left as it was if nothing was measured, eg because
+// nothing was being transferred during the probe.  If the limit has
+// been toggled off by the user the new limit will be used when it is
+// toggled on again.
+func setProbedLimit(percent int, bps float64) (ok bool) {
+	bandwidth := fs.SizeSuffix(bps * float64(percent) / 100)
+	if bandwidth <= 0 {
+		fs.Debugf(nil, "Nothing transferred while probing the bandwidth - leaving the limit alone")
+		return false
+	}
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+	targetBucket := &tokenBucket
+	if bwLimitToggledOff {
+		targetBucket = &prevTokenBucket
+	}
+	if *targetBucket != nil {
+		(*targetBucket).SetLimit(rate.Limit(bandwidth))
+	} else {
+		*targetBucket = newTokenBucket(bandwidth)
+	}
+	fs.Infof(nil, "Measured bandwidth %s. Limit set to %vBytes/s (%d%%)", FormatRate(bps), &bandwidth, percent)
+	return true
+}
+
 // limitBandwith sleeps for the correct amount of time for the passage
 // of n bytes according to the current bandwidth limit
+//
+// The lock isn't held while waiting so the limit can be read and
+// changed while transfers are being limited.
 func limitBandwidth(n int) {
+	atomic.AddInt64(&limitedBytes, int64(n))
 	tokenBucketMu.Lock()
+	tb := tokenBucket
+	tokenBucketMu.Unlock()
 
 	// Limit the transfer speed if required
-	if tokenBucket != nil {
-		err := tokenBucket.WaitN(context.Background(), n)
+	if tb != nil {
+		err := waitRamped(tb, n)
 		if err != nil {
 			fs.Errorf(nil, "Token bucket error: %v", err)
 		}
 	}
+}
+
+// SetBandwidthLimit sets a bandwidth limit in bytes per second for
+// this transfer only.  This is applied in addition to the global
+// bandwidth limit.  A limit of 0 means unlimited.
+//
+// This can be called while the transfer is in progress and the new
+// limit will be used from the next read.
+func (acc *Account) SetBandwidthLimit(bytesPerSecond int64) {
+	acc.statmu.Lock()
+	defer acc.statmu.Unlock()
+	switch {
+	case bytesPerSecond <= 0:
+		acc.limiter = nil
+	case acc.limiter == nil:
+		acc.limiter = newTokenBucket(fs.SizeSuffix(bytesPerSecond))
+	default:
+		acc.limiter.SetLimit(rate.Limit(bytesPerSecond))
+	}
+}
+
+// limitBandwidth sleeps for the correct amount of time for the
+// passage of n bytes according to the bandwidth limit of this
+// transfer
+func (acc *Account) limitBandwidth(n int) {
+	acc.statmu.Lock()
+	tb := acc.limiter
+	acc.statmu.Unlock()
+	if tb != nil {
+		err := waitTokens(tb, n)
+		if err != nil {
+			fs.Errorf(acc.Name(), "Token bucket error: %v", err)
+		}
+	}
+}
+
+// SetRemoteBandwidthLimit sets the bandwidth limit for the transfers
+// to the remote name to bandwidth in bytes per second.  A limit of 0
+// or less means unlimited.
+//
+// Accounts use the limit given by WithBwLimitRemote in addition to the
+// global limit.  Changing the limit changes it for the transfers in
+// progress too.
+func SetRemoteBandwidthLimit(name string, bandwidth fs.SizeSuffix) {
+	limit := rate.Inf
+	if bandwidth > 0 {
+		limit = rate.Limit(bandwidth)
+	}
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+	if b := remoteBuckets[name]; b != nil {
+		b.limiter.SetLimit(limit)
+		return
+	}
+	b := &remoteBucket{}
+	if bandwidth > 0 {
+		b.limiter = newTokenBucket(bandwidth)
+	} else {
+		b.limiter = rate.NewLimiter(limit, minBurstSize)
+	}
+	remoteBuckets[name] = b
+}
 
+// WithBwLimitRemote limits the bandwidth of the transfer to the limit
+// set for the remote name with --bwlimit-remote (or
+// SetRemoteBandwidthLimit), eg the name of the destination Fs.  If
+// there isn't a limit for name then only the global limit applies.
+//
+// The limit is looked up when this is called so call it when the
+// Account is made.
+func (acc *Account) WithBwLimitRemote(name string) *Account {
+	tokenBucketMu.Lock()
+	b := remoteBuckets[name]
 	tokenBucketMu.Unlock()
+	acc.statmu.Lock()
+	acc.remote = b
+	acc.statmu.Unlock()
+	return acc
+}
+
+// limitRemoteBandwidth sleeps for the correct amount of time for the
+// passage of n bytes according to the bandwidth limit of the remote
+// set by WithBwLimitRemote
+func (acc *Account) limitRemoteBandwidth(n int) {
+	acc.statmu.Lock()
+	b := acc.remote
+	acc.statmu.Unlock()
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.bytes, int64(n))
+	err := waitTokens(b.limiter, n)
+	if err != nil {
+		fs.Errorf(acc.Name(), "Token bucket error: %v", err)
+	}
+}
+
+// remoteLimit is the bandwidth limit of a remote and the bytes which
+// have been transferred through it
+type remoteLimit struct {
+	name      string
+	bandwidth fs.SizeSuffix // 0 if unlimited
+	bytes     int64
+}
+
+// remoteBandwidthLimits returns the bandwidth limits of the remotes
+// sorted by name
+func remoteBandwidthLimits() []remoteLimit {
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+	limits := make([]remoteLimit, 0, len(remoteBuckets))
+	for name, b := range remoteBuckets {
+		limit := remoteLimit{name: name, bytes: atomic.LoadInt64(&b.bytes)}
+		if l := b.limiter.Limit(); l != rate.Inf {
+			limit.bandwidth = fs.SizeSuffix(l)
+		}
+		limits = append(limits, limit)
+	}
+	sort.Sort(remoteLimitsByName(limits))
+	return limits
+}
+
+// remoteLimitsByName sorts remoteLimit by name
+type remoteLimitsByName []remoteLimit
+
+func (x remoteLimitsByName) Len() int           { return len(x) }
+func (x remoteLimitsByName) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x remoteLimitsByName) Less(i, j int) bool { return x[i].name < x[j].name }
+
+// bandwidthLimit returns the currently active global bandwidth limit
+// in bytes per second and whether a limit is active
+func bandwidthLimit() (bandwidth fs.SizeSuffix, limited bool) {
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+	if tokenBucket == nil {
+		return 0, false
+	}
+	return fs.SizeSuffix(tokenBucket.Limit()), true
 }
 
 // Remote control for the token bucket
@@ -0,0 +1,180 @@
+package accounting
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestToggleBandwidthLimit(t *testing.T) {
+	oldBwLimit := fs.Config.BwLimit
+	defer func() {
+		fs.Config.BwLimit = oldBwLimit
+		tokenBucketMu.Lock()
+		tokenBucket, prevTokenBucket, bwLimitToggledOff = nil, nil, false
+		tokenBucketMu.Unlock()
+	}()
+
+	// Fixed limit toggles off and back on to the same limit
+	fs.Config.BwLimit = fs.BwTimetable{{Bandwidth: 1024 * 1024}}
+	tokenBucketMu.Lock()
+	tokenBucket, prevTokenBucket, bwLimitToggledOff = newTokenBucket(1024*1024), nil, false
+	tokenBucketMu.Unlock()
+
+	toggleBandwidthLimit()
+	_, limited := bandwidthLimit()
+	assert.False(t, limited)
+
+	toggleBandwidthLimit()
+	bw, limited := bandwidthLimit()
+	assert.True(t, limited)
+	assert.Equal(t, fs.SizeSuffix(1024*1024), bw)
+
+	// With a timetable toggling back on uses the limit for now
+	// rather than the stale one
+	fs.Config.BwLimit = fs.BwTimetable{
+		{HHMM: 0, Bandwidth: 2 * 1024 * 1024},
+		{HHMM: 2359, Bandwidth: 2 * 1024 * 1024},
+	}
+	toggleBandwidthLimit()
+	_, limited = bandwidthLimit()
+	assert.False(t, limited)
+	tokenBucketMu.Lock()
+	prevTokenBucket = rate.NewLimiter(1, minBurstSize)
+	tokenBucketMu.Unlock()
+
+	toggleBandwidthLimit()
+	bw, limited = bandwidthLimit()
+	assert.True(t, limited)
+	assert.Equal(t, fs.SizeSuffix(2*1024*1024), bw)
+}
+
+func TestProbeBandwidth(t *testing.T) {
+	defer func() {
+		tokenBucketMu.Lock()
+		tokenBucket, prevTokenBucket, bwLimitToggledOff = nil, nil, false
+		tokenBucketMu.Unlock()
+	}()
+	tb := newTokenBucket(1024)
+	tokenBucketMu.Lock()
+	tokenBucket, prevTokenBucket, bwLimitToggledOff = tb, nil, false
+	tokenBucketMu.Unlock()
+
+	// The limit is lifted while probing
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 100; i++ {
+			limitBandwidth(64 * 1024)
+		}
+	}()
+	bps := probeBandwidth(200 * time.Millisecond)
+	<-done
+	assert.True(t, bps >= 100*64*1024/0.2, bps)
+
+	// and put back afterwards
+	tokenBucketMu.Lock()
+	assert.True(t, tokenBucket == tb)
+	tokenBucketMu.Unlock()
+
+	// The limit is set to the percentage of the measured bandwidth
+	// reusing the bucket
+	setProbedLimit(50, 4096)
+	bw, limited := bandwidthLimit()
+	assert.True(t, limited)
+	assert.Equal(t, fs.SizeSuffix(2048), bw)
+	assert.True(t, tokenBucket == tb)
+
+	// Nothing measured leaves the limit alone
+	setProbedLimit(50, 0)
+	bw, _ = bandwidthLimit()
+	assert.Equal(t, fs.SizeSuffix(2048), bw)
+
+	// If toggled off the limit is set for when it is toggled on
+	toggleBandwidthLimit()
+	setProbedLimit(25, 4096)
+	_, limited = bandwidthLimit()
+	assert.False(t, limited)
+	toggleBandwidthLimit()
+	bw, limited = bandwidthLimit()
+	assert.True(t, limited)
+	assert.Equal(t, fs.SizeSuffix(1024), bw)
+}
+
+func TestRemoteBandwidthLimit(t *testing.T) {
+	defer func() {
+		tokenBucketMu.Lock()
+		remoteBuckets = map[string]*remoteBucket{}
+		tokenBucketMu.Unlock()
+	}()
+	SetRemoteBandwidthLimit("remoteB", 0)
+	SetRemoteBandwidthLimit("remoteA", 1024*1024)
+
+	// Transfers to a remote with a limit use its bucket
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100))), 100, "a").WithBwLimitRemote("remoteA")
+	require.NotNil(t, acc.remote)
+	bucket := acc.remote
+	assert.Equal(t, rate.Limit(1024*1024), bucket.limiter.Limit())
+	_, err := ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	require.NoError(t, acc.Close())
+
+	// Unknown remotes only use the global limit
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "c").WithBwLimitRemote("remoteC")
+	assert.Nil(t, acc.remote)
+	require.NoError(t, acc.Close())
+
+	// Changing the limit keeps the bucket
+	SetRemoteBandwidthLimit("remoteA", 2*1024*1024)
+	assert.Equal(t, rate.Limit(2*1024*1024), bucket.limiter.Limit())
+
+	assert.Equal(t, []remoteLimit{
+		{name: "remoteA", bandwidth: 2 * 1024 * 1024, bytes: 100},
+		{name: "remoteB"},
+	}, remoteBandwidthLimits())
+	out := NewStats().String()
+	assert.Contains(t, out, "Bwlimit remoteA:    2 MB/s, 100 Bytes transferred\n")
+	assert.Contains(t, out, "Bwlimit remoteB:       off, 0 Bytes transferred\n")
+}
+
+func TestBurstSize(t *testing.T) {
+	oldBurst := fs.Config.BwLimitBurst
+	defer func() {
+		fs.Config.BwLimitBurst = oldBurst
+	}()
+
+	// By default a second's worth but at least minBurstSize
+	fs.Config.BwLimitBurst = 0
+	assert.Equal(t, minBurstSize, burstSize(1024))
+	assert.Equal(t, 10*1024*1024, burstSize(10*1024*1024))
+	assert.Equal(t, maxBurstSize, burstSize(1<<40))
+
+	// Set independently from the bandwidth
+	fs.Config.BwLimitBurst = 4 * 1024 * 1024
+	assert.Equal(t, 4*1024*1024, burstSize(1024))
+	assert.Equal(t, 4*1024*1024, burstSize(10*1024*1024))
+
+	tb := newTokenBucket(10 * 1024 * 1024)
+	assert.Equal(t, 4*1024*1024, tb.Burst())
+}
+
+func TestWaitTokens(t *testing.T) {
+	// Asking for more than the burst at once doesn't fail or hang
+	tb := rate.NewLimiter(rate.Inf, 10)
+	assert.NoError(t, waitTokens(tb, 25))
+
+	tb = rate.NewLimiter(1000, 10)
+	start := time.Now()
+	assert.NoError(t, waitTokens(tb, 30))
+	// 10 from the full bucket then 20 at 1000/s
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+
+	assert.NoError(t, waitTokens(tb, 0))
+}
@@ -0,0 +1,216 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/lib/atexit"
+	"github.com/pkg/errors"
+)
+
+// transferLogJSON is the JSON representation of a finished transfer
+// in the transfer log, one per line.
+//
+// Don't change the keys as scripts rely on them.
+type transferLogJSON struct {
+	Name     string            `json:"name"`
+	Size     *int64            `json:"size"` // null if unknown
+	Bytes    int64             `json:"bytes"`
+	Duration float64           `json:"duration"` // seconds
+	Speed    float64           `json:"speed"`    // average in bytes/s
+	Retries  int               `json:"retries"`
+	Checksum map[string]string `json:"checksum,omitempty"` // hash name to hex - only if set up with WithHash
+	Error    string            `json:"error,omitempty"`
+}
+
+// newTransferLogJSON converts s into its transfer log representation
+func newTransferLogJSON(s TransferSnapshot) transferLogJSON {
+	t := transferLogJSON{
+		Name:     s.Name,
+		Bytes:    s.Bytes,
+		Duration: s.Duration.Seconds(),
+		Speed:    s.AverageSpeed,
+		Retries:  s.Retries,
+	}
+	if s.Size >= 0 {
+		size := s.Size
+		t.Size = &size
+	}
+	if len(s.Hashes) > 0 {
+		t.Checksum = make(map[string]string, len(s.Hashes))
+		for ht, sum := range s.Hashes {
+			t.Checksum[ht.String()] = sum
+		}
+	}
+	if s.Error != nil {
+		t.Error = s.Error.Error()
+	}
+	return t
+}
+
+// TransferLog writes a JSON object on a line of its own for every
+// transfer which finishes.
+//
+// The lines are queued and written by a goroutine of its own so
+// finishing a transfer never waits for the writes.  They are buffered
+// so call Close to flush them.
+type TransferLog struct {
+	mu     sync.Mutex
+	queue  []TransferSnapshot // transfers waiting to be written
+	closed bool               // set when Close has been called
+	wake   chan struct{}      // signals the writer there is something in the queue
+	done   chan struct{}      // closed when the writer has finished
+	out    *bufio.Writer
+	closer io.Closer // closed by Close if set
+	err    error     // first error writing the log
+}
+
+// NewTransferLog makes a TransferLog which writes to w.
+//
+// w isn't closed by Close.
+func NewTransferLog(w io.Writer) *TransferLog {
+	l := &TransferLog{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		out:  bufio.NewWriter(w),
+	}
+	go l.writer()
+	return l
+}
+
+// OpenTransferLog makes a TransferLog which appends to the file at
+// path, creating it if necessary.  The file is closed by Close.
+func OpenTransferLog(path string) (*TransferLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open transfer log")
+	}
+	l := NewTransferLog(f)
+	l.closer = f
+	return l, nil
+}
+
+// add queues s to be written.  It never blocks on the writes.
+func (l *TransferLog) add(s TransferSnapshot) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.queue = append(l.queue, s)
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writer writes the queued transfers until the log is closed and
+// the queue is empty, flushing whenever the queue runs dry.
+func (l *TransferLog) writer() {
+	defer close(l.done)
+	enc := json.NewEncoder(l.out)
+	for {
+		l.mu.Lock()
+		queue, closed := l.queue, l.closed
+		l.queue = nil
+		l.mu.Unlock()
+		for _, s := range queue {
+			l.setErr(enc.Encode(newTransferLogJSON(s)))
+		}
+		if len(queue) > 0 {
+			l.setErr(l.out.Flush())
+		}
+		if closed {
+			return
+		}
+		<-l.wake
+	}
+}
+
+// setErr records err if it is the first error
+func (l *TransferLog) setErr(err error) {
+	if err == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.err == nil {
+		l.err = err
+		fs.Errorf(nil, "Failed to write transfer log: %v", err)
+	}
+	l.mu.Unlock()
+}
+
+// Close writes and flushes the transfers queued and stops the
+// TransferLog.  Transfers which finish afterwards aren't logged.
+//
+// It returns the first error writing the log, if any.  It is safe to
+// call more than once.
+func (l *TransferLog) Close() error {
+	l.mu.Lock()
+	closed := l.closed
+	l.closed = true
+	l.mu.Unlock()
+	if !closed {
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+	<-l.done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !closed && l.closer != nil {
+		if err := l.closer.Close(); err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+	return l.err
+}
+
+var (
+	transferLogMu sync.Mutex
+	transferLog   *TransferLog // log of the finished transfers or nil
+)
+
+// SetTransferLog sets the TransferLog which all transfers are logged
+// to when they finish.  nil turns the logging off.  The previous
+// TransferLog, if any, isn't closed.
+func SetTransferLog(l *TransferLog) {
+	transferLogMu.Lock()
+	transferLog = l
+	transferLogMu.Unlock()
+}
+
+// logTransfer logs s to the TransferLog if there is one
+func logTransfer(s TransferSnapshot) {
+	transferLogMu.Lock()
+	l := transferLog
+	transferLogMu.Unlock()
+	if l != nil {
+		l.add(s)
+	}
+}
+
+// StartTransferLog opens the transfer log set with --transfer-log if
+// any.  It is closed, flushing it, when rclone exits.
+func StartTransferLog() error {
+	if fs.Config.TransferLog == "" {
+		return nil
+	}
+	l, err := OpenTransferLog(fs.Config.TransferLog)
+	if err != nil {
+		return err
+	}
+	SetTransferLog(l)
+	atexit.Register(func() {
+		SetTransferLog(nil)
+		_ = l.Close()
+	})
+	fs.Infof(nil, "Logging transfers to %q", fs.Config.TransferLog)
+	return nil
+}
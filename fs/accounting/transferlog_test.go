@@ -0,0 +1,94 @@
+package accounting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncw/rclone/fs/hash"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTransferLog decodes the lines of a transfer log
+func readTransferLog(t *testing.T, data []byte) (lines []map[string]interface{}) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestTransferLog(t *testing.T) {
+	var out bytes.Buffer
+	l := NewTransferLog(&out)
+	SetTransferLog(l)
+	defer SetTransferLog(nil)
+
+	acc := NewAccountSizeName(ioutil.NopCloser(bytes.NewBufferString("hello")), 5, "ok")
+	acc, err := acc.WithHash(hash.NewHashSet(hash.MD5))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(acc)
+	require.NoError(t, err)
+	require.NoError(t, acc.Close())
+
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), -1, "failed")
+	acc.Finish(errors.New("potato"))
+	require.NoError(t, acc.Close())
+
+	require.NoError(t, l.Close())
+	require.NoError(t, l.Close())
+
+	lines := readTransferLog(t, out.Bytes())
+	require.Equal(t, 2, len(lines))
+
+	ok := lines[0]
+	assert.Equal(t, "ok", ok["name"])
+	assert.Equal(t, float64(5), ok["size"])
+	assert.Equal(t, float64(5), ok["bytes"])
+	assert.Equal(t, float64(0), ok["retries"])
+	assert.Equal(t, map[string]interface{}{"MD5": "5d41402abc4b2a76b9719d911017c592"}, ok["checksum"])
+	assert.NotContains(t, ok, "error")
+
+	failed := lines[1]
+	assert.Equal(t, "failed", failed["name"])
+	assert.Nil(t, failed["size"])
+	assert.Equal(t, "potato", failed["error"])
+	assert.NotContains(t, failed, "checksum")
+
+	// Nothing is logged after Close
+	acc = NewAccountSizeName(ioutil.NopCloser(bytes.NewBuffer(nil)), 0, "late")
+	require.NoError(t, acc.Close())
+	assert.Equal(t, 2, len(readTransferLog(t, out.Bytes())))
+}
+
+func TestOpenTransferLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-transfer-log")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	path := filepath.Join(dir, "transfers.log")
+
+	// The file is appended to
+	for i := 0; i < 2; i++ {
+		l, err := OpenTransferLog(path)
+		require.NoError(t, err)
+		l.add(TransferSnapshot{Name: "file", Size: 1})
+		require.NoError(t, l.Close())
+	}
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(readTransferLog(t, data)))
+
+	_, err = OpenTransferLog(filepath.Join(dir, "missing", "transfers.log"))
+	assert.Error(t, err)
+}
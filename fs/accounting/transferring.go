@@ -0,0 +1,288 @@
+package accounting
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fs/rc"
+	"github.com/pkg/errors"
+)
+
+// TransferFilter selects the transfers returned by Transferring
+type TransferFilter struct {
+	Include  string  // only transfers whose name matches this glob if set
+	MaxSpeed float64 // only transfers slower than this in bytes/s if > 0
+}
+
+// match returns true if the transfer passes the filter
+func (f *TransferFilter) match(s AccountSnapshot) (bool, error) {
+	if f.Include != "" {
+		ok, err := path.Match(f.Include, s.Name)
+		if err != nil {
+			return false, errors.Wrap(err, "bad include glob")
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	if f.MaxSpeed > 0 && s.CurrentSpeed >= f.MaxSpeed {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Transferring returns snapshots of the transfers in progress in the
+// global Stats and any live jobs which pass filter.
+//
+// They are sorted in the order set with --stats-sort, as they are
+// in the stats - see sortSnapshots.
+func Transferring(filter TransferFilter) ([]AccountSnapshot, error) {
+	var out []AccountSnapshot
+	for _, s := range AggregateStats().inProgress.snapshots() {
+		ok, err := filter.match(s)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, s)
+		}
+	}
+	sortSnapshots(out)
+	return out, nil
+}
+
+// LookupTransfer returns the Account of the transfer in progress in
+// the global Stats or any live job with the ID or name nameOrID, found
+// in the same way as StatsInfo.AbortTransfer does, eg to fetch the
+// live stats of a file some other job is transferring.
+//
+// The Account returned is the one doing the transfer so it stays the
+// same for as long as the transfer goes on.  ok is false if there is
+// no such transfer or if more than one has the name, in which case use
+// the ID or prefix the name with the remote.
+func LookupTransfer(nameOrID string) (acc *Account, ok bool) {
+	acc, err := AggregateStats().inProgress.lookup(nameOrID)
+	return acc, err == nil
+}
+
+// DumpInProgress writes the stats line of each of the transfers in
+// progress in the global Stats and any live jobs to w, sorted by name.
+// Use this to show the transfers on demand, eg from a signal handler,
+// rather than waiting for the next stats.
+func DumpInProgress(w io.Writer) error {
+	accs := AggregateStats().inProgress.accounts()
+	sort.Sort(accountsByName(accs))
+	names := statsNames(accs)
+	width := 0
+	for _, acc := range accs {
+		if nameWidth := runewidth.StringWidth(names[acc]); nameWidth > width {
+			width = nameWidth
+		}
+	}
+	lines := make([]string, 0, len(accs))
+	for _, acc := range accs {
+		lines = append(lines, acc.stringWidth(names[acc], width))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountsByName sorts Accounts by name then ID
+type accountsByName []*Account
+
+func (x accountsByName) Len() int      { return len(x) }
+func (x accountsByName) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x accountsByName) Less(i, j int) bool {
+	a, b := x[i].Name(), x[j].Name()
+	if a != b {
+		return a < b
+	}
+	return lessID(x[i].id, x[j].id)
+}
+
+// statsNames returns the names of accs to show in the stats truncated
+// with truncateName.  Names which more than one of accs has are
+// prefixed with the name of the remote, eg "remote:path/to/file", so
+// the transfers can be told apart.
+func statsNames(accs []*Account) map[*Account]string {
+	snapshots := make([]AccountSnapshot, len(accs))
+	count := make(map[string]int, len(accs))
+	for i, acc := range accs {
+		acc.statmu.Lock()
+		snapshots[i] = AccountSnapshot{Name: acc.name, RemoteName: acc.fsName}
+		acc.statmu.Unlock()
+		count[snapshots[i].Name]++
+	}
+	names := make(map[*Account]string, len(accs))
+	for i, acc := range accs {
+		name := snapshots[i].Name
+		if count[name] > 1 {
+			name = snapshots[i].DisplayName()
+		}
+		names[acc] = truncateName(name, fs.Config.StatsFileNameLength, fs.Config.StatsFileNameMode)
+	}
+	return names
+}
+
+// sortSnapshots sorts snapshots in the order set with --stats-sort,
+// breaking ties by name and ID so the order is stable between one display
+// and the next.  The orders are
+//
+//   - start - by start time with transfers which haven't started yet last
+//   - name - by name
+//   - remaining - the least left to do first, with unknown sizes last
+//   - speed - the fastest first
+func sortSnapshots(snapshots []AccountSnapshot) {
+	sort.Sort(snapshotsInOrder{order: fs.Config.StatsSort, snapshots: snapshots})
+}
+
+// snapshotsInOrder sorts AccountSnapshot by order then name then ID
+type snapshotsInOrder struct {
+	order     string
+	snapshots []AccountSnapshot
+}
+
+func (x snapshotsInOrder) Len() int { return len(x.snapshots) }
+func (x snapshotsInOrder) Swap(i, j int) {
+	x.snapshots[i], x.snapshots[j] = x.snapshots[j], x.snapshots[i]
+}
+func (x snapshotsInOrder) Less(i, j int) bool {
+	a, b := &x.snapshots[i], &x.snapshots[j]
+	switch x.order {
+	case "name":
+	case "remaining":
+		if a.PercentageValid != b.PercentageValid {
+			return a.PercentageValid
+		}
+		if a.Percentage != b.Percentage {
+			return a.Percentage > b.Percentage
+		}
+	case "speed":
+		if a.CurrentSpeed != b.CurrentSpeed {
+			return a.CurrentSpeed > b.CurrentSpeed
+		}
+	default:
+		switch {
+		case a.Start.Equal(b.Start):
+		case a.Start.IsZero():
+			return false
+		case b.Start.IsZero():
+			return true
+		default:
+			return a.Start.Before(b.Start)
+		}
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return lessID(a.ID, b.ID)
+}
+
+// rcTransferring lists the transfers in progress for the rc
+func rcTransferring(in rc.Params) (out rc.Params, err error) {
+	var filter TransferFilter
+	if include, ok := in["include"]; ok {
+		filter.Include, ok = include.(string)
+		if !ok {
+			return out, errors.Errorf("value must be string include=%v", include)
+		}
+	}
+	if maxSpeed, ok := in["maxSpeed"]; ok {
+		switch v := maxSpeed.(type) {
+		case float64:
+			filter.MaxSpeed = v
+		case string:
+			var speed fs.SizeSuffix
+			err = speed.Set(v)
+			if err != nil {
+				return out, errors.Wrap(err, "bad maxSpeed")
+			}
+			filter.MaxSpeed = float64(speed)
+		default:
+			return out, errors.Errorf("value must be string or number maxSpeed=%v", maxSpeed)
+		}
+	}
+	snapshots, err := Transferring(filter)
+	if err != nil {
+		return out, err
+	}
+	transferring := make([]transferJSON, 0, len(snapshots))
+	for _, s := range snapshots {
+		transferring = append(transferring, newTransferJSON(s))
+	}
+	return rc.Params{"transferring": transferring}, nil
+}
+
+// rcAbort aborts a transfer in progress for the rc
+func rcAbort(in rc.Params) (out rc.Params, err error) {
+	iname, ok := in["name"]
+	if !ok {
+		return out, errors.Errorf("parameter name not found")
+	}
+	name, ok := iname.(string)
+	if !ok {
+		return out, errors.Errorf("value must be string name=%v", iname)
+	}
+	return out, AggregateStats().AbortTransfer(name)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "core/abort",
+		Fn:    rcAbort,
+		Title: "Abort a transfer in progress",
+		Help: `
+This aborts the transfer in progress with the id or name passed in, as
+shown by core/transferring.  The transfer fails with the error "transfer
+aborted" and the rest of the sync carries on.
+
+If more than one transfer has the name, eg when copying the same path
+to two remotes, prefix it with the name of the remote, eg
+remote:path/to/big.iso, or use the id instead.  Nothing is aborted if
+the name is still ambiguous.
+
+Eg
+
+    rclone rc core/abort name=path/to/big.iso
+    rclone rc core/abort name=#42
+`,
+	})
+	rc.Add(rc.Call{
+		Path:  "core/transferring",
+		Fn:    rcTransferring,
+		Title: "List the transfers in progress",
+		Help: `
+This returns the transfers in progress in the transferring response
+in the order set with --stats-sort, which is by the time they started
+with transfers which haven't read any data yet last by default.  Each
+transfer has these keys
+
+- name - name of the file
+- bytes - bytes transferred so far
+- size - size of the file - null if unknown
+- percentage - percentage done - null if the size is unknown
+- speed - average speed since the start in bytes/s
+- speedAvg - moving average of the speed in bytes/s
+- eta - seconds to completion - null if unknown
+- start - time the first byte was read - null if not started
+- id - unique id of the transfer, eg #42 - "" if not opened yet
+
+Parameters
+
+- include - only list transfers whose name matches this glob
+- maxSpeed - only list transfers whose current speed is below this, eg 1M
+
+Eg
+
+    rclone rc core/transferring include='*.iso' maxSpeed=100k
+`,
+	})
+}
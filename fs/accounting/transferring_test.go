@@ -0,0 +1,179 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferring(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	ctx := WithStats(context.Background(), job)
+	newAcc := func(name string) *Account {
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+		return NewAccountSizeNameContext(ctx, in, 100, name)
+	}
+	b := newAcc("transferring-test-b")
+	a := newAcc("transferring-test-a")
+	c := newAcc("transferring-test-c")
+	notMatched := newAcc("other")
+	defer func() {
+		for _, acc := range []*Account{a, b, c, notMatched} {
+			assert.NoError(t, acc.Close())
+		}
+	}()
+	// b starts before c, a hasn't started
+	_, err := b.Read(make([]byte, 10))
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = c.Read(make([]byte, 10))
+	require.NoError(t, err)
+	c.statmu.Lock()
+	c.avg.Set(1e6)
+	c.statmu.Unlock()
+
+	names := func(snapshots []AccountSnapshot) (out []string) {
+		for _, s := range snapshots {
+			out = append(out, s.Name)
+		}
+		return out
+	}
+
+	got, err := Transferring(TransferFilter{Include: "transferring-test-*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"transferring-test-b", "transferring-test-c", "transferring-test-a"}, names(got))
+
+	got, err = Transferring(TransferFilter{Include: "transferring-test-*", MaxSpeed: 1e6})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"transferring-test-b", "transferring-test-a"}, names(got))
+
+	_, err = Transferring(TransferFilter{Include: "["})
+	assert.Error(t, err)
+
+	// The rc call
+	out, err := rcTransferring(map[string]interface{}{
+		"include":  "transferring-test-[bc]",
+		"maxSpeed": "900k",
+	})
+	require.NoError(t, err)
+	data, err := json.Marshal(out)
+	require.NoError(t, err)
+	var decoded struct {
+		Transferring []struct {
+			Name string `json:"name"`
+		} `json:"transferring"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, 1, len(decoded.Transferring))
+	assert.Equal(t, "transferring-test-b", decoded.Transferring[0].Name)
+
+	_, err = rcTransferring(map[string]interface{}{"maxSpeed": "fast"})
+	assert.Error(t, err)
+	_, err = rcTransferring(map[string]interface{}{"include": 1})
+	assert.Error(t, err)
+}
+
+func TestRcAbort(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+	acc := NewAccountSizeNameContext(WithStats(context.Background(), job), in, 100, "rc-abort-test")
+
+	_, err := rcAbort(map[string]interface{}{"name": "rc-abort-test"})
+	require.NoError(t, err)
+	_, err = acc.Read(make([]byte, 10))
+	assert.Equal(t, ErrorTransferAborted, err)
+	assert.NoError(t, acc.Close())
+
+	_, err = rcAbort(map[string]interface{}{"name": "rc-abort-test"})
+	assert.Error(t, err)
+	_, err = rcAbort(map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestLookupTransfer(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	ctx := WithStats(context.Background(), job)
+	newAcc := func(remote string) *Account {
+		in := ioutil.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+		return NewAccountSizeNameContext(ctx, in, 100, "lookup-test").WithRemoteName(remote)
+	}
+	acc := newAcc("remote1")
+
+	got, ok := LookupTransfer("lookup-test")
+	require.True(t, ok)
+	assert.True(t, got == acc)
+	got, ok = LookupTransfer(acc.ID())
+	require.True(t, ok)
+	assert.True(t, got == acc)
+
+	// Ambiguous unless the remote is given
+	acc2 := newAcc("remote2")
+	_, ok = LookupTransfer("lookup-test")
+	assert.False(t, ok)
+	got, ok = LookupTransfer("remote2:lookup-test")
+	require.True(t, ok)
+	assert.True(t, got == acc2)
+
+	assert.NoError(t, acc.Close())
+	assert.NoError(t, acc2.Close())
+	_, ok = LookupTransfer("lookup-test")
+	assert.False(t, ok)
+}
+
+func TestDumpInProgress(t *testing.T) {
+	job := NewJobStats()
+	defer job.Remove()
+	ctx := WithStats(context.Background(), job)
+	for _, name := range []string{"dump-test-bb", "dump-test-a"} {
+		in := ioutil.NopCloser(bytes.NewBuffer(nil))
+		acc := NewAccountSizeNameContext(ctx, in, 100, name)
+		defer func() { assert.NoError(t, acc.Close()) }()
+	}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, DumpInProgress(out))
+	assert.Regexp(t, `(?m)^ +dump-test-a:  0% /100, 0 B/s, -\n *dump-test-bb:  0% /100, 0 B/s, -$`, out.String())
+}
+
+func TestSortSnapshots(t *testing.T) {
+	oldStatsSort := fs.Config.StatsSort
+	defer func() {
+		fs.Config.StatsSort = oldStatsSort
+	}()
+	t0 := time.Unix(1000, 0)
+	snapshots := []AccountSnapshot{
+		{Name: "d", Start: t0, Percentage: 50, PercentageValid: true, CurrentSpeed: 10},
+		{Name: "c"},
+		{Name: "b", Start: t0.Add(-time.Second), Percentage: 90, PercentageValid: true, CurrentSpeed: 5},
+		{Name: "a", Start: t0, Percentage: 50, PercentageValid: true, CurrentSpeed: 20},
+	}
+	names := func() (names []string) {
+		sortSnapshots(snapshots)
+		for _, s := range snapshots {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	for _, test := range []struct {
+		order string
+		want  []string
+	}{
+		{"start", []string{"b", "a", "d", "c"}},
+		{"name", []string{"a", "b", "c", "d"}},
+		{"remaining", []string{"b", "a", "d", "c"}},
+		{"speed", []string{"a", "d", "b", "c"}},
+	} {
+		fs.Config.StatsSort = test.order
+		assert.Equal(t, test.want, names(), test.order)
+	}
+}
@@ -5,6 +5,7 @@ package asyncreader
 import (
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ncw/rclone/lib/readers"
 	"github.com/pkg/errors"
@@ -16,10 +17,6 @@ const (
 	softStartInitial = 4 * 1024
 )
 
-var asyncBufferPool = sync.Pool{
-	New: func() interface{} { return newBuffer() },
-}
-
 var errorStreamAbandoned = errors.New("stream abandoned")
 
 // AsyncReader will do async read-ahead from the input reader
@@ -27,6 +24,7 @@ var errorStreamAbandoned = errors.New("stream abandoned")
 // This should be fully transparent, except that once an error
 // has been returned from the Reader, it will not recover.
 type AsyncReader struct {
+	bufd    int64         // bytes read ahead but not yet read - use atomic - first for alignment
 	in      io.ReadCloser // Input reader
 	ready   chan *buffer  // Buffers ready to be handed to the reader
 	token   chan struct{} // Tokens which allow a buffer to be taken
@@ -38,6 +36,8 @@ type AsyncReader struct {
 	size    int           // size of buffer to use
 	closed  bool          // whether we have closed the underlying stream
 	mu      sync.Mutex    // lock for Read/WriteTo/Abandon/Close
+	growMu  sync.Mutex    // lock for changing buffers
+	retire  int           // tokens to take out of use to shrink the buffers - guarded by growMu
 }
 
 // New returns a reader that will asynchronously read from
@@ -46,6 +46,10 @@ type AsyncReader struct {
 // function has returned.
 // The input can be read from the returned reader.
 // When done use Close to release the buffers and close the supplied input.
+//
+// If the memory limit set with SetMemoryLimit doesn't allow all the
+// buffers then it uses as many as it can, returning ErrorMemoryLimit
+// if that is none.
 func New(rd io.ReadCloser, buffers int) (*AsyncReader, error) {
 	if buffers <= 0 {
 		return nil, errors.New("number of buffers too small")
@@ -54,14 +58,42 @@ func New(rd io.ReadCloser, buffers int) (*AsyncReader, error) {
 		return nil, errors.New("nil reader supplied")
 	}
 	a := &AsyncReader{}
-	a.init(rd, buffers)
+	err := a.init(rd, buffers, buffers)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// NewGrowable returns a reader like New which starts with buffers
+// buffers but can be grown to use up to maxBuffers, or shrunk, with
+// SetBuffers.
+func NewGrowable(rd io.ReadCloser, buffers, maxBuffers int) (*AsyncReader, error) {
+	if buffers <= 0 {
+		return nil, errors.New("number of buffers too small")
+	}
+	if maxBuffers < buffers {
+		return nil, errors.New("maximum number of buffers too small")
+	}
+	if rd == nil {
+		return nil, errors.New("nil reader supplied")
+	}
+	a := &AsyncReader{}
+	err := a.init(rd, buffers, maxBuffers)
+	if err != nil {
+		return nil, err
+	}
 	return a, nil
 }
 
-func (a *AsyncReader) init(rd io.ReadCloser, buffers int) {
+func (a *AsyncReader) init(rd io.ReadCloser, buffers, maxBuffers int) error {
+	buffers = pool.reserve(buffers)
+	if buffers == 0 {
+		return ErrorMemoryLimit
+	}
 	a.in = rd
-	a.ready = make(chan *buffer, buffers)
-	a.token = make(chan struct{}, buffers)
+	a.ready = make(chan *buffer, maxBuffers)
+	a.token = make(chan struct{}, maxBuffers)
 	a.exit = make(chan struct{}, 0)
 	a.exited = make(chan struct{}, 0)
 	a.buffers = buffers
@@ -87,6 +119,7 @@ func (a *AsyncReader) init(rd io.ReadCloser, buffers int) {
 					a.size <<= 1
 				}
 				err := b.read(a.in)
+				atomic.AddInt64(&a.bufd, int64(len(b.buf)))
 				a.ready <- b
 				if err != nil {
 					return
@@ -96,18 +129,119 @@ func (a *AsyncReader) init(rd io.ReadCloser, buffers int) {
 			}
 		}
 	}()
+	return nil
 }
 
 // return the buffer to the pool (clearing it)
 func (a *AsyncReader) putBuffer(b *buffer) {
-	b.clear()
-	asyncBufferPool.Put(b)
+	pool.put(b)
 }
 
 // get a buffer from the pool
 func (a *AsyncReader) getBuffer() *buffer {
-	b := asyncBufferPool.Get().(*buffer)
-	return b
+	return pool.get()
+}
+
+// SetBuffers changes the number of buffers in use to buffers, at
+// least 1 and up to the maximum passed to NewGrowable.  It returns
+// the number of buffers now in use.  This may be fewer than asked for
+// if the memory limit set with SetMemoryLimit is reached, and is only
+// more than asked for if less than 1 was asked for.
+//
+// If the number is reduced then buffers which are in use are taken
+// out of use as they are read so data already read ahead is never
+// lost.  Their memory is given back to the memory limit then.
+//
+// It is safe to call while the AsyncReader is being read.
+func (a *AsyncReader) SetBuffers(buffers int) int {
+	a.growMu.Lock()
+	defer a.growMu.Unlock()
+	if buffers > cap(a.token) {
+		buffers = cap(a.token)
+	}
+	if buffers < 1 {
+		buffers = 1
+	}
+	if buffers == a.buffers {
+		return a.buffers
+	}
+	select {
+	case <-a.exit:
+		// Don't reserve buffers which will never be released
+		return a.buffers
+	default:
+	}
+	if buffers < a.buffers {
+		a.retire += a.buffers - buffers
+		a.buffers = buffers
+		// Take the buffers which aren't in use out of use now
+		for a.retire > 0 && a.takeToken() {
+			a.retire--
+			pool.release(1)
+		}
+		return a.buffers
+	}
+	// Put back any buffers which haven't been taken out of use yet
+	// before reserving any more
+	if a.retire > 0 {
+		n := buffers - a.buffers
+		if n > a.retire {
+			n = a.retire
+		}
+		a.retire -= n
+		a.buffers += n
+	}
+	n := pool.reserve(buffers - a.buffers)
+	for i := 0; i < n; i++ {
+		a.token <- struct{}{}
+	}
+	a.buffers += n
+	return a.buffers
+}
+
+// takeToken takes a token if one is free without waiting, returning
+// true if it did.
+func (a *AsyncReader) takeToken() bool {
+	select {
+	case <-a.token:
+		return true
+	default:
+		return false
+	}
+}
+
+// returnToken gives back the token of a buffer which has been read,
+// unless the buffers are being shrunk in which case the buffer is
+// taken out of use.
+func (a *AsyncReader) returnToken() {
+	a.growMu.Lock()
+	defer a.growMu.Unlock()
+	if a.retire > 0 {
+		a.retire--
+		pool.release(1)
+		return
+	}
+	a.token <- struct{}{}
+}
+
+// Buffers returns the number of buffers in use
+func (a *AsyncReader) Buffers() int {
+	a.growMu.Lock()
+	defer a.growMu.Unlock()
+	return a.buffers
+}
+
+// Buffered returns the number of bytes read ahead but not yet read
+// and the capacity of the buffers.  It is safe to call at any time.
+func (a *AsyncReader) Buffered() (buffered, capacity int64) {
+	return atomic.LoadInt64(&a.bufd), int64(a.Buffers()) * BufferSize
+}
+
+// increment the offset of the current buffer by n bytes which have
+// been read
+func (a *AsyncReader) increment(n int) {
+	a.cur.increment(n)
+	atomic.AddInt64(&a.bufd, -int64(n))
 }
 
 // Read will return the next available data.
@@ -115,7 +249,7 @@ func (a *AsyncReader) fill() (err error) {
 	if a.cur.isEmpty() {
 		if a.cur != nil {
 			a.putBuffer(a.cur)
-			a.token <- struct{}{}
+			a.returnToken()
 			a.cur = nil
 		}
 		b, ok := <-a.ready
@@ -144,7 +278,7 @@ func (a *AsyncReader) Read(p []byte) (n int, err error) {
 
 	// Copy what we can
 	n = copy(p, a.cur.buffer())
-	a.cur.increment(n)
+	a.increment(n)
 
 	// If at end of buffer, return any error, if present
 	if a.cur.isEmpty() {
@@ -168,7 +302,7 @@ func (a *AsyncReader) WriteTo(w io.Writer) (n int64, err error) {
 			return n, err
 		}
 		n2, err := w.Write(a.cur.buffer())
-		a.cur.increment(n2)
+		a.increment(n2)
 		n += int64(n2)
 		if err != nil {
 			return n, err
@@ -183,10 +317,18 @@ func (a *AsyncReader) WriteTo(w io.Writer) (n int64, err error) {
 // Abandon will ensure that the underlying async reader is shut down.
 // It will NOT close the input supplied on New.
 func (a *AsyncReader) Abandon() {
+	_ = a.Drain()
+}
+
+// Drain shuts down the underlying async reader like Abandon and
+// discards any data which has been read ahead but not yet read from
+// the AsyncReader.  It returns the number of bytes discarded.
+// It will NOT close the input supplied on New.
+func (a *AsyncReader) Drain() (discarded int64) {
 	select {
 	case <-a.exit:
 		// Do nothing if reader routine already exited
-		return
+		return 0
 	default:
 	}
 	// Close and wait for go routine
@@ -197,12 +339,21 @@ func (a *AsyncReader) Abandon() {
 	defer a.mu.Unlock()
 	// Return any outstanding buffers to the Pool
 	if a.cur != nil {
+		discarded += int64(len(a.cur.buffer()))
 		a.putBuffer(a.cur)
 		a.cur = nil
 	}
 	for b := range a.ready {
+		discarded += int64(len(b.buffer()))
 		a.putBuffer(b)
 	}
+	atomic.StoreInt64(&a.bufd, 0)
+	// Give back the memory reserved for the buffers
+	a.growMu.Lock()
+	pool.release(a.buffers + a.retire)
+	a.retire = 0
+	a.growMu.Unlock()
+	return discarded
 }
 
 // Close will ensure that the underlying async reader is shut down.
@@ -281,3 +281,103 @@ func testAsyncReaderClose(t *testing.T, writeto bool) {
 }
 func TestAsyncReaderCloseRead(t *testing.T)    { testAsyncReaderClose(t, false) }
 func TestAsyncReaderCloseWriteTo(t *testing.T) { testAsyncReaderClose(t, true) }
+
+func TestAsyncReaderDrain(t *testing.T) {
+	buf := ioutil.NopCloser(bytes.NewBufferString("Testbuffer"))
+	ar, err := New(buf, 4)
+	require.NoError(t, err)
+
+	var dst = make([]byte, 4)
+	n, err := io.ReadFull(ar, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	assert.Equal(t, int64(6), ar.Drain())
+	assert.Equal(t, int64(0), ar.Drain())
+
+	_, err = ar.Read(dst)
+	assert.Equal(t, errorStreamAbandoned, err)
+	require.NoError(t, ar.Close())
+}
+
+func TestAsyncReaderSetBuffers(t *testing.T) {
+	_, err := NewGrowable(ioutil.NopCloser(bytes.NewBufferString("x")), 2, 1)
+	require.Error(t, err)
+
+	data := make([]byte, 8*BufferSize)
+	ar, err := NewGrowable(ioutil.NopCloser(bytes.NewBuffer(data)), 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ar.Buffers())
+
+	// Grow while reading
+	var dst = make([]byte, BufferSize)
+	_, err = io.ReadFull(ar, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 3, ar.SetBuffers(3))
+	assert.Equal(t, 4, ar.SetBuffers(10))
+	assert.Equal(t, 1, ar.SetBuffers(0))
+	assert.Equal(t, 2, ar.SetBuffers(2))
+
+	got, err := ioutil.ReadAll(ar)
+	require.NoError(t, err)
+	assert.Equal(t, len(data)-BufferSize, len(got))
+	require.NoError(t, ar.Close())
+}
+
+func TestAsyncReaderSetBuffersShrink(t *testing.T) {
+	data := make([]byte, 8*BufferSize)
+	ar, err := NewGrowable(ioutil.NopCloser(bytes.NewBuffer(data)), 4, 4)
+	require.NoError(t, err)
+	used, _ := MemoryUsed()
+	assert.Equal(t, int64(4*BufferSize), used)
+
+	// Shrinking keeps the data already read ahead
+	var dst = make([]byte, BufferSize)
+	_, err = io.ReadFull(ar, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ar.SetBuffers(1))
+	_, capacity := ar.Buffered()
+	assert.Equal(t, int64(BufferSize), capacity)
+
+	got, err := ioutil.ReadAll(ar)
+	require.NoError(t, err)
+	assert.Equal(t, len(data)-BufferSize, len(got))
+
+	// The buffers taken out of use have been given back
+	used, _ = MemoryUsed()
+	assert.Equal(t, int64(BufferSize), used)
+
+	// Growing again after shrinking
+	assert.Equal(t, 3, ar.SetBuffers(3))
+	require.NoError(t, ar.Close())
+	used, _ = MemoryUsed()
+	assert.Equal(t, int64(0), used)
+}
+
+func TestAsyncReaderBuffered(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 100)
+	ar, err := New(ioutil.NopCloser(bytes.NewBuffer(data)), 4)
+	require.NoError(t, err)
+
+	// Wait for the data to be read ahead
+	var buffered, capacity int64
+	for i := 0; i < 100; i++ {
+		buffered, capacity = ar.Buffered()
+		if buffered == 100 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(100), buffered)
+	assert.Equal(t, int64(4*BufferSize), capacity)
+
+	n, err := ar.Read(make([]byte, 30))
+	require.NoError(t, err)
+	assert.Equal(t, 30, n)
+	buffered, _ = ar.Buffered()
+	assert.Equal(t, int64(70), buffered)
+
+	require.NoError(t, ar.Close())
+	buffered, _ = ar.Buffered()
+	assert.Equal(t, int64(0), buffered)
+}
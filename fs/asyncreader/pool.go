@@ -0,0 +1,126 @@
+package asyncreader
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorMemoryLimit is returned by New and NewGrowable if no buffers
+// can be had without going over the limit set with SetMemoryLimit
+var ErrorMemoryLimit = errors.New("async buffer memory limit reached")
+
+// bufferPool hands out the buffers for all the AsyncReaders keeping
+// the memory used by them under a global limit
+type bufferPool struct {
+	mu      sync.Mutex
+	limited bool      // set if there is a memory limit
+	limit   int       // max number of buffers which may be reserved
+	inUse   int       // number of buffers reserved
+	free    []*buffer // buffers kept for reuse if limited
+	pool    sync.Pool // buffers kept for reuse if not limited
+}
+
+// pool is the global bufferPool
+var pool = newBufferPool()
+
+// newBufferPool makes a new unlimited bufferPool
+func newBufferPool() *bufferPool {
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() interface{} { return newBuffer() },
+		},
+	}
+}
+
+// SetMemoryLimit sets the maximum amount of memory the buffers of all
+// the AsyncReaders may use.  0 or less means no limit.
+//
+// Buffers already in use aren't taken back if the limit is lowered.
+func SetMemoryLimit(bytes int64) {
+	pool.setLimit(bytes)
+}
+
+// MemoryUsed returns the memory in use by the buffers of all the
+// AsyncReaders and the limit set with SetMemoryLimit, which is 0 if
+// there isn't one.
+func MemoryUsed() (used, limit int64) {
+	return pool.used()
+}
+
+// setLimit does the work for SetMemoryLimit
+func (p *bufferPool) setLimit(bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limited = bytes > 0
+	p.limit = int(bytes / BufferSize)
+	if !p.limited {
+		p.free = nil
+	} else if len(p.free) > p.limit {
+		p.free = p.free[:p.limit]
+	}
+}
+
+// used does the work for MemoryUsed
+func (p *bufferPool) used() (used, limit int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	used = int64(p.inUse) * BufferSize
+	if p.limited {
+		limit = int64(p.limit) * BufferSize
+	}
+	return used, limit
+}
+
+// reserve reserves up to n buffers returning the number reserved,
+// which may be less than n (or 0) if the memory limit is reached.
+func (p *bufferPool) reserve(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limited {
+		if left := p.limit - p.inUse; n > left {
+			n = left
+		}
+		if n < 0 {
+			n = 0
+		}
+	}
+	p.inUse += n
+	return n
+}
+
+// release returns n reserved buffers to the pool
+func (p *bufferPool) release(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inUse -= n
+}
+
+// get gets a buffer, reusing a freed one if possible
+func (p *bufferPool) get() *buffer {
+	p.mu.Lock()
+	if n := len(p.free); n > 0 {
+		b := p.free[n-1]
+		p.free = p.free[:n-1]
+		p.mu.Unlock()
+		return b
+	}
+	p.mu.Unlock()
+	return p.pool.Get().(*buffer)
+}
+
+// put clears the buffer and keeps it for reuse
+//
+// If there is a memory limit then up to that many buffers are kept
+// on the free list so they survive garbage collection.
+func (p *bufferPool) put(b *buffer) {
+	b.clear()
+	p.mu.Lock()
+	if p.limited && len(p.free) < p.limit {
+		p.free = append(p.free, b)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.pool.Put(b)
+}
@@ -0,0 +1,90 @@
+package asyncreader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPoolReserve(t *testing.T) {
+	p := newBufferPool()
+
+	// Unlimited
+	assert.Equal(t, 100, p.reserve(100))
+	used, limit := p.used()
+	assert.Equal(t, int64(100*BufferSize), used)
+	assert.Equal(t, int64(0), limit)
+	p.release(100)
+
+	// Limited
+	p.setLimit(3*BufferSize + 1)
+	assert.Equal(t, 2, p.reserve(2))
+	assert.Equal(t, 1, p.reserve(2))
+	assert.Equal(t, 0, p.reserve(1))
+	used, limit = p.used()
+	assert.Equal(t, int64(3*BufferSize), used)
+	assert.Equal(t, int64(3*BufferSize), limit)
+
+	// Lowering the limit doesn't take buffers back
+	p.setLimit(BufferSize)
+	assert.Equal(t, 0, p.reserve(1))
+	p.release(3)
+	assert.Equal(t, 1, p.reserve(2))
+}
+
+func TestBufferPoolReuse(t *testing.T) {
+	p := newBufferPool()
+	p.setLimit(2 * BufferSize)
+
+	b1, b2, b3 := p.get(), p.get(), p.get()
+	b1.buf = b1.buf[:10]
+	p.put(b1)
+	p.put(b2)
+	p.put(b3)
+
+	// Only up to the limit are kept on the free list
+	assert.Equal(t, 2, len(p.free))
+	b := p.get()
+	assert.True(t, b == b2)
+	b = p.get()
+	assert.True(t, b == b1)
+	assert.Equal(t, BufferSize, len(b.buf))
+}
+
+func TestAsyncReaderMemoryLimit(t *testing.T) {
+	defer SetMemoryLimit(0)
+	SetMemoryLimit(3 * BufferSize)
+
+	data := []byte("Testbuffer")
+	ar1, err := New(ioutil.NopCloser(bytes.NewBuffer(data)), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ar1.Buffers())
+
+	// Gets fewer buffers than asked for
+	ar2, err := NewGrowable(ioutil.NopCloser(bytes.NewBuffer(data)), 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ar2.Buffers())
+	assert.Equal(t, 1, ar2.SetBuffers(4))
+
+	// Gets none
+	_, err = New(ioutil.NopCloser(bytes.NewBuffer(data)), 1)
+	assert.Equal(t, ErrorMemoryLimit, err)
+
+	used, limit := MemoryUsed()
+	assert.Equal(t, int64(3*BufferSize), used)
+	assert.Equal(t, int64(3*BufferSize), limit)
+
+	// Closing gives the buffers back
+	got, err := ioutil.ReadAll(ar1)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	require.NoError(t, ar1.Close())
+	assert.Equal(t, 3, ar2.SetBuffers(4))
+	require.NoError(t, ar2.Close())
+
+	used, _ = MemoryUsed()
+	assert.Equal(t, int64(0), used)
+}
@@ -11,18 +11,116 @@ import (
 
 // BwTimeSlot represents a bandwidth configuration at a point in time.
 type BwTimeSlot struct {
+	Days      Weekdays // days this slot applies to - 0 for every day
 	HHMM      int
 	Bandwidth SizeSuffix
 }
 
+// Weekdays is a set of days of the week with bit n set for
+// time.Weekday(n)
+type Weekdays uint8
+
+// weekdayNames are the short names of the days indexed by time.Weekday
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// parseWeekday parses a short day name, eg "Mon"
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, errors.Errorf("invalid day of the week: %q", s)
+}
+
+// parseWeekdays parses a day, eg "Mon", or an inclusive range of
+// days, eg "Mon-Fri", which may wrap around the end of the week, eg
+// "Fri-Mon"
+func parseWeekdays(s string) (Weekdays, error) {
+	from, to := s, s
+	if i := strings.IndexRune(s, '-'); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	start, err := parseWeekday(from)
+	if err != nil {
+		return 0, err
+	}
+	end, err := parseWeekday(to)
+	if err != nil {
+		return 0, err
+	}
+	var days Weekdays
+	for day := start; ; day = (day + 1) % 7 {
+		days |= 1 << uint(day)
+		if day == end {
+			break
+		}
+	}
+	return days, nil
+}
+
+// Contains returns true if day is in the set.  An empty set contains
+// every day.
+func (d Weekdays) Contains(day time.Weekday) bool {
+	return d == 0 || d&(1<<uint(day)) != 0
+}
+
+// ranges returns the days in the set as the ranges of days which
+// parseWeekdays accepts, eg ["Mon-Fri", "Sun"], in the order of the
+// first day of each range.  A range may wrap around the end of the
+// week, eg "Fri-Mon".
+func (d Weekdays) ranges() (out []string) {
+	if d == 0 {
+		return nil
+	}
+	if d == 1<<7-1 {
+		return []string{weekdayNames[time.Sunday] + "-" + weekdayNames[time.Saturday]}
+	}
+	has := func(day time.Weekday) bool {
+		return d&(1<<uint(day%7)) != 0
+	}
+	for start := time.Sunday; start <= time.Saturday; start++ {
+		if !has(start) || has(start+6) {
+			continue
+		}
+		end := start
+		for has(end + 1) {
+			end++
+		}
+		name := weekdayNames[start]
+		if end != start {
+			name += "-" + weekdayNames[end%7]
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// String returns a printable representation of the Weekdays as
+// ranges of days separated by commas, eg "Mon-Fri" or "Mon,Wed-Thu"
+func (d Weekdays) String() string {
+	return strings.Join(d.ranges(), ",")
+}
+
 // BwTimetable contains all configured time slots.
 type BwTimetable []BwTimeSlot
 
-// String returns a printable representation of BwTimetable.
+// String returns a printable representation of BwTimetable in the
+// format accepted by Set.
+//
+// A slot for days which aren't a single range of days is shown as a
+// slot for each range.
 func (x BwTimetable) String() string {
 	ret := []string{}
 	for _, ts := range x {
-		ret = append(ret, fmt.Sprintf("%04.4d,%s", ts.HHMM, ts.Bandwidth.String()))
+		slot := fmt.Sprintf("%02d:%02d,%s", ts.HHMM/100, ts.HHMM%100, ts.Bandwidth.String())
+		days := ts.Days.ranges()
+		if len(days) == 0 {
+			ret = append(ret, slot)
+		}
+		for _, day := range days {
+			ret = append(ret, day+"-"+slot)
+		}
 	}
 	return strings.Join(ret, " ")
 }
@@ -31,6 +129,11 @@ func (x BwTimetable) String() string {
 func (x *BwTimetable) Set(s string) error {
 	// The timetable is formatted as:
 	// "hh:mm,bandwidth hh:mm,banwidth..." ex: "10:00,10G 11:30,1G 18:00,off"
+	// Each time may be prefixed with a day of the week or a range of
+	// days to make it only apply on those days, ex:
+	// "Mon-08:00,512 Sat-00:00,off" or "Mon-Fri-08:00,1M"
+	// A day or range of days on its own applies to all the times
+	// which follow it, ex: "Mon-Fri 08:00,1M 20:00,off"
 	// If only a single bandwidth identifier is provided, we assume constant bandwidth.
 
 	if len(s) == 0 {
@@ -47,7 +150,23 @@ func (x *BwTimetable) Set(s string) error {
 		return nil
 	}
 
+	var (
+		tt          BwTimetable
+		defaultDays Weekdays // days set by the last day only token
+		needSlot    bool     // set if a day only token needs a time slot
+	)
 	for _, tok := range strings.Split(s, " ") {
+		// Day or range of days for the slots which follow
+		if !strings.Contains(tok, ",") {
+			days, err := parseWeekdays(tok)
+			if err != nil {
+				return errors.Errorf("invalid time/bandwidth specification: %q", tok)
+			}
+			defaultDays = days
+			needSlot = true
+			continue
+		}
+		needSlot = false
 		tv := strings.Split(tok, ",")
 
 		// Format must be HH:MM,BW
@@ -55,8 +174,19 @@ func (x *BwTimetable) Set(s string) error {
 			return errors.Errorf("invalid time/bandwidth specification: %q", tok)
 		}
 
-		// Basic timespec sanity checking
+		// Optional day of the week prefix
 		HHMM := tv[0]
+		days := defaultDays
+		if i := strings.LastIndex(HHMM, "-"); i >= 0 {
+			var err error
+			days, err = parseWeekdays(HHMM[:i])
+			if err != nil {
+				return err
+			}
+			HHMM = HHMM[i+1:]
+		}
+
+		// Basic timespec sanity checking
 		if len(HHMM) != 5 {
 			return errors.Errorf("invalid time specification (hh:mm): %q", HHMM)
 		}
@@ -76,14 +206,19 @@ func (x *BwTimetable) Set(s string) error {
 		}
 
 		ts := BwTimeSlot{
+			Days: days,
 			HHMM: (hh * 100) + mm,
 		}
 		// Bandwidth limit for this time slot.
 		if err := ts.Bandwidth.Set(tv[1]); err != nil {
 			return err
 		}
-		*x = append(*x, ts)
+		tt = append(tt, ts)
+	}
+	if needSlot {
+		return errors.New("days must be followed by a time/bandwidth specification")
 	}
+	*x = append(*x, tt...)
 	return nil
 }
 
@@ -94,7 +229,9 @@ func (x BwTimetable) LimitAt(tt time.Time) BwTimeSlot {
 		return BwTimeSlot{HHMM: 0, Bandwidth: -1}
 	}
 
-	HHMM := tt.Hour()*100 + tt.Minute()
+	const minutesPerDay = 24 * 60
+	const minutesPerWeek = 7 * minutesPerDay
+	now := int(tt.Weekday())*minutesPerDay + tt.Hour()*60 + tt.Minute()
 
 	// By default, we return the last element in the timetable. This
 	// satisfies two conditions: 1) If there's only one element it
@@ -103,23 +240,20 @@ func (x BwTimetable) LimitAt(tt time.Time) BwTimeSlot {
 	// there's only one time slot in the timetable.
 	ret := x[len(x)-1]
 
-	mindif := 0
-	first := true
+	mindif := minutesPerWeek
 
-	// Look for most recent time slot.
+	// Look for most recent time slot going back up to a week.
 	for _, ts := range x {
-		// Ignore the past
-		if HHMM < ts.HHMM {
-			continue
-		}
-		dif := ((HHMM / 100 * 60) + (HHMM % 100)) - ((ts.HHMM / 100 * 60) + (ts.HHMM % 100))
-		if first {
-			mindif = dif
-			first = false
-		}
-		if dif <= mindif {
-			mindif = dif
-			ret = ts
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if !ts.Days.Contains(day) {
+				continue
+			}
+			start := int(day)*minutesPerDay + (ts.HHMM/100)*60 + ts.HHMM%100
+			dif := (now - start + minutesPerWeek) % minutesPerWeek
+			if dif <= mindif {
+				mindif = dif
+				ret = ts
+			}
 		}
 	}
 
@@ -32,6 +32,27 @@ func TestBwTimetableSet(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"Mon-08:00,512 Sat-00:00,off 23:00,1M",
+			BwTimetable{
+				BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+				BwTimeSlot{Days: 1 << uint(time.Saturday), HHMM: 0, Bandwidth: -1},
+				BwTimeSlot{HHMM: 2300, Bandwidth: 1024 * 1024},
+			},
+			false,
+		},
+		{
+			"Mon-Fri 08:00,1M 20:00,off Sat-Sun-10:00,512",
+			BwTimetable{
+				BwTimeSlot{Days: 0x3e, HHMM: 800, Bandwidth: 1024 * 1024},
+				BwTimeSlot{Days: 0x3e, HHMM: 2000, Bandwidth: -1},
+				BwTimeSlot{Days: 1<<uint(time.Saturday) | 1<<uint(time.Sunday), HHMM: 1000, Bandwidth: 512 * 1024},
+			},
+			false,
+		},
+		{"Xyz-08:00,512", BwTimetable{}, true},
+		{"Mon-Xyz 08:00,512", BwTimetable{}, true},
+		{"08:00,512 Mon-Fri", BwTimetable{}, true},
 		{"bad,bad", BwTimetable{}, true},
 		{"bad bad", BwTimetable{}, true},
 		{"bad", BwTimetable{}, true},
@@ -106,8 +127,102 @@ func TestBwTimetableLimitAt(t *testing.T) {
 			time.Date(2017, time.April, 20, 23, 59, 0, 0, time.UTC),
 			BwTimeSlot{HHMM: 2350, Bandwidth: -1},
 		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+				BwTimeSlot{Days: 1 << uint(time.Saturday), HHMM: 0, Bandwidth: -1},
+			},
+			// Thursday
+			time.Date(2017, time.April, 20, 15, 0, 0, 0, time.UTC),
+			BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+				BwTimeSlot{Days: 1 << uint(time.Saturday), HHMM: 0, Bandwidth: -1},
+			},
+			// Sunday
+			time.Date(2017, time.April, 23, 15, 0, 0, 0, time.UTC),
+			BwTimeSlot{Days: 1 << uint(time.Saturday), HHMM: 0, Bandwidth: -1},
+		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+				BwTimeSlot{HHMM: 1800, Bandwidth: 1024 * 1024},
+			},
+			// Monday after the Monday slot
+			time.Date(2017, time.April, 24, 9, 0, 0, 0, time.UTC),
+			BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 1 << uint(time.Monday), HHMM: 800, Bandwidth: 512 * 1024},
+				BwTimeSlot{HHMM: 1800, Bandwidth: 1024 * 1024},
+			},
+			// Tuesday morning
+			time.Date(2017, time.April, 25, 9, 0, 0, 0, time.UTC),
+			BwTimeSlot{HHMM: 1800, Bandwidth: 1024 * 1024},
+		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 0x3e, HHMM: 800, Bandwidth: 1024 * 1024},
+				BwTimeSlot{Days: 0x3e, HHMM: 2000, Bandwidth: -1},
+			},
+			// Wednesday during business hours
+			time.Date(2017, time.April, 26, 12, 0, 0, 0, time.UTC),
+			BwTimeSlot{Days: 0x3e, HHMM: 800, Bandwidth: 1024 * 1024},
+		},
+		{
+			BwTimetable{
+				BwTimeSlot{Days: 0x3e, HHMM: 800, Bandwidth: 1024 * 1024},
+				BwTimeSlot{Days: 0x3e, HHMM: 2000, Bandwidth: -1},
+			},
+			// Saturday is still off from Friday evening
+			time.Date(2017, time.April, 22, 12, 0, 0, 0, time.UTC),
+			BwTimeSlot{Days: 0x3e, HHMM: 2000, Bandwidth: -1},
+		},
 	} {
 		slot := test.tt.LimitAt(test.now)
 		assert.Equal(t, test.want, slot)
 	}
 }
+
+func TestBwTimetableString(t *testing.T) {
+	tt := BwTimetable{}
+	require.NoError(t, tt.Set("Mon-08:00,512 23:00,off"))
+	assert.Equal(t, "Mon-08:00,512k 23:00,off", tt.String())
+
+	// The string can be parsed back into the same timetable
+	for _, in := range []string{
+		"10:00,10G 11:30,1G 18:00,off",
+		"Mon-08:00,512 Sat-00:00,off",
+		"Mon-Fri-08:00,1M 20:00,off",
+		"Fri-Mon-08:00,1M",
+		"Sun-Sat-08:00,1M",
+		"Mon-Fri 08:00,1M 20:00,off",
+		"1M",
+	} {
+		tt := BwTimetable{}
+		require.NoError(t, tt.Set(in), in)
+		var got BwTimetable
+		require.NoError(t, got.Set(tt.String()), tt.String())
+		assert.Equal(t, tt, got, in)
+	}
+	tt = BwTimetable{}
+	require.NoError(t, tt.Set("Mon-Fri-08:00,1M"))
+	assert.Equal(t, "Mon-Fri-08:00,1M", tt.String())
+	tt = BwTimetable{}
+	require.NoError(t, tt.Set("Fri-Mon-08:00,1M"))
+	assert.Equal(t, "Fri-Mon-08:00,1M", tt.String())
+
+	// Days which aren't a range are shown as a slot for each range
+	tt = BwTimetable{{Days: 1<<time.Monday | 1<<time.Wednesday | 1<<time.Thursday, HHMM: 800, Bandwidth: 1024 * 1024}}
+	assert.Equal(t, "Mon,Wed-Thu", tt[0].Days.String())
+	assert.Equal(t, "Mon-08:00,1M Wed-Thu-08:00,1M", tt.String())
+	var got BwTimetable
+	require.NoError(t, got.Set(tt.String()))
+	assert.Equal(t, BwTimetable{
+		{Days: 1 << time.Monday, HHMM: 800, Bandwidth: 1024 * 1024},
+		{Days: 1<<time.Wednesday | 1<<time.Thursday, HHMM: 800, Bandwidth: 1024 * 1024},
+	}, got)
+}
@@ -55,11 +55,19 @@ type ConfigInfo struct {
 	IgnoreChecksum        bool
 	NoUpdateModTime       bool
 	DataRateUnit          string
+	SizeUnit              string // multipliers of the sizes in the stats - binary or si
 	BackupDir             string
 	Suffix                string
 	UseListR              bool
 	BufferSize            SizeSuffix
+	BufferAuto            bool       // tune the buffers by how full they are
+	BufferMemory          SizeSuffix // max memory for the buffers of all transfers
 	BwLimit               BwTimetable
+	BwLimitPercent        int                   // limit to this percentage of the measured bandwidth - 0 for off
+	BwLimitRemote         map[string]SizeSuffix // bandwidth limits for the transfers to each remote
+	BwLimitChecks         bool                  // apply the bandwidth limits to the reads for checks too
+	BwLimitBurst          SizeSuffix            // max burst above the bandwidth limits - 0 for a second's worth
+	BwLimitRamp           time.Duration         // time to ramp up to the bandwidth limit after being idle - 0 for none
 	TPSLimit              float64
 	TPSLimitBurst         int
 	BindAddr              net.IP
@@ -69,6 +77,25 @@ type ConfigInfo struct {
 	AutoConfirm           bool
 	StreamingUploadCutoff SizeSuffix
 	StatsFileNameLength   int
+	StatsFileNameMode     string        // which part of long names to cut - left, right or middle
+	StatsAvgWindow        time.Duration // window for the moving average speed - 0 for default
+	StatsShowAvgSpeed     bool          // show the average speed since the start as well as the current speed
+	StatsShowDoneTime     bool          // show the time transfers are expected to be done at with the ETA
+	StatsLight            bool          // don't keep moving averages of the speed
+	StatsSpeedHistory     int           // number of speed samples kept for each transfer
+	StatsSmallFiles       SizeSuffix    // show transfers smaller than this as one total in stats - 0 for off
+	StatsSort             string        // order of the transfers in progress - start, name, remaining or speed
+	StatsReadLatency      bool          // time each read of the transfers for Account.ReadLatencies
+	StatsOneLine          bool          // show the main stats on one line without the transfers in progress
+	StatsOneLineDate      bool          // prefix the one line stats with the date and time
+	StatsETAResolution    time.Duration // round ETAs over a minute to this - 0 for whole seconds
+	StatsPercentDecimals  int           // decimal places of the percentage done of big transfers in stats
+	StatsDecimalsSize     SizeSuffix    // min size of transfer to use StatsPercentDecimals for
+	MinSpeed              SizeSuffix    // abort transfers slower than this - 0 for off
+	MinSpeedTime          time.Duration // for this long
+	MaxTransfer           SizeSuffix    // stop transferring after this many bytes - off if < 0
+	MaxTransferMode       string        // what to do when MaxTransfer is reached - hard or soft
+	TransferLog           string        // file to write a JSON line per finished transfer to
 	AskPassword           bool
 	UseServerModTime      bool
 }
@@ -92,12 +119,21 @@ func NewConfig() *ConfigInfo {
 	c.LowLevelRetries = 10
 	c.MaxDepth = -1
 	c.DataRateUnit = "bytes"
+	c.SizeUnit = "binary"
 	c.BufferSize = SizeSuffix(16 << 20)
 	c.UserAgent = "rclone/" + Version
 	c.StreamingUploadCutoff = SizeSuffix(100 * 1024)
 	c.StatsFileNameLength = 40
+	c.StatsFileNameMode = "left"
+	c.StatsSort = "start"
 	c.AskPassword = true
 	c.TPSLimitBurst = 1
+	c.MinSpeedTime = time.Minute
+	c.MaxTransfer = -1
+	c.MaxTransferMode = "hard"
+	c.StatsSpeedHistory = 60
+	c.StatsPercentDecimals = 1
+	c.StatsDecimalsSize = SizeSuffix(10 << 30)
 
 	return c
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/Unknwon/goconfig"
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/rclone/fs/accounting"
+	"github.com/ncw/rclone/fs/asyncreader"
 	"github.com/ncw/rclone/fs/config/obscure"
 	"github.com/ncw/rclone/fs/driveletter"
 	"github.com/ncw/rclone/fs/fshttp"
@@ -176,6 +177,17 @@ func LoadConfig() {
 	// Start the bandwidth update ticker
 	accounting.StartTokenTicker()
 
+	// Start measuring the bandwidth for --bwlimit-percent
+	accounting.StartBandwidthProber()
+
+	// Start the transfer log for --transfer-log
+	if err := accounting.StartTransferLog(); err != nil {
+		log.Fatalf("Failed to start transfer log: %v", err)
+	}
+
+	// Limit the memory used by the transfer buffers
+	asyncreader.SetMemoryLimit(int64(fs.Config.BufferMemory))
+
 	// Start the transactions per second limiter
 	fshttp.StartHTTPTokenBucket()
 }
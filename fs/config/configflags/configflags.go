@@ -27,6 +27,7 @@ var (
 	bindAddr        string
 	disableFeatures string
 	noTraverse      bool
+	bwLimitRemote   []string
 )
 
 // AddFlags adds the non filing system specific flags to the command
@@ -76,10 +77,37 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &fs.Config.Immutable, "immutable", "", fs.Config.Immutable, "Do not modify files. Fail if existing files have been modified.")
 	flags.BoolVarP(flagSet, &fs.Config.AutoConfirm, "auto-confirm", "", fs.Config.AutoConfirm, "If enabled, do not request console confirmation.")
 	flags.IntVarP(flagSet, &fs.Config.StatsFileNameLength, "stats-file-name-length", "", fs.Config.StatsFileNameLength, "Max file name length in stats. 0 for no limit")
+	flags.StringVarP(flagSet, &fs.Config.StatsFileNameMode, "stats-file-name-mode", "", fs.Config.StatsFileNameMode, "Which part of long file names to cut in stats: left, right or middle.")
+	flags.DurationVarP(flagSet, &fs.Config.StatsAvgWindow, "stats-avg-window", "", fs.Config.StatsAvgWindow, "Time window for averaging the current speed and ETA in stats. 0 for default (30s)")
+	flags.BoolVarP(flagSet, &fs.Config.StatsLight, "stats-light", "", fs.Config.StatsLight, "Use less CPU and memory for stats by only showing speeds averaged from the start of each transfer.")
+	flags.BoolVarP(flagSet, &fs.Config.StatsShowAvgSpeed, "stats-show-avg-speed", "", fs.Config.StatsShowAvgSpeed, "Show the average speed since the start of each transfer in stats as well as the current speed.")
+	flags.BoolVarP(flagSet, &fs.Config.StatsShowDoneTime, "stats-show-done-time", "", fs.Config.StatsShowDoneTime, "Show the local time transfers are expected to be done at with the ETA in stats.")
+	flags.StringVarP(flagSet, &fs.Config.SizeUnit, "size-unit", "", fs.Config.SizeUnit, "Show sizes and speeds in stats with binary (1k = 1024) or si (1k = 1000) multipliers.")
+	flags.IntVarP(flagSet, &fs.Config.StatsSpeedHistory, "stats-speed-history", "", fs.Config.StatsSpeedHistory, "Number of speed samples to keep for each transfer. 0 for none.")
+	flags.DurationVarP(flagSet, &fs.Config.StatsETAResolution, "stats-eta-resolution", "", fs.Config.StatsETAResolution, "Round ETAs over a minute in stats to this, eg 10s or 1m. 0 for whole seconds.")
+	flags.IntVarP(flagSet, &fs.Config.StatsPercentDecimals, "stats-percent-decimals", "", fs.Config.StatsPercentDecimals, "Decimal places of the percentage done of big transfers in stats, 0 to 3.")
+	flags.FVarP(flagSet, &fs.Config.StatsDecimalsSize, "stats-percent-decimals-size", "", "Show --stats-percent-decimals for transfers at least this big.")
+	flags.FVarP(flagSet, &fs.Config.StatsSmallFiles, "stats-small-files", "", "Show transfers of files smaller than this as one total in stats. 0 for off.")
+	flags.StringVarP(flagSet, &fs.Config.StatsSort, "stats-sort", "", fs.Config.StatsSort, "Order of the transfers in progress in stats: start, name, remaining or speed.")
+	flags.BoolVarP(flagSet, &fs.Config.StatsOneLine, "stats-one-line", "", fs.Config.StatsOneLine, "Make the stats fit on one line. SIGUSR1 shows them all.")
+	flags.BoolVarP(flagSet, &fs.Config.StatsOneLineDate, "stats-one-line-date", "", fs.Config.StatsOneLineDate, "Prefix the one line stats with the date and time in RFC3339 format.")
+	flags.BoolVarP(flagSet, &fs.Config.StatsReadLatency, "stats-read-latency", "", fs.Config.StatsReadLatency, "Time each read of the transfers to find the read latency percentiles.")
 	flags.FVarP(flagSet, &fs.Config.LogLevel, "log-level", "", "Log level DEBUG|INFO|NOTICE|ERROR")
 	flags.FVarP(flagSet, &fs.Config.StatsLogLevel, "stats-log-level", "", "Log level to show --stats output DEBUG|INFO|NOTICE|ERROR")
 	flags.FVarP(flagSet, &fs.Config.BwLimit, "bwlimit", "", "Bandwidth limit in kBytes/s, or use suffix b|k|M|G or a full timetable.")
+	flags.StringArrayVarP(flagSet, &bwLimitRemote, "bwlimit-remote", "", nil, "Bandwidth limit for transfers to a remote as remote=BANDWIDTH. Can be repeated.")
+	flags.IntVarP(flagSet, &fs.Config.BwLimitPercent, "bwlimit-percent", "", fs.Config.BwLimitPercent, "Bandwidth limit as a percentage of the measured bandwidth. 0 for off.")
+	flags.BoolVarP(flagSet, &fs.Config.BwLimitChecks, "bwlimit-checks", "", fs.Config.BwLimitChecks, "Apply the bandwidth limits to data read for checks, eg hashing, too.")
+	flags.FVarP(flagSet, &fs.Config.BwLimitBurst, "bwlimit-burst", "", "Max burst above the bandwidth limits, at least 1M. 0 for a second's worth of the limit.")
+	flags.DurationVarP(flagSet, &fs.Config.BwLimitRamp, "bwlimit-ramp", "", fs.Config.BwLimitRamp, "Ramp up to the bandwidth limit over this long when transfers start.")
 	flags.FVarP(flagSet, &fs.Config.BufferSize, "buffer-size", "", "Buffer size when copying files.")
+	flags.BoolVarP(flagSet, &fs.Config.BufferAuto, "buffer-auto", "", fs.Config.BufferAuto, "Grow or shrink the buffer of each transfer by how full it is, up to 4 times --buffer-size.")
+	flags.FVarP(flagSet, &fs.Config.BufferMemory, "buffer-memory", "", "Max memory used by the buffers of all transfers. 0 for unlimited.")
+	flags.FVarP(flagSet, &fs.Config.MinSpeed, "min-speed", "", "Abort and retry transfers slower than this for --min-speed-time. 0 for off.")
+	flags.DurationVarP(flagSet, &fs.Config.MinSpeedTime, "min-speed-time", "", fs.Config.MinSpeedTime, "Time a transfer must be slower than --min-speed for to be aborted.")
+	flags.FVarP(flagSet, &fs.Config.MaxTransfer, "max-transfer", "", "Maximum size of data to transfer.")
+	flags.StringVarP(flagSet, &fs.Config.MaxTransferMode, "max-transfer-mode", "", fs.Config.MaxTransferMode, "What to do when --max-transfer is reached: hard or soft.")
+	flags.StringVarP(flagSet, &fs.Config.TransferLog, "transfer-log", "", fs.Config.TransferLog, "Append a JSON line for each finished transfer to this file.")
 	flags.FVarP(flagSet, &fs.Config.StreamingUploadCutoff, "streaming-upload-cutoff", "", "Cutoff for switching to chunked upload if file size is unknown. Upload starts after reaching cutoff or when file ends.")
 	flags.FVarP(flagSet, &fs.Config.Dump, "dump", "", "List of items to dump from: "+fs.DumpFlagsList)
 
@@ -139,6 +167,48 @@ func SetFlags() {
 		log.Fatalf(`Can't use --size-only and --ignore-size together.`)
 	}
 
+	switch fs.Config.StatsFileNameMode {
+	case "left", "right", "middle":
+	default:
+		log.Fatalf(`--stats-file-name-mode must be one of left, right or middle.`)
+	}
+
+	switch fs.Config.StatsSort {
+	case "start", "name", "remaining", "speed":
+	default:
+		log.Fatalf(`--stats-sort must be one of start, name, remaining or speed.`)
+	}
+
+	if fs.Config.StatsPercentDecimals < 0 || fs.Config.StatsPercentDecimals > 3 {
+		log.Fatalf(`--stats-percent-decimals must be between 0 and 3.`)
+	}
+
+	switch fs.Config.SizeUnit {
+	case "binary", "si":
+	default:
+		log.Fatalf(`--size-unit must be one of binary or si.`)
+	}
+
+	switch fs.Config.MaxTransferMode {
+	case "hard", "soft":
+	default:
+		log.Fatalf(`--max-transfer-mode must be one of hard or soft.`)
+	}
+
+	if fs.Config.BwLimitPercent < 0 || fs.Config.BwLimitPercent > 100 {
+		log.Fatalf(`--bwlimit-percent must be between 0 and 100.`)
+	}
+	if fs.Config.BwLimitRamp < 0 {
+		log.Fatalf(`--bwlimit-ramp can't be negative.`)
+	}
+	if fs.Config.BwLimitBurst != 0 && fs.Config.BwLimitBurst < 1024*1024 {
+		// The burst must be bigger than the biggest read
+		log.Fatalf(`--bwlimit-burst must be at least 1M.`)
+	}
+	if fs.Config.BwLimitPercent > 0 && len(fs.Config.BwLimit) > 0 {
+		log.Fatalf(`Can't use --bwlimit and --bwlimit-percent together.`)
+	}
+
 	if fs.Config.Suffix != "" && fs.Config.BackupDir == "" {
 		log.Fatalf(`Can only use --suffix with --backup-dir.`)
 	}
@@ -154,6 +224,21 @@ func SetFlags() {
 		fs.Config.BindAddr = addrs[0]
 	}
 
+	if len(bwLimitRemote) > 0 {
+		fs.Config.BwLimitRemote = make(map[string]fs.SizeSuffix, len(bwLimitRemote))
+		for _, limit := range bwLimitRemote {
+			i := strings.LastIndex(limit, "=")
+			if i <= 0 {
+				log.Fatalf("--bwlimit-remote: Expecting remote=BANDWIDTH but got %q", limit)
+			}
+			var bandwidth fs.SizeSuffix
+			if err := bandwidth.Set(limit[i+1:]); err != nil {
+				log.Fatalf("--bwlimit-remote: Failed to parse bandwidth in %q: %v", limit, err)
+			}
+			fs.Config.BwLimitRemote[limit[:i]] = bandwidth
+		}
+	}
+
 	if disableFeatures != "" {
 		if disableFeatures == "help" {
 			log.Fatalf("Possible backend features are: %s\n", strings.Join(new(fs.Features).List(), ", "))
@@ -268,6 +268,9 @@ func Copy(f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Objec
 			newDst, err = doCopy(src, remote)
 			if err == nil {
 				dst = newDst
+				if size := src.Size(); size > 0 {
+					accounting.Stats.ServerSideBytes(size)
+				}
 			}
 		} else {
 			err = fs.ErrorCantCopy
@@ -279,7 +282,7 @@ func Copy(f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Objec
 			if err != nil {
 				err = errors.Wrap(err, "failed to open source object")
 			} else {
-				in := accounting.NewAccount(in0, src).WithBuffer() // account and buffer the transfer
+				in := accounting.NewAccount(in0, src).WithBuffer().WithDirection(accounting.TransferDirection(src.Fs(), f)).WithBwLimitRemote(f.Name()) // account and buffer the transfer
 				var wrappedSrc fs.ObjectInfo = src
 				// We try to pass the original object if possible
 				if src.Remote() != remote {
@@ -292,6 +295,15 @@ func Copy(f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Objec
 					actionTaken = "Copied (new)"
 					dst, err = f.Put(in, wrappedSrc, hashOption)
 				}
+				if err != nil {
+					// Return why the transfer was aborted
+					// rather than whatever the closed stream
+					// caused, so it isn't retried needlessly
+					if abortErr := in.Err(); abortErr != nil {
+						err = abortErr
+					}
+					in.Finish(err)
+				}
 				closeErr := in.Close()
 				if err == nil {
 					newDst = dst
@@ -306,6 +318,7 @@ func Copy(f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Objec
 		// Retry if err returned a retry error
 		if fserrors.IsRetryError(err) || fserrors.ShouldRetry(err) {
 			fs.Debugf(src, "Received error: %v - low level retry %d/%d", err, tries, maxTries)
+			accounting.Stats.LowLevelRetry()
 			continue
 		}
 		// otherwise finish
@@ -754,14 +767,14 @@ func CheckIdentical(dst, src fs.Object) (differ bool, err error) {
 	if err != nil {
 		return true, errors.Wrapf(err, "failed to open %q", dst)
 	}
-	in1 = accounting.NewAccount(in1, dst).WithBuffer() // account and buffer the transfer
+	in1 = accounting.NewAccount(in1, dst).WithBuffer().WithDirection(accounting.TransferDirection(dst.Fs(), nil)) // account and buffer the transfer
 	defer fs.CheckClose(in1, &err)
 
 	in2, err := src.Open()
 	if err != nil {
 		return true, errors.Wrapf(err, "failed to open %q", src)
 	}
-	in2 = accounting.NewAccount(in2, src).WithBuffer() // account and buffer the transfer
+	in2 = accounting.NewAccount(in2, src).WithBuffer().WithDirection(accounting.TransferDirection(src.Fs(), nil)) // account and buffer the transfer
 	defer fs.CheckClose(in2, &err)
 
 	return CheckEqualReaders(in1, in2)
@@ -1097,7 +1110,7 @@ func Cat(f fs.Fs, w io.Writer, offset, count int64) error {
 				size = count
 			}
 		}
-		in = accounting.NewAccountSizeName(in, size, o.Remote()).WithBuffer() // account and buffer the transfer
+		in = accounting.NewAccountSizeName(in, size, o.Remote()).WithBuffer().WithDirection(accounting.TransferDirection(f, nil)) // account and buffer the transfer
 		defer func() {
 			err = in.Close()
 			if err != nil {
@@ -1119,7 +1132,7 @@ func Cat(f fs.Fs, w io.Writer, offset, count int64) error {
 // Rcat reads data from the Reader until EOF and uploads it to a file on remote
 func Rcat(fdst fs.Fs, dstFileName string, in io.ReadCloser, modTime time.Time) (dst fs.Object, err error) {
 	accounting.Stats.Transferring(dstFileName)
-	in = accounting.NewAccountSizeName(in, -1, dstFileName).WithBuffer()
+	in = accounting.NewAccountSizeName(in, -1, dstFileName).WithBuffer().WithDirection(accounting.TransferDirection(nil, fdst)).WithBwLimitRemote(fdst.Name())
 	defer func() {
 		accounting.Stats.DoneTransferring(dstFileName, err == nil)
 		if otherErr := in.Close(); otherErr != nil {
@@ -1273,7 +1286,8 @@ func Rmdirs(f fs.Fs, dir string, leaveRoot bool) error {
 // the current config.
 //
 // Returns a flag which indicates whether the file needs to be
-// transferred or not.
+// transferred or not.  Files which don't are counted as skipped in
+// the stats.
 func NeedTransfer(dst, src fs.Object) bool {
 	if dst == nil {
 		fs.Debugf(src, "Couldn't find file - need to transfer")
@@ -1282,6 +1296,7 @@ func NeedTransfer(dst, src fs.Object) bool {
 	// If we should ignore existing files, don't transfer
 	if fs.Config.IgnoreExisting {
 		fs.Debugf(src, "Destination exists, skipping")
+		accounting.Stats.AddSkipped(src.Size())
 		return false
 	}
 	// If we should upload unconditionally
@@ -1305,12 +1320,14 @@ func NeedTransfer(dst, src fs.Object) bool {
 		switch {
 		case dt >= modifyWindow:
 			fs.Debugf(src, "Destination is newer than source, skipping")
+			accounting.Stats.AddSkipped(src.Size())
 			return false
 		case dt <= -modifyWindow:
 			fs.Debugf(src, "Destination is older than source, transferring")
 		default:
 			if src.Size() == dst.Size() {
 				fs.Debugf(src, "Destination mod time is within %v of source and sizes identical, skipping", modifyWindow)
+				accounting.Stats.AddSkipped(src.Size())
 				return false
 			}
 			fs.Debugf(src, "Destination mod time is within %v of source but sizes differ, transferring", modifyWindow)
@@ -1319,6 +1336,7 @@ func NeedTransfer(dst, src fs.Object) bool {
 		// Check to see if changed or not
 		if Equal(src, dst) {
 			fs.Debugf(src, "Unchanged skipping")
+			accounting.Stats.AddSkipped(src.Size())
 			return false
 		}
 	}
@@ -13,33 +13,29 @@ import (
 // SizeSuffix is an int64 with a friendly way of printing setting
 type SizeSuffix int64
 
-// Turn SizeSuffix into a string and a suffix
+// Turn SizeSuffix into a string and a suffix using binary (powers of
+// 1024) multipliers
 func (x SizeSuffix) string() (string, string) {
-	scaled := float64(0)
-	suffix := ""
+	return x.scaled(1 << 10)
+}
+
+// Turn SizeSuffix into a string and a suffix with the multiplier of
+// each suffix a power of base
+func (x SizeSuffix) scaled(base float64) (string, string) {
 	switch {
 	case x < 0:
 		return "off", ""
 	case x == 0:
 		return "0", ""
-	case x < 1<<10:
-		scaled = float64(x)
-		suffix = ""
-	case x < 1<<20:
-		scaled = float64(x) / (1 << 10)
-		suffix = "k"
-	case x < 1<<30:
-		scaled = float64(x) / (1 << 20)
-		suffix = "M"
-	case x < 1<<40:
-		scaled = float64(x) / (1 << 30)
-		suffix = "G"
-	case x < 1<<50:
-		scaled = float64(x) / (1 << 40)
-		suffix = "T"
-	default:
-		scaled = float64(x) / (1 << 50)
-		suffix = "P"
+	}
+	scaled := float64(x)
+	suffix := ""
+	for _, s := range []string{"k", "M", "G", "T", "P"} {
+		if scaled < base {
+			break
+		}
+		scaled /= base
+		suffix = s
 	}
 	if math.Floor(scaled) == scaled {
 		return fmt.Sprintf("%.0f", scaled), suffix
@@ -56,6 +52,25 @@ func (x SizeSuffix) String() string {
 // Unit turns SizeSuffix into a string with a unit
 func (x SizeSuffix) Unit(unit string) string {
 	val, suffix := x.string()
+	return withUnit(val, suffix, unit)
+}
+
+// SIString turns SizeSuffix into a string using SI (powers of 1000)
+// multipliers, so 1M is 1,000,000
+func (x SizeSuffix) SIString() string {
+	val, suffix := x.scaled(1000)
+	return val + suffix
+}
+
+// SIUnit turns SizeSuffix into a string with a unit using SI (powers
+// of 1000) multipliers, so 1 MBytes is 1,000,000 Bytes
+func (x SizeSuffix) SIUnit(unit string) string {
+	val, suffix := x.scaled(1000)
+	return withUnit(val, suffix, unit)
+}
+
+// withUnit joins val and suffix from string with unit
+func withUnit(val, suffix, unit string) string {
 	if val == "off" {
 		return val
 	}
@@ -93,3 +93,25 @@ func TestSizeSuffixSet(t *testing.T) {
 		assert.Equal(t, test.want, int64(ss))
 	}
 }
+
+func TestSizeSuffixSI(t *testing.T) {
+	for _, test := range []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1k"},
+		{1024, "1.024k"},
+		{1000 * 1000, "1M"},
+		{1024 * 1024, "1.049M"},
+		{10.1 * 1000 * 1000 * 1000, "10.100G"},
+		{1000 * 1000 * 1000 * 1000 * 1000 * 1000, "1000P"},
+		{-1, "off"},
+	} {
+		ss := SizeSuffix(test.in)
+		assert.Equal(t, test.want, ss.SIString())
+	}
+	assert.Equal(t, "1.500 MBytes", SizeSuffix(1500000).SIUnit("Bytes"))
+	assert.Equal(t, "off", SizeSuffix(-1).SIUnit("Bytes"))
+}
@@ -59,6 +59,9 @@ type syncCopyMove struct {
 	renameCheck    []fs.Object            // accumulate files to check for rename here
 	backupDir      fs.Fs                  // place to store overwrites/deletes
 	suffix         string                 // suffix to add to files placed in backupDir
+	totalsMu       sync.Mutex             // protect the totals below
+	totalBytes     int64                  // bytes queued for transfer
+	totalTransfers int64                  // files queued for transfer
 }
 
 func newSyncCopyMove(fdst, fsrc fs.Fs, deleteMode fs.DeleteMode, DoMove bool, deleteEmptySrcDirs bool) (*syncCopyMove, error) {
@@ -190,6 +193,38 @@ func (s *syncCopyMove) currentError() error {
 	return s.noRetryErr
 }
 
+// queueTransfer sends pair to out to be transferred and counts it in
+// the totals for the job.
+//
+// The totals are set in the stats as each pair is queued so the job
+// has an ETA while the checkers are still finding things to transfer.
+func (s *syncCopyMove) queueTransfer(out fs.ObjectPairChan, pair fs.ObjectPair) {
+	s.totalsMu.Lock()
+	if size := pair.Src.Size(); size > 0 {
+		s.totalBytes += size
+	}
+	s.totalTransfers++
+	s._setTotals()
+	s.totalsMu.Unlock()
+	out <- pair
+}
+
+// setTotals sets the totals for the job in the stats once everything
+// which needs transferring has been queued, so they are known even if
+// nothing was
+func (s *syncCopyMove) setTotals() {
+	s.totalsMu.Lock()
+	defer s.totalsMu.Unlock()
+	s._setTotals()
+}
+
+// _setTotals sets the totals for the job in the stats - call with
+// totalsMu held
+func (s *syncCopyMove) _setTotals() {
+	accounting.Stats.SetTotalBytes(s.totalBytes)
+	accounting.Stats.SetTotalTransfers(s.totalTransfers)
+}
+
 // pairChecker reads Objects~s on in send to out if they need transferring.
 //
 // FIXME potentially doing lots of hashes at once
@@ -224,10 +259,10 @@ func (s *syncCopyMove) pairChecker(in fs.ObjectPairChan, out fs.ObjectPairChan,
 							} else {
 								// If successful zero out the dst as it is no longer there and copy the file
 								pair.Dst = nil
-								out <- pair
+								s.queueTransfer(out, pair)
 							}
 						} else {
-							out <- pair
+							s.queueTransfer(out, pair)
 						}
 					}
 				} else {
@@ -261,7 +296,7 @@ func (s *syncCopyMove) pairRenamer(in fs.ObjectPairChan, out fs.ObjectPairChan,
 			src := pair.Src
 			if !s.tryRename(src) {
 				// pass on if not renamed
-				out <- pair
+				s.queueTransfer(out, pair)
 			}
 		case <-s.ctx.Done():
 			return
@@ -593,6 +628,9 @@ func (s *syncCopyMove) run() error {
 		return nil
 	}
 
+	// The totals are worked out afresh by each try of the sync
+	accounting.Stats.ResetTotals()
+
 	// Start background checking and transferring pipeline
 	s.startCheckers()
 	s.startRenamers()
@@ -619,6 +657,7 @@ func (s *syncCopyMove) run() error {
 	// Stop background checking and transferring pipeline
 	s.stopCheckers()
 	s.stopRenamers()
+	s.setTotals()
 	s.stopTransfers()
 	s.stopDeleters()
 
@@ -695,7 +734,7 @@ func (s *syncCopyMove) SrcOnly(src fs.DirEntry) (recurse bool) {
 			s.trackRenamesCh <- x
 		} else {
 			// No need to check since doesn't exist
-			s.toBeUploaded <- fs.ObjectPair{Src: x, Dst: nil}
+			s.queueTransfer(s.toBeUploaded, fs.ObjectPair{Src: x, Dst: nil})
 		}
 	case fs.Directory:
 		// Do the same thing to the entire contents of the directory
@@ -3,7 +3,10 @@
 package sync
 
 import (
+	"fmt"
+	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -79,6 +82,58 @@ func TestCopyWithDepth(t *testing.T) {
 	fstest.CheckItems(t, r.Fremote, file2)
 }
 
+// Test the job has an ETA while the files are still being queued for
+// transfer
+func TestCopyETAWhileTransferring(t *testing.T) {
+	r := fstest.NewRun(t)
+	defer r.Finalise()
+	contents := strings.Repeat("x", 128*1024)
+	var files []fstest.Item
+	for i := 1; i <= 4; i++ {
+		files = append(files, r.WriteFile(fmt.Sprintf("file%d", i), contents, t1))
+	}
+	r.Mkdir(r.Fremote)
+
+	// One transfer at a time so the checkers are held up queueing
+	// the files behind it, and no moving average so the speed is
+	// known as soon as the first bytes are read
+	oldTransfers, oldStatsLight := fs.Config.Transfers, fs.Config.StatsLight
+	fs.Config.Transfers, fs.Config.StatsLight = 1, true
+	defer func() {
+		fs.Config.Transfers, fs.Config.StatsLight = oldTransfers, oldStatsLight
+	}()
+
+	// Slow the transfers down so they can be seen in progress
+	remove := accounting.OnTransferStart(func(name string, size int64) {
+		if acc, ok := accounting.LookupTransfer(name); ok {
+			acc.SetBandwidthLimit(512 * 1024)
+		}
+	})
+	defer remove()
+
+	accounting.Stats.ResetCounters()
+	done := make(chan error)
+	go func() {
+		done <- CopyDir(r.Fremote, r.Flocal)
+	}()
+	hasETA := regexp.MustCompile(`(?m)^ETA: +[0-9]`)
+	sawETA := false
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			finished = true
+		case <-time.After(10 * time.Millisecond):
+			if accounting.Stats.GetTransfers() == 0 && hasETA.MatchString(accounting.Stats.String()) {
+				sawETA = true
+			}
+		}
+	}
+	assert.True(t, sawETA, "no ETA while the first file was transferring")
+
+	fstest.CheckItems(t, r.Fremote, files...)
+}
+
 // Test a server side copy if possible, or the backup path if not
 func TestServerSideCopy(t *testing.T) {
 	r := fstest.NewRun(t)
@@ -68,7 +68,7 @@ func (fh *ReadFileHandle) openPending() (err error) {
 	if err != nil {
 		return err
 	}
-	fh.r = accounting.NewAccount(r, o).WithBuffer() // account the transfer
+	fh.r = accounting.NewAccount(r, o).WithBuffer().WithDirection(accounting.TransferDirection(o.Fs(), nil)) // account the transfer
 	fh.opened = true
 	accounting.Stats.Transferring(o.Remote())
 	return nil